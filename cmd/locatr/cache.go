@@ -0,0 +1,438 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/playwright-community/playwright-go"
+	locatr "github.com/vertexcover-io/locatr/pkg"
+	"github.com/vertexcover-io/locatr/pkg/plugins"
+	"github.com/vertexcover-io/locatr/pkg/types"
+)
+
+const (
+	// defaultCachePath matches the cache path used by locatr.EnableCache(nil)
+	defaultCachePath = ".locatr.cache"
+	// defaultLoadTimeout is the page load timeout used by validate, in milliseconds
+	defaultLoadTimeout = 30000.0
+)
+
+const cacheUsage = `Usage: locatr cache <subcommand> [flags] [args]
+
+Subcommands:
+  list                          List the contexts and requests stored in the cache
+  show <url>                    Show the entries cached for a context
+  prune                         Remove entries by age, context or request pattern
+  merge <a> <b> -o <c>          Merge two cache files
+  export -format csv|yaml       Export the cache to CSV or YAML
+  import <file> -format csv|yaml
+                                Import entries from a CSV or YAML export
+  validate                      Check every entry of a web page against the live page with Playwright
+
+Every subcommand accepts -cache <path> (defaults to ` + defaultCachePath + `).
+`
+
+// runCache dispatches the cache subcommands.
+func runCache(args []string, stdout io.Writer) error {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		fmt.Fprint(stdout, cacheUsage)
+		return nil
+	}
+
+	switch args[0] {
+	case "list":
+		return cacheList(args[1:], stdout)
+	case "show":
+		return cacheShow(args[1:], stdout)
+	case "prune":
+		return cachePrune(args[1:], stdout)
+	case "merge":
+		return cacheMerge(args[1:], stdout)
+	case "export":
+		return cacheExport(args[1:], stdout)
+	case "import":
+		return cacheImport(args[1:], stdout)
+	case "validate":
+		return cacheValidate(args[1:], stdout)
+	default:
+		return fmt.Errorf("unknown cache subcommand '%s'\n\n%s", args[0], cacheUsage)
+	}
+}
+
+// newFlagSet creates a flag set for a subcommand with the common -cache flag.
+func newFlagSet(name string) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet("locatr cache "+name, flag.ContinueOnError)
+	cachePath := fs.String("cache", defaultCachePath, "path to the cache file")
+	return fs, cachePath
+}
+
+// parseArgs parses flags that may be interleaved with positional arguments
+// (e.g. `merge a b -o c`) and returns the positional arguments.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	positional := []string{}
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+func cacheList(args []string, stdout io.Writer) error {
+	fs, cachePath := newFlagSet("list")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	cache, err := loadCacheFile(*cachePath)
+	if err != nil {
+		return err
+	}
+
+	writer := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "CONTEXT\tREQUEST\tLOCATOR TYPE\tLOCATORS\tCREATED AT")
+	for _, context := range cache.contexts() {
+		for _, entry := range cache[context] {
+			createdAt := "-"
+			if entry.CreatedAt != nil {
+				createdAt = entry.CreatedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(
+				writer, "%s\t%s\t%s\t%d\t%s\n",
+				context, entry.UserRequest, entry.LocatorType, len(entry.Locators), createdAt,
+			)
+		}
+	}
+	return writer.Flush()
+}
+
+func cacheShow(args []string, stdout io.Writer) error {
+	fs, cachePath := newFlagSet("show")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return errors.New("usage: locatr cache show <url>")
+	}
+	cache, err := loadCacheFile(*cachePath)
+	if err != nil {
+		return err
+	}
+	entries, ok := cache[positional[0]]
+	if !ok {
+		return fmt.Errorf("no entries cached for '%s'", positional[0])
+	}
+	content, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(stdout, string(content))
+	return err
+}
+
+func cachePrune(args []string, stdout io.Writer) error {
+	fs, cachePath := newFlagSet("prune")
+	olderThan := fs.Duration("older-than", 0, "remove entries created longer ago than this duration (e.g. 720h)")
+	contextExpr := fs.String("context", "", "remove entries whose context (URL) matches this regular expression")
+	patternExpr := fs.String("pattern", "", "remove entries whose user request matches this regular expression")
+	dryRun := fs.Bool("dry-run", false, "report the number of matching entries without modifying the cache")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+
+	filter := &pruneFilter{olderThan: *olderThan}
+	if *contextExpr != "" {
+		expr, err := regexp.Compile(*contextExpr)
+		if err != nil {
+			return fmt.Errorf("invalid -context expression: %v", err)
+		}
+		filter.context = expr
+	}
+	if *patternExpr != "" {
+		expr, err := regexp.Compile(*patternExpr)
+		if err != nil {
+			return fmt.Errorf("invalid -pattern expression: %v", err)
+		}
+		filter.pattern = expr
+	}
+	if filter.isEmpty() {
+		return errors.New("at least one of -older-than, -context or -pattern is required")
+	}
+
+	cache, err := loadCacheFile(*cachePath)
+	if err != nil {
+		return err
+	}
+	removed := cache.prune(filter, time.Now())
+	if *dryRun {
+		fmt.Fprintf(stdout, "%d entries would be removed\n", removed)
+		return nil
+	}
+	if err := saveCacheFile(*cachePath, cache); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Removed %d entries\n", removed)
+	return nil
+}
+
+func cacheMerge(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("locatr cache merge", flag.ContinueOnError)
+	output := fs.String("o", "", "path of the merged cache file")
+	strategy := fs.String("strategy", string(mergeNewest), "conflict resolution strategy: ours, theirs or newest")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 2 || *output == "" {
+		return errors.New("usage: locatr cache merge <a> <b> -o <c> [-strategy ours|theirs|newest]")
+	}
+
+	ours, err := loadCacheFile(positional[0])
+	if err != nil {
+		return err
+	}
+	theirs, err := loadCacheFile(positional[1])
+	if err != nil {
+		return err
+	}
+	merged, conflicts, err := mergeCacheFiles(ours, theirs, mergeStrategy(*strategy))
+	if err != nil {
+		return err
+	}
+	for _, conflict := range conflicts {
+		fmt.Fprintf(
+			stdout, "conflict: %s: '%s' (kept %s)\n", conflict.Context, conflict.UserRequest, conflict.Kept,
+		)
+	}
+	if err := saveCacheFile(*output, merged); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Merged into %s with %d conflicts\n", *output, len(conflicts))
+	return nil
+}
+
+func cacheExport(args []string, stdout io.Writer) error {
+	fs, cachePath := newFlagSet("export")
+	format := fs.String("format", "yaml", "export format: csv or yaml")
+	output := fs.String("o", "", "path of the exported file, defaults to stdout")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	cache, err := loadCacheFile(*cachePath)
+	if err != nil {
+		return err
+	}
+
+	writer := stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		writer = file
+	}
+	return exportCache(cache, *format, writer)
+}
+
+func cacheImport(args []string, stdout io.Writer) error {
+	fs, cachePath := newFlagSet("import")
+	format := fs.String("format", "", "import format: csv or yaml, inferred from the file extension if omitted")
+	strategy := fs.String("strategy", string(mergeTheirs), "conflict resolution strategy with existing entries: ours, theirs or newest")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return errors.New("usage: locatr cache import <file> [-format csv|yaml]")
+	}
+
+	if *format == "" {
+		switch {
+		case strings.HasSuffix(positional[0], ".csv"):
+			*format = "csv"
+		case strings.HasSuffix(positional[0], ".yaml"), strings.HasSuffix(positional[0], ".yml"):
+			*format = "yaml"
+		default:
+			return errors.New("couldn't infer the import format, pass -format csv|yaml")
+		}
+	}
+
+	file, err := os.Open(positional[0])
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	imported, err := importCache(file, *format)
+	if err != nil {
+		return err
+	}
+
+	existing, err := loadCacheFile(*cachePath)
+	if err != nil {
+		return err
+	}
+	merged, conflicts, err := mergeCacheFiles(existing, imported, mergeStrategy(*strategy))
+	if err != nil {
+		return err
+	}
+	if err := saveCacheFile(*cachePath, merged); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Imported %s into %s with %d conflicts\n", positional[0], *cachePath, len(conflicts))
+	return nil
+}
+
+func cacheValidate(args []string, stdout io.Writer) error {
+	fs, cachePath := newFlagSet("validate")
+	browserName := fs.String("browser", "chromium", "browser to use: chromium, firefox or webkit")
+	headless := fs.Bool("headless", true, "run the browser in headless mode")
+	timeout := fs.Float64("timeout", defaultLoadTimeout, "page load timeout in milliseconds")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	cache, err := loadCacheFile(*cachePath)
+	if err != nil {
+		return err
+	}
+
+	pw, err := playwright.Run()
+	if err != nil {
+		return fmt.Errorf("could not start playwright: %v", err)
+	}
+	defer pw.Stop()
+
+	var browserType playwright.BrowserType
+	switch *browserName {
+	case "chromium":
+		browserType = pw.Chromium
+	case "firefox":
+		browserType = pw.Firefox
+	case "webkit":
+		browserType = pw.WebKit
+	default:
+		return fmt.Errorf("unsupported browser '%s'", *browserName)
+	}
+	browser, err := browserType.Launch(playwright.BrowserTypeLaunchOptions{Headless: headless})
+	if err != nil {
+		return fmt.Errorf("could not launch browser: %v", err)
+	}
+	defer browser.Close()
+
+	open := func(url string) (types.PluginInterface, func(), error) {
+		page, err := browser.NewPage()
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not create page: %v", err)
+		}
+		if _, err := page.Goto(url, playwright.PageGotoOptions{
+			WaitUntil: playwright.WaitUntilStateLoad, Timeout: timeout,
+		}); err != nil {
+			page.Close()
+			return nil, nil, fmt.Errorf("%w: %v", errPageLoad, err)
+		}
+		plugin, err := plugins.NewPlaywrightPlugin(&page)
+		if err != nil {
+			page.Close()
+			return nil, nil, fmt.Errorf("could not create playwright plugin: %v", err)
+		}
+		return plugin, func() { page.Close() }, nil
+	}
+
+	checked, broken, skipped, err := validateCache(context.Background(), cache, open, stdout)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "\n%d entries checked, %d broken, %d skipped\n", checked, broken, skipped)
+	if broken > 0 {
+		return fmt.Errorf("%d broken cache entries", broken)
+	}
+	return nil
+}
+
+// errPageLoad is returned by a pageOpener when the page of a context fails to load, marking its entries as broken.
+// Other errors abort the validation.
+var errPageLoad = errors.New("page failed to load")
+
+// pageOpener loads the page of a URL and returns a plugin for it, along with a function closing the page.
+type pageOpener func(url string) (types.PluginInterface, func(), error)
+
+// skipReason returns why the entries of a cache context can't be validated against a web page,
+// or an empty string if the context is an http(s) URL.
+func skipReason(context string) string {
+	switch {
+	case strings.HasPrefix(context, locatr.StructureCacheKeyPrefix):
+		return "DOM structure key, no page to load"
+	case strings.HasPrefix(context, "http://") || strings.HasPrefix(context, "https://"):
+		return ""
+	default:
+		return "not a web page URL"
+	}
+}
+
+// validateCache checks the entries of every http(s) context of the cache against its live page and writes
+// a report. The entries of other contexts, like Appium activities and DOM structure keys, are reported as skipped.
+//
+// Parameters:
+//   - cache: The cache to validate
+//   - open: Opens the page of a context
+//   - stdout: Where the report is written
+//
+// Returns the number of checked, broken and skipped entries, or an error if a page can't be opened
+// for another reason than a failed load or the report can't be written.
+func validateCache(ctx context.Context, cache cacheFile, open pageOpener, stdout io.Writer) (int, int, int, error) {
+	writer := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "CONTEXT\tREQUEST\tSTATUS")
+	checked, broken, skipped := 0, 0, 0
+	for _, key := range cache.contexts() {
+		if reason := skipReason(key); reason != "" {
+			for _, entry := range cache[key] {
+				fmt.Fprintf(writer, "%s\t%s\tskipped (%s)\n", key, entry.UserRequest, reason)
+				skipped++
+			}
+			continue
+		}
+
+		plugin, closePage, err := open(key)
+		if err != nil && !errors.Is(err, errPageLoad) {
+			return checked, broken, skipped, err
+		}
+		if err != nil {
+			for _, entry := range cache[key] {
+				fmt.Fprintf(writer, "%s\t%s\tbroken (%v)\n", key, entry.UserRequest, err)
+				checked++
+				broken++
+			}
+			continue
+		}
+		for _, entry := range cache[key] {
+			checked++
+			validLocators := 0
+			for _, locator := range entry.Locators {
+				if ok, err := plugin.IsLocatorValid(ctx, locator); err == nil && ok {
+					validLocators++
+				}
+			}
+			if validLocators == 0 {
+				broken++
+				fmt.Fprintf(writer, "%s\t%s\tbroken\n", key, entry.UserRequest)
+			} else {
+				fmt.Fprintf(writer, "%s\t%s\tok (%d/%d locators valid)\n", key, entry.UserRequest, validLocators, len(entry.Locators))
+			}
+		}
+		closePage()
+	}
+	return checked, broken, skipped, writer.Flush()
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vertexcover-io/locatr/pkg/types"
+)
+
+// validatorPlugin is a plugin whose valid locators are known in advance.
+type validatorPlugin struct {
+	types.PluginInterface
+	valid map[string]bool
+}
+
+func (p *validatorPlugin) IsLocatorValid(ctx context.Context, locator string) (bool, error) {
+	return p.valid[locator], nil
+}
+
+func TestValidateCache(t *testing.T) {
+	cache := cacheFile{
+		"https://example.com/login": {
+			{UserRequest: "login button", Locators: []string{"#login", "#old-login"}},
+			{UserRequest: "signup link", Locators: []string{"#signup"}},
+		},
+		"https://example.com/down":            {{UserRequest: "logo", Locators: []string{"#logo"}}},
+		"com.android.deskclock.DeskClock":     {{UserRequest: "alarm tab", Locators: []string{"//tab"}}},
+		"com.android.settings/.Settings$Wifi": {{UserRequest: "wifi toggle", Locators: []string{"//switch"}}},
+		"file:///tmp/fixture.html":            {{UserRequest: "fixture", Locators: []string{"#fixture"}}},
+		"https://example.com/empty":           {},
+		"dom-structure:9f2c4e1a":              {{UserRequest: "checkout button", Locators: []string{"#checkout"}}},
+	}
+	opened := []string{}
+	open := func(url string) (types.PluginInterface, func(), error) {
+		opened = append(opened, url)
+		if strings.HasSuffix(url, "/down") {
+			return nil, nil, fmt.Errorf("%w: timeout", errPageLoad)
+		}
+		return &validatorPlugin{valid: map[string]bool{"#login": true}}, func() {}, nil
+	}
+
+	var report bytes.Buffer
+	checked, broken, skipped, err := validateCache(context.Background(), cache, open, &report)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, checked)
+	assert.Equal(t, 2, broken)
+	assert.Equal(t, 4, skipped)
+	assert.Equal(t, []string{"https://example.com/down", "https://example.com/empty", "https://example.com/login"}, opened)
+
+	output := report.String()
+	assert.Regexp(t, `com\.android\.deskclock\.DeskClock\s+alarm tab\s+skipped \(not a web page URL\)`, output)
+	assert.Regexp(t, `dom-structure:9f2c4e1a\s+checkout button\s+skipped \(DOM structure key, no page to load\)`, output)
+	assert.Regexp(t, `file:///tmp/fixture\.html\s+fixture\s+skipped`, output)
+	assert.Regexp(t, `https://example\.com/down\s+logo\s+broken \(page failed to load: timeout\)`, output)
+	assert.Regexp(t, `login button\s+ok \(1/2 locators valid\)`, output)
+	assert.Regexp(t, `signup link\s+broken\n`, output)
+
+	t.Run("aborts when a page can't be opened", func(t *testing.T) {
+		open := func(url string) (types.PluginInterface, func(), error) {
+			return nil, nil, errors.New("browser crashed")
+		}
+		_, _, _, err := validateCache(context.Background(), cache, open, &bytes.Buffer{})
+		assert.EqualError(t, err, "browser crashed")
+	})
+}
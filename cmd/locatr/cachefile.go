@@ -0,0 +1,341 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vertexcover-io/locatr/pkg/types"
+	"gopkg.in/yaml.v3"
+)
+
+// cacheFile is the in-memory representation of a locatr cache file, keyed by context (URL or activity).
+type cacheFile map[string][]types.CacheEntry
+
+// loadCacheFile reads and deserializes the cache file at the given path.
+// A missing or empty file results in an empty cache.
+func loadCacheFile(path string) (cacheFile, error) {
+	cache := cacheFile{}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cache, nil
+		}
+		return nil, fmt.Errorf("couldn't read cache file: %v", err)
+	}
+	if len(content) == 0 {
+		return cache, nil
+	}
+	if err := json.Unmarshal(content, &cache); err != nil {
+		return nil, fmt.Errorf("couldn't parse cache file '%s': %v", path, err)
+	}
+	return cache, nil
+}
+
+// saveCacheFile serializes and writes the cache to the given path.
+func saveCacheFile(path string, cache cacheFile) error {
+	content, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, content, 0644)
+}
+
+// contexts returns the contexts of the cache in sorted order.
+func (c cacheFile) contexts() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// pruneFilter selects the cache entries to remove. All the set criteria must match for an entry to be pruned.
+type pruneFilter struct {
+	olderThan time.Duration  // Entries created before now - olderThan
+	context   *regexp.Regexp // Entries whose context matches the expression
+	pattern   *regexp.Regexp // Entries whose user request matches the expression
+}
+
+func (f *pruneFilter) isEmpty() bool {
+	return f.olderThan == 0 && f.context == nil && f.pattern == nil
+}
+
+func (f *pruneFilter) matches(context string, entry types.CacheEntry, now time.Time) bool {
+	if f.context != nil && !f.context.MatchString(context) {
+		return false
+	}
+	if f.pattern != nil && !f.pattern.MatchString(entry.UserRequest) {
+		return false
+	}
+	if f.olderThan > 0 {
+		// Entries written before timestamps were recorded have an unknown age and are kept
+		if entry.CreatedAt == nil || now.Sub(*entry.CreatedAt) < f.olderThan {
+			return false
+		}
+	}
+	return true
+}
+
+// prune removes the entries selected by the filter and returns the number of removed entries.
+func (c cacheFile) prune(filter *pruneFilter, now time.Time) int {
+	removed := 0
+	for _, context := range c.contexts() {
+		kept := []types.CacheEntry{}
+		for _, entry := range c[context] {
+			if filter.matches(context, entry, now) {
+				removed++
+				continue
+			}
+			kept = append(kept, entry)
+		}
+		if len(kept) == 0 {
+			delete(c, context)
+		} else {
+			c[context] = kept
+		}
+	}
+	return removed
+}
+
+// mergeStrategy decides which entry wins when both caches hold different locators for the same request.
+type mergeStrategy string
+
+const (
+	mergeOurs   mergeStrategy = "ours"   // Keep the entry from the first cache
+	mergeTheirs mergeStrategy = "theirs" // Keep the entry from the second cache
+	mergeNewest mergeStrategy = "newest" // Keep the most recently created entry
+)
+
+// mergeConflict describes a request that had different locators in the merged caches.
+type mergeConflict struct {
+	Context     string
+	UserRequest string
+	Kept        string
+}
+
+// mergeCacheFiles merges two caches into a new one, resolving conflicts with the given strategy.
+func mergeCacheFiles(ours, theirs cacheFile, strategy mergeStrategy) (cacheFile, []mergeConflict, error) {
+	if !slices.Contains([]mergeStrategy{mergeOurs, mergeTheirs, mergeNewest}, strategy) {
+		return nil, nil, fmt.Errorf("invalid merge strategy '%s', expected 'ours', 'theirs' or 'newest'", strategy)
+	}
+
+	merged := cacheFile{}
+	for context, entries := range ours {
+		merged[context] = slices.Clone(entries)
+	}
+
+	conflicts := []mergeConflict{}
+	for _, context := range theirs.contexts() {
+		for _, theirEntry := range theirs[context] {
+			index := slices.IndexFunc(merged[context], func(e types.CacheEntry) bool {
+				return e.UserRequest == theirEntry.UserRequest
+			})
+			if index == -1 {
+				merged[context] = append(merged[context], theirEntry)
+				continue
+			}
+
+			ourEntry := merged[context][index]
+			if ourEntry.LocatorType == theirEntry.LocatorType && slices.Equal(ourEntry.Locators, theirEntry.Locators) {
+				continue
+			}
+
+			kept := mergeOurs
+			switch strategy {
+			case mergeTheirs:
+				kept = mergeTheirs
+			case mergeNewest:
+				if theirEntry.CreatedAt != nil && (ourEntry.CreatedAt == nil || theirEntry.CreatedAt.After(*ourEntry.CreatedAt)) {
+					kept = mergeTheirs
+				}
+			}
+			if kept == mergeTheirs {
+				merged[context][index] = theirEntry
+			}
+			conflicts = append(conflicts, mergeConflict{
+				Context: context, UserRequest: theirEntry.UserRequest, Kept: string(kept),
+			})
+		}
+	}
+	return merged, conflicts, nil
+}
+
+// cacheRecord is the flattened representation of a cache entry used for CSV and YAML exports.
+type cacheRecord struct {
+	Context     string         `yaml:"context"`
+	UserRequest string         `yaml:"user_request"`
+	LocatorType string         `yaml:"locator_type"`
+	Locators    []string       `yaml:"locators"`
+	CreatedAt   *time.Time     `yaml:"created_at,omitempty"`
+	Fingerprint map[string]any `yaml:"fingerprint,omitempty"`
+}
+
+var csvHeader = []string{"context", "user_request", "locator_type", "locators", "created_at", "fingerprint"}
+
+// toRecords flattens the cache into records ordered by context.
+func (c cacheFile) toRecords() ([]cacheRecord, error) {
+	records := []cacheRecord{}
+	for _, context := range c.contexts() {
+		for _, entry := range c[context] {
+			record := cacheRecord{
+				Context:     context,
+				UserRequest: entry.UserRequest,
+				LocatorType: entry.LocatorType,
+				Locators:    entry.Locators,
+				CreatedAt:   entry.CreatedAt,
+			}
+			if entry.Fingerprint != nil {
+				content, err := json.Marshal(entry.Fingerprint)
+				if err != nil {
+					return nil, err
+				}
+				if err := json.Unmarshal(content, &record.Fingerprint); err != nil {
+					return nil, err
+				}
+			}
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+// fromRecords builds a cache from flattened records.
+func fromRecords(records []cacheRecord) (cacheFile, error) {
+	cache := cacheFile{}
+	for _, record := range records {
+		if record.Context == "" || record.UserRequest == "" {
+			return nil, errors.New("every record requires a context and a user request")
+		}
+		entry := types.CacheEntry{
+			UserRequest: record.UserRequest,
+			Locators:    record.Locators,
+			LocatorType: record.LocatorType,
+			CreatedAt:   record.CreatedAt,
+		}
+		if record.Fingerprint != nil {
+			content, err := json.Marshal(record.Fingerprint)
+			if err != nil {
+				return nil, err
+			}
+			if err := json.Unmarshal(content, &entry.Fingerprint); err != nil {
+				return nil, fmt.Errorf("invalid fingerprint for '%s': %v", record.UserRequest, err)
+			}
+		}
+		cache[record.Context] = append(cache[record.Context], entry)
+	}
+	return cache, nil
+}
+
+// exportCache writes the cache to the writer in the given format ("csv" or "yaml").
+func exportCache(cache cacheFile, format string, writer io.Writer) error {
+	records, err := cache.toRecords()
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case "yaml":
+		encoder := yaml.NewEncoder(writer)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(records); err != nil {
+			return err
+		}
+		return encoder.Close()
+	case "csv":
+		csvWriter := csv.NewWriter(writer)
+		if err := csvWriter.Write(csvHeader); err != nil {
+			return err
+		}
+		for _, record := range records {
+			createdAt, fingerprint := "", ""
+			if record.CreatedAt != nil {
+				createdAt = record.CreatedAt.Format(time.RFC3339)
+			}
+			if record.Fingerprint != nil {
+				content, err := json.Marshal(record.Fingerprint)
+				if err != nil {
+					return err
+				}
+				fingerprint = string(content)
+			}
+			if err := csvWriter.Write([]string{
+				record.Context,
+				record.UserRequest,
+				record.LocatorType,
+				strings.Join(record.Locators, "\n"),
+				createdAt,
+				fingerprint,
+			}); err != nil {
+				return err
+			}
+		}
+		csvWriter.Flush()
+		return csvWriter.Error()
+	default:
+		return fmt.Errorf("unsupported format '%s', expected 'csv' or 'yaml'", format)
+	}
+}
+
+// importCache reads a cache from the reader in the given format ("csv" or "yaml").
+func importCache(reader io.Reader, format string) (cacheFile, error) {
+	records := []cacheRecord{}
+
+	switch format {
+	case "yaml":
+		if err := yaml.NewDecoder(reader).Decode(&records); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("couldn't parse YAML: %v", err)
+		}
+	case "csv":
+		rows, err := csv.NewReader(reader).ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("couldn't parse CSV: %v", err)
+		}
+		if len(rows) == 0 {
+			return cacheFile{}, nil
+		}
+		if !slices.Equal(rows[0], csvHeader) {
+			return nil, fmt.Errorf("unexpected CSV header, expected: %s", strings.Join(csvHeader, ","))
+		}
+		for line, row := range rows[1:] {
+			record := cacheRecord{
+				Context:     row[0],
+				UserRequest: row[1],
+				LocatorType: row[2],
+				Locators:    []string{},
+			}
+			if row[3] != "" {
+				record.Locators = strings.Split(row[3], "\n")
+			}
+			if row[4] != "" {
+				createdAt, err := time.Parse(time.RFC3339, row[4])
+				if err != nil {
+					return nil, fmt.Errorf("invalid created_at on line %d: %v", line+2, err)
+				}
+				record.CreatedAt = &createdAt
+			}
+			if row[5] != "" {
+				if err := json.Unmarshal([]byte(row[5]), &record.Fingerprint); err != nil {
+					return nil, fmt.Errorf("invalid fingerprint on line %d: %v", line+2, err)
+				}
+			}
+			records = append(records, record)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported format '%s', expected 'csv' or 'yaml'", format)
+	}
+	return fromRecords(records)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vertexcover-io/locatr/pkg/types"
+)
+
+func timeAt(t time.Time) *time.Time {
+	return &t
+}
+
+func TestCacheFile_Prune(t *testing.T) {
+	now := time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC)
+	newCache := func() cacheFile {
+		return cacheFile{
+			"https://example.com": {
+				{UserRequest: "login button", Locators: []string{"#login"}, CreatedAt: timeAt(now.Add(-48 * time.Hour))},
+				{UserRequest: "search input", Locators: []string{"#search"}, CreatedAt: timeAt(now.Add(-1 * time.Hour))},
+				{UserRequest: "legacy entry", Locators: []string{"#legacy"}},
+			},
+			"https://example.org": {
+				{UserRequest: "login button", Locators: []string{"#signin"}, CreatedAt: timeAt(now.Add(-1 * time.Hour))},
+			},
+		}
+	}
+
+	tests := []struct {
+		name            string
+		filter          *pruneFilter
+		expectedRemoved int
+		expectedLeft    map[string]int
+	}{
+		{
+			name:            "by age keeps entries without timestamp",
+			filter:          &pruneFilter{olderThan: 24 * time.Hour},
+			expectedRemoved: 1,
+			expectedLeft:    map[string]int{"https://example.com": 2, "https://example.org": 1},
+		},
+		{
+			name:            "by context removes empty contexts",
+			filter:          &pruneFilter{context: regexp.MustCompile(`example\.org`)},
+			expectedRemoved: 1,
+			expectedLeft:    map[string]int{"https://example.com": 3},
+		},
+		{
+			name:            "by pattern and context",
+			filter:          &pruneFilter{pattern: regexp.MustCompile(`^login`), context: regexp.MustCompile(`\.com$`)},
+			expectedRemoved: 1,
+			expectedLeft:    map[string]int{"https://example.com": 2, "https://example.org": 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := newCache()
+			assert.Equal(t, tt.expectedRemoved, cache.prune(tt.filter, now))
+			left := map[string]int{}
+			for context, entries := range cache {
+				left[context] = len(entries)
+			}
+			assert.Equal(t, tt.expectedLeft, left)
+		})
+	}
+}
+
+func TestMergeCacheFiles(t *testing.T) {
+	older := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := older.Add(time.Hour)
+	ours := cacheFile{
+		"https://example.com": {
+			{UserRequest: "login button", Locators: []string{"#login"}, CreatedAt: &newer},
+			{UserRequest: "search input", Locators: []string{"#search"}, CreatedAt: &older},
+		},
+	}
+	theirs := cacheFile{
+		"https://example.com": {
+			{UserRequest: "login button", Locators: []string{"#login-v2"}, CreatedAt: &older},
+			{UserRequest: "search input", Locators: []string{"#search-v2"}, CreatedAt: &newer},
+			{UserRequest: "cart", Locators: []string{"#cart"}},
+		},
+		"https://example.org": {
+			{UserRequest: "cart", Locators: []string{"#cart"}},
+		},
+	}
+
+	tests := []struct {
+		strategy         mergeStrategy
+		expectedLogin    string
+		expectedSearch   string
+		expectedConflict int
+	}{
+		{strategy: mergeOurs, expectedLogin: "#login", expectedSearch: "#search", expectedConflict: 2},
+		{strategy: mergeTheirs, expectedLogin: "#login-v2", expectedSearch: "#search-v2", expectedConflict: 2},
+		{strategy: mergeNewest, expectedLogin: "#login", expectedSearch: "#search-v2", expectedConflict: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.strategy), func(t *testing.T) {
+			merged, conflicts, err := mergeCacheFiles(ours, theirs, tt.strategy)
+			assert.NoError(t, err)
+			assert.Len(t, conflicts, tt.expectedConflict)
+			assert.Len(t, merged["https://example.com"], 3)
+			assert.Len(t, merged["https://example.org"], 1)
+			assert.Equal(t, tt.expectedLogin, merged["https://example.com"][0].Locators[0])
+			assert.Equal(t, tt.expectedSearch, merged["https://example.com"][1].Locators[0])
+		})
+	}
+
+	// The inputs must be left untouched
+	assert.Equal(t, "#login", ours["https://example.com"][0].Locators[0])
+
+	_, _, err := mergeCacheFiles(ours, theirs, "unknown")
+	assert.Error(t, err)
+}
+
+func TestExportImportCache(t *testing.T) {
+	createdAt := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	cache := cacheFile{
+		"https://example.com": {
+			{
+				UserRequest: "login button",
+				Locators:    []string{"#login", "form > button:nth-of-type(1)"},
+				LocatorType: types.CssSelectorType,
+				CreatedAt:   &createdAt,
+				Fingerprint: &types.ElementFingerprint{
+					TagName:             "button",
+					Text:                "Log in, now",
+					Attributes:          map[string]string{"type": "submit"},
+					SupportedPrimitives: "click",
+				},
+			},
+		},
+		"com.example/.MainActivity": {
+			{UserRequest: "menu", Locators: []string{"//*[@content-desc='Menu']"}, LocatorType: types.XPathType},
+		},
+	}
+
+	for _, format := range []string{"csv", "yaml"} {
+		t.Run(format, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			assert.NoError(t, exportCache(cache, format, buf))
+
+			imported, err := importCache(buf, format)
+			assert.NoError(t, err)
+			assert.Equal(t, cache, imported)
+		})
+	}
+
+	assert.Error(t, exportCache(cache, "xml", new(bytes.Buffer)))
+}
@@ -0,0 +1,43 @@
+// Command locatr provides command-line utilities for working with locatr artifacts.
+//
+// Usage:
+//
+//	locatr cache <subcommand> [flags] [args]
+//
+// Run `locatr cache help` to list the available cache subcommands.
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+const usage = `Usage: locatr <command> [arguments]
+
+Commands:
+  cache    Manage locatr cache files
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "cache":
+		err = runCache(os.Args[2:], os.Stdout)
+	case "help", "-h", "--help":
+		fmt.Fprint(os.Stdout, usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -5,8 +5,10 @@ go 1.23.0
 toolchain go1.23.5
 
 require (
+	github.com/HugoSmits86/nativewebp v0.9.3
 	github.com/antchfx/xmlquery v1.4.4
 	github.com/anthropics/anthropic-sdk-go v0.2.0-alpha.13
+	github.com/aws/aws-sdk-go-v2 v1.30.3
 	github.com/cohere-ai/cohere-go/v2 v2.12.0
 	github.com/go-resty/resty/v2 v2.16.5
 	github.com/kaptinlin/jsonrepair v0.1.0
@@ -14,6 +16,7 @@ require (
 	github.com/playwright-community/playwright-go v0.4501.1
 	github.com/stretchr/testify v1.9.0
 	github.com/vertexcover-io/selenium v0.0.0-20241204163435-6f7f74f598ec
+	golang.org/x/image v0.25.0
 	golang.org/x/net v0.37.0
 	gopkg.in/validator.v2 v2.0.1
 	gopkg.in/yaml.v3 v3.0.1
@@ -21,7 +24,6 @@ require (
 
 require (
 	github.com/antchfx/xpath v1.3.3 // indirect
-	github.com/aws/aws-sdk-go-v2 v1.30.3 // indirect
 	github.com/aws/smithy-go v1.20.3 // indirect
 	github.com/blang/semver v3.5.1+incompatible // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
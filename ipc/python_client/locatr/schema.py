@@ -27,6 +27,10 @@ class LlmProvider(str, Enum):
     ANTHROPIC = "anthropic"
     OPEN_ROUTER = "open-router"
     GROQ = "groq"
+    OPENAI_COMPATIBLE = "openai-compatible"
+    GEMINI = "gemini"
+    AZURE_OPENAI = "azure-openai"
+    BEDROCK = "bedrock"
 
 
 class PluginType(str, Enum):
@@ -59,6 +63,10 @@ class LocatrOutput(LocatrBaseOutputMessage):
 class LlmSettings(BaseModel):
     llm_provider: Optional[LlmProvider] = Field(default=None)
     llm_api_key: Optional[str] = Field(default=None)
+    llm_base_url: Optional[str] = Field(default=None)
+    azure_deployment: Optional[str] = Field(default=None)
+    azure_api_version: Optional[str] = Field(default=None)
+    aws_region: Optional[str] = Field(default=None)
     model_name: Optional[str] = Field(default=None)
     reranker_api_key: Optional[str] = Field(default=None)
 
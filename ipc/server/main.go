@@ -71,21 +71,34 @@ func handleInitialHandshake(message incomingMessage, logger *slog.Logger) error
 		if err != nil {
 			return fmt.Errorf("could not create selenium plugin: %v", err)
 		}
-		plugin = NewCachedPlugin(plugin)
 	case "appium":
 		plugin, err = plugins.NewAppiumPlugin(settings.AppiumUrl, settings.AppiumSessionId)
 		if err != nil {
 			return fmt.Errorf("unable to create appium plugin: %w", err)
 		}
-		plugin = NewCachedPlugin(plugin)
 	}
+	plugin = plugins.NewCachedPlugin(plugin)
 
 	llmSettings := settings.LlmSettings
-	llmClient, err := llm.NewLLMClient(
+	llmOptions := []llm.Option{
 		llm.WithProvider(types.LLMProvider(llmSettings.LlmProvider)),
 		llm.WithModel(llmSettings.ModelName),
 		llm.WithAPIKey(llmSettings.LlmApiKey),
-	)
+	}
+	if llmSettings.LlmBaseUrl != "" {
+		llmOptions = append(llmOptions, llm.WithBaseURL(llmSettings.LlmBaseUrl))
+	}
+	if llmSettings.AzureDeployment != "" {
+		llmOptions = append(llmOptions, llm.WithAzureDeployment(llmSettings.AzureDeployment))
+	}
+	if llmSettings.AzureApiVersion != "" {
+		llmOptions = append(llmOptions, llm.WithAzureAPIVersion(llmSettings.AzureApiVersion))
+	}
+	if llmSettings.AwsRegion != "" {
+		// AWS credentials are read from the server's environment
+		llmOptions = append(llmOptions, llm.WithAWSRegion(llmSettings.AwsRegion))
+	}
+	llmClient, err := llm.NewLLMClient(llmOptions...)
 	if err != nil {
 		return fmt.Errorf("unable to create llm client: %w", err)
 	}
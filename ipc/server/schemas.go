@@ -1,10 +1,14 @@
 package main
 
 type llmSettings struct {
-	LlmProvider    string `json:"llm_provider"`
-	LlmApiKey      string `json:"llm_api_key"`
-	ModelName      string `json:"model_name"`
-	ReRankerApiKey string `json:"reranker_api_key"`
+	LlmProvider     string `json:"llm_provider"`
+	LlmApiKey       string `json:"llm_api_key"`
+	LlmBaseUrl      string `json:"llm_base_url"`
+	AzureDeployment string `json:"azure_deployment"`
+	AzureApiVersion string `json:"azure_api_version"`
+	AwsRegion       string `json:"aws_region"`
+	ModelName       string `json:"model_name"`
+	ReRankerApiKey  string `json:"reranker_api_key"`
 }
 
 type locatrSettings struct {
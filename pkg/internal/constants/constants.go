@@ -1,10 +1,28 @@
 package constants
 
-import _ "embed"
+import (
+	_ "embed"
+	"time"
+)
 
 // Default cache path
 const DEFAULT_CACHE_PATH = ".locatr.cache"
 
+// DEFAULT_DRIFT_THRESHOLD is the default minimum fingerprint similarity for a cached locator to be considered a hit
+const DEFAULT_DRIFT_THRESHOLD = 0.6
+
+// DEFAULT_SNAPSHOT_TTL is the default maximum age of a cached DOM snapshot
+const DEFAULT_SNAPSHOT_TTL = 10 * time.Second
+
+// DEFAULT_LLM_MAX_RETRIES is the default number of times a failed LLM request is retried
+const DEFAULT_LLM_MAX_RETRIES = 3
+
+// DEFAULT_LLM_INITIAL_BACKOFF is the default delay before the first retry of a failed LLM request
+const DEFAULT_LLM_INITIAL_BACKOFF = 500 * time.Millisecond
+
+// DEFAULT_LLM_MAX_BACKOFF is the default maximum delay between retries of a failed LLM request
+const DEFAULT_LLM_MAX_BACKOFF = 30 * time.Second
+
 // DEFAULT_CHUNK_SIZE is the default maximum size of a dom chunk
 const DEFAULT_CHUNK_SIZE = 4000
 
@@ -17,9 +35,21 @@ const DEFAULT_MAX_ATTEMPTS = 3
 // DEFAULT_CHUNKS_PER_ATTEMPT is the default number of chunks than can be processed in a single attempt of Id completion request
 const DEFAULT_CHUNKS_PER_ATTEMPT = 3
 
+// DEFAULT_MAX_TURNS is the default maximum number of turns of a conversation with the LLM, in which failed attempts are fed back to it
+const DEFAULT_MAX_TURNS = 3
+
 // DEFAULT_TOP_N is the default number of chunks that will be returned from the reranker
 const DEFAULT_TOP_N = 10
 
+// DEFAULT_EMBEDDING_CACHE_SIZE is the default maximum number of embeddings kept in memory by an embeddings reranker
+const DEFAULT_EMBEDDING_CACHE_SIZE = 10000
+
+// DEFAULT_LLM_RERANK_BATCH_SIZE is the default maximum number of chunks scored by a single completion of an LLM reranker
+const DEFAULT_LLM_RERANK_BATCH_SIZE = 50
+
+// DEFAULT_RERANK_CONCURRENCY is the default maximum number of batches of a rerank request ranked at the same time
+const DEFAULT_RERANK_CONCURRENCY = 4
+
 //go:embed meta/script.js
 var JS_CONTENT string
 
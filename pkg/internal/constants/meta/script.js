@@ -605,10 +605,42 @@ async function getLocation(locator) {
 	}, null, 2);
 }
 
+/**
+ * Random token identifying the current script attachment. It changes whenever the
+ * script is attached again (e.g. after a navigation), invalidating older DOM versions.
+ * @type {string}
+ */
+window.locatrSnapshotToken = Math.random().toString(36).slice(2);
+
+/**
+ * Number of DOM mutations observed since the script was attached.
+ * @type {number}
+ */
+window.locatrMutationCount = 0;
+
+new MutationObserver((mutations) => {
+	window.locatrMutationCount += mutations.length;
+}).observe(document.documentElement, {
+	attributes: true,
+	characterData: true,
+	childList: true,
+	subtree: true,
+});
+
+/**
+ * Gets the version of the DOM. The version changes whenever the DOM is mutated
+ * or the page is reloaded.
+ * @returns {string} The DOM version.
+ */
+function getDOMVersion() {
+	return `${window.locatrSnapshotToken}:${window.locatrMutationCount}`;
+}
+
 window.minifyHTML = minifyHTML;
 window.createLocatorMap = createLocatorMap;
 window.isLocatorValid = isLocatorValid;
 window.getLocators = getLocators;
 window.getLocation = getLocation;
+window.getDOMVersion = getDOMVersion;
 
 window.locatrScriptAttached = true;
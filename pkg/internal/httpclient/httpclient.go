@@ -0,0 +1,94 @@
+// Package httpclient builds the HTTP clients shared by the LLM and reranker clients.
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// Config describes how to build an HTTP client.
+type Config struct {
+	// Client is used as is when set, all the other settings are ignored
+	Client *http.Client
+	// Transport is the round tripper to use, defaults to a clone of http.DefaultTransport
+	Transport http.RoundTripper
+	// ProxyURL is the proxy to send requests through, defaults to the HTTP_PROXY/HTTPS_PROXY/NO_PROXY environment variables
+	ProxyURL string
+	// CABundlePath is the path of a PEM file with additional root certificates to trust
+	CABundlePath string
+	// Timeout is the time limit of requests made by the client, zero means no timeout
+	Timeout time.Duration
+}
+
+// New builds an HTTP client from the given configuration.
+// The client should be built once and reused, so that connections are pooled.
+//
+// Parameters:
+//   - cfg: The HTTP client configuration
+//
+// Returns:
+//   - *http.Client: The configured HTTP client
+//   - error: If the proxy URL or the CA bundle is invalid
+func New(cfg *Config) (*http.Client, error) {
+	if cfg.Client != nil {
+		return cfg.Client, nil
+	}
+
+	transport := cfg.Transport
+	if cfg.ProxyURL != "" || cfg.CABundlePath != "" {
+		base, ok := transport.(*http.Transport)
+		if transport == nil {
+			base, ok = http.DefaultTransport.(*http.Transport)
+		}
+		if !ok {
+			return nil, errors.New("proxy and CA bundle settings require an *http.Transport")
+		}
+		custom := base.Clone()
+
+		if cfg.ProxyURL != "" {
+			proxyURL, err := url.Parse(cfg.ProxyURL)
+			if err != nil || proxyURL.Host == "" {
+				return nil, fmt.Errorf("invalid proxy url: %q", cfg.ProxyURL)
+			}
+			custom.Proxy = http.ProxyURL(proxyURL)
+		}
+
+		if cfg.CABundlePath != "" {
+			rootCAs, err := loadCABundle(cfg.CABundlePath)
+			if err != nil {
+				return nil, err
+			}
+			if custom.TLSClientConfig == nil {
+				custom.TLSClientConfig = &tls.Config{}
+			}
+			custom.TLSClientConfig.RootCAs = rootCAs
+		}
+		transport = custom
+	} else if transport == nil {
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	}
+
+	return &http.Client{Transport: transport, Timeout: cfg.Timeout}, nil
+}
+
+// loadCABundle returns the system root certificates extended with the certificates of the given PEM file.
+func loadCABundle(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read CA bundle: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in CA bundle: %v", path)
+	}
+	return pool, nil
+}
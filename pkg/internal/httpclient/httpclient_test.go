@@ -0,0 +1,77 @@
+package httpclient
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	t.Run("returns the given client", func(t *testing.T) {
+		client := &http.Client{}
+		got, err := New(&Config{Client: client, ProxyURL: "http://proxy:3128"})
+		assert.NoError(t, err)
+		assert.Same(t, client, got)
+	})
+
+	t.Run("clones the default transport", func(t *testing.T) {
+		client, err := New(&Config{Timeout: time.Minute})
+		assert.NoError(t, err)
+		assert.Equal(t, time.Minute, client.Timeout)
+		assert.NotSame(t, http.DefaultTransport, client.Transport)
+	})
+
+	t.Run("uses the proxy", func(t *testing.T) {
+		client, err := New(&Config{ProxyURL: "http://proxy.corp:3128"})
+		assert.NoError(t, err)
+		request, _ := http.NewRequest(http.MethodGet, "https://api.anthropic.com", nil)
+		proxyURL, err := client.Transport.(*http.Transport).Proxy(request)
+		assert.NoError(t, err)
+		assert.Equal(t, "proxy.corp:3128", proxyURL.Host)
+	})
+
+	t.Run("rejects invalid settings", func(t *testing.T) {
+		_, err := New(&Config{ProxyURL: "proxy.corp"})
+		assert.EqualError(t, err, `invalid proxy url: "proxy.corp"`)
+
+		_, err = New(&Config{CABundlePath: filepath.Join(t.TempDir(), "missing.pem")})
+		assert.ErrorContains(t, err, "couldn't read CA bundle")
+
+		_, err = New(&Config{Transport: roundTripperFunc(nil), ProxyURL: "http://proxy.corp:3128"})
+		assert.EqualError(t, err, "proxy and CA bundle settings require an *http.Transport")
+	})
+
+	t.Run("trusts the CA bundle", func(t *testing.T) {
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer server.Close()
+
+		path := filepath.Join(t.TempDir(), "ca.pem")
+		certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+		assert.NoError(t, os.WriteFile(path, certificate, 0644))
+
+		untrusted, err := New(&Config{})
+		assert.NoError(t, err)
+		_, err = untrusted.Get(server.URL)
+		assert.Error(t, err)
+
+		trusted, err := New(&Config{CABundlePath: path})
+		assert.NoError(t, err)
+		response, err := trusted.Get(server.URL)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, response.StatusCode)
+	})
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
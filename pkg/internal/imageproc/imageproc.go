@@ -0,0 +1,203 @@
+// Package imageproc prepares screenshots before they are sent to vision models.
+package imageproc
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/gif" // Register the GIF decoder
+	"image/jpeg"
+	"image/png"
+	"math"
+	"net/http"
+
+	"github.com/HugoSmits86/nativewebp"
+	"github.com/vertexcover-io/locatr/pkg/internal/utils"
+	"github.com/vertexcover-io/locatr/pkg/types"
+	_ "golang.org/x/image/webp" // Register the WebP decoder
+)
+
+const (
+	// defaultJPEGQuality is the JPEG quality used when none is configured.
+	defaultJPEGQuality = 85
+	// lowDetailSize is the size of the square fitting low detail images.
+	lowDetailSize = 512
+)
+
+// sizeLimits bounds the size of an image, a zero value means no limit.
+type sizeLimits struct {
+	longEdge  int // Maximum length of the longest side
+	shortEdge int // Maximum length of the shortest side
+	pixels    int // Maximum number of pixels
+}
+
+// providerSizeLimits are the image sizes above which each provider scales images down, adding latency
+// without any gain in accuracy.
+var providerSizeLimits = map[types.LLMProvider]sizeLimits{
+	"anthropic":    {longEdge: 1568, pixels: 1_150_000},
+	"bedrock":      {longEdge: 1568, pixels: 1_150_000},
+	"openai":       {longEdge: 2048, shortEdge: 768},
+	"azure-openai": {longEdge: 2048, shortEdge: 768},
+	"gemini":       {longEdge: 3072},
+}
+
+// defaultSizeLimits are used for the providers without known limits.
+var defaultSizeLimits = sizeLimits{longEdge: 1568}
+
+// Result is a processed image.
+type Result struct {
+	Image     []byte            // The encoded image
+	MediaType string            // The media type of the encoded image
+	Original  *types.Resolution // The resolution of the screenshot, nil if it couldn't be decoded
+	Target    *types.Resolution // The resolution of the processed image, nil if it couldn't be decoded
+}
+
+// DetectMediaType returns the media type of the given image, e.g. image/png.
+func DetectMediaType(data []byte) string {
+	return http.DetectContentType(data)
+}
+
+// Process resizes and re-encodes a screenshot for the given providers.
+// Images that can't be decoded are returned as is.
+//
+// Parameters:
+//   - data: The encoded screenshot
+//   - providers: The providers the image may be sent to, e.g. by the tiers of a router client
+//   - opts: The preprocessing options, nil for the defaults
+//
+// Returns:
+//   - *Result: The processed image
+//   - error: If the image can't be encoded
+func Process(data []byte, providers []types.LLMProvider, opts *types.ImageOptions) (*Result, error) {
+	mediaType := DetectMediaType(data)
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return &Result{Image: data, MediaType: mediaType}, nil
+	}
+
+	original := &types.Resolution{Width: img.Bounds().Dx(), Height: img.Bounds().Dy()}
+	target := TargetResolution(original, providers, opts)
+
+	format := types.ImageFormatJPEG
+	quality := defaultJPEGQuality
+	if opts != nil {
+		if opts.Format != "" {
+			format = opts.Format
+		}
+		if opts.Quality > 0 {
+			quality = min(opts.Quality, 100)
+		}
+	}
+	if format == types.ImageFormatOriginal {
+		if *target == *original {
+			return &Result{Image: data, MediaType: mediaType, Original: original, Target: target}, nil
+		}
+		// The image must be re-encoded after resizing, losslessly like most screenshots
+		format = types.ImageFormatPNG
+		if mediaType == "image/webp" {
+			format = types.ImageFormatWebP
+		}
+	}
+
+	if *target != *original {
+		img = utils.ScaleAndPadImage(img, target)
+	}
+	buf := new(bytes.Buffer)
+	switch format {
+	case types.ImageFormatJPEG:
+		mediaType = "image/jpeg"
+		err = jpeg.Encode(buf, img, &jpeg.Options{Quality: quality})
+	case types.ImageFormatPNG:
+		mediaType = "image/png"
+		err = png.Encode(buf, img)
+	case types.ImageFormatWebP:
+		mediaType = "image/webp"
+		err = nativewebp.Encode(buf, img, nil)
+	default:
+		return nil, fmt.Errorf("unsupported image format: %q", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't encode image: %w", err)
+	}
+	return &Result{Image: buf.Bytes(), MediaType: mediaType, Original: original, Target: target}, nil
+}
+
+// TargetResolution returns the resolution an image is scaled down to, keeping its aspect ratio.
+//
+// Parameters:
+//   - original: The resolution of the image
+//   - providers: The providers the image may be sent to, whose smallest limits apply
+//   - opts: The preprocessing options, nil for the defaults
+//
+// Returns the target resolution, which is never larger than the original one.
+func TargetResolution(original *types.Resolution, providers []types.LLMProvider, opts *types.ImageOptions) *types.Resolution {
+	allLimits := []sizeLimits{}
+	for _, provider := range providers {
+		limits, ok := providerSizeLimits[provider]
+		if !ok {
+			limits = defaultSizeLimits
+		}
+		allLimits = append(allLimits, limits)
+	}
+	if len(allLimits) == 0 {
+		allLimits = append(allLimits, defaultSizeLimits)
+	}
+	var maxResolution *types.Resolution
+	if opts != nil {
+		switch opts.Detail {
+		case types.ImageDetailLow:
+			allLimits = []sizeLimits{{longEdge: lowDetailSize}}
+		case types.ImageDetailHigh:
+			allLimits = nil
+		}
+		maxResolution = opts.MaxResolution
+	}
+
+	width, height := float64(original.Width), float64(original.Height)
+	scale := 1.0
+	for _, limits := range allLimits {
+		if limits.longEdge > 0 {
+			scale = math.Min(scale, float64(limits.longEdge)/math.Max(width, height))
+		}
+		if limits.shortEdge > 0 {
+			scale = math.Min(scale, float64(limits.shortEdge)/math.Min(width, height))
+		}
+		if limits.pixels > 0 {
+			scale = math.Min(scale, math.Sqrt(float64(limits.pixels)/(width*height)))
+		}
+	}
+	if maxResolution != nil && maxResolution.Width > 0 && maxResolution.Height > 0 {
+		scale = math.Min(scale, math.Min(
+			float64(maxResolution.Width)/width, float64(maxResolution.Height)/height,
+		))
+	}
+	if scale >= 1 {
+		return &types.Resolution{Width: original.Width, Height: original.Height}
+	}
+	return &types.Resolution{
+		Width:  max(1, int(math.Round(width*scale))),
+		Height: max(1, int(math.Round(height*scale))),
+	}
+}
+
+// RemapPoint maps a point of the processed image back to the given resolution, e.g. the viewport size
+// when the screenshot was taken with a device scale factor.
+//
+// Parameters:
+//   - point: The point in the processed image
+//   - resolution: The resolution to map the point to
+//
+// Returns the remapped point, or nil if the point is outside of the image.
+func (r *Result) RemapPoint(point *types.Point, resolution *types.Resolution) *types.Point {
+	if r.Original == nil || r.Target == nil {
+		return point
+	}
+	remapped := utils.RemapPoint(point, r.Original, r.Target)
+	if remapped == nil {
+		return nil
+	}
+	return &types.Point{
+		X: remapped.X * float64(resolution.Width) / float64(r.Original.Width),
+		Y: remapped.Y * float64(resolution.Height) / float64(r.Original.Height),
+	}
+}
@@ -0,0 +1,173 @@
+package imageproc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vertexcover-io/locatr/pkg/types"
+)
+
+// webpScreenshot is a lossless 75x100 WebP image.
+const webpScreenshot = "UklGRrIBAABXRUJQVlA4TKUBAAAvSsAYAA8w//M///MfeJAkbXvaSG7m8Q3GfYSBJekwQztm/IcZlgwnmWImn2BK7aFmBtnVir6q//8VOkFE/xm4baTIu8c48ArEo6+B3zFKYln3pqClSCKX0begFTAXFOLXHSyF8cCNcZEG4OywuA4KVVfJCiArU7GAgJI8+lJP/OKMT/fBAjevg1cYB7YVkFuWga2lyPi5I0HFy5YTpWIHg0RZpkniRVW9odHAKOwosWuOGdxIyn2OvaCDvhg/we6TwadPBPbqBV58MsLmMJ8yZnOWk8SRz4N+QoyPL+MnamzMvcE1rHNEr91F9GKZPVUcS9w7PhhH36suB9qPeYb/oLk6cuTiJ0wOK3m5h1cKjW6EVZCYMK7dxcKCBdgP9HkKr9gkAO2P8GKZGWVdIAatQa+1IDpt6qyorVwdy01xdW8Jkfk6xjEXmVQQ+HQdFr6OKhIN34dXWq0+0qr6EJSCeeVLH9+gvGTLyqM65PQ44ihzlTXxQKjKbAvshXgir7Lil9w4L2bvMycmjQcqXaMCO6BlY28i+FOLzbfI1vEqxAhotocAAA=="
+
+func encodePNG(t *testing.T, width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	buf := new(bytes.Buffer)
+	assert.NoError(t, png.Encode(buf, img))
+	return buf.Bytes()
+}
+
+func TestProcess(t *testing.T) {
+	screenshot := encodePNG(t, 2560, 1600)
+
+	t.Run("scales down and re-encodes as JPEG", func(t *testing.T) {
+		result, err := Process(screenshot, []types.LLMProvider{"openai"}, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, "image/jpeg", result.MediaType)
+		assert.Equal(t, &types.Resolution{Width: 2560, Height: 1600}, result.Original)
+		assert.Equal(t, &types.Resolution{Width: 1229, Height: 768}, result.Target)
+
+		config, err := jpeg.DecodeConfig(bytes.NewReader(result.Image))
+		assert.NoError(t, err)
+		assert.Equal(t, 1229, config.Width)
+		assert.Equal(t, 768, config.Height)
+	})
+
+	t.Run("keeps the original encoding", func(t *testing.T) {
+		small := encodePNG(t, 400, 300)
+		result, err := Process(small, []types.LLMProvider{"openai"}, &types.ImageOptions{Format: types.ImageFormatOriginal})
+		assert.NoError(t, err)
+		assert.Equal(t, "image/png", result.MediaType)
+		assert.Equal(t, small, result.Image)
+	})
+
+	t.Run("re-encodes WebP screenshots", func(t *testing.T) {
+		webp, err := base64.StdEncoding.DecodeString(webpScreenshot)
+		assert.NoError(t, err)
+		result, err := Process(webp, []types.LLMProvider{"anthropic"}, &types.ImageOptions{MaxResolution: &types.Resolution{Width: 32, Height: 32}})
+		assert.NoError(t, err)
+		assert.Equal(t, "image/jpeg", result.MediaType)
+		assert.Equal(t, &types.Resolution{Width: 75, Height: 100}, result.Original)
+		assert.Equal(t, &types.Resolution{Width: 24, Height: 32}, result.Target)
+
+		config, err := jpeg.DecodeConfig(bytes.NewReader(result.Image))
+		assert.NoError(t, err)
+		assert.Equal(t, 24, config.Width)
+	})
+
+	t.Run("re-encodes as lossless WebP", func(t *testing.T) {
+		small := encodePNG(t, 40, 30)
+		result, err := Process(small, []types.LLMProvider{"openai"}, &types.ImageOptions{Format: types.ImageFormatWebP})
+		assert.NoError(t, err)
+		assert.Equal(t, "image/webp", result.MediaType)
+		assert.Equal(t, "image/webp", DetectMediaType(result.Image))
+
+		decoded, format, err := image.Decode(bytes.NewReader(result.Image))
+		assert.NoError(t, err)
+		assert.Equal(t, "webp", format)
+		original, err := png.Decode(bytes.NewReader(small))
+		assert.NoError(t, err)
+		assert.Equal(t, original.Bounds(), decoded.Bounds())
+		r1, g1, b1, _ := original.At(17, 23).RGBA()
+		r2, g2, b2, _ := decoded.At(17, 23).RGBA()
+		assert.Equal(t, []uint32{r1, g1, b1}, []uint32{r2, g2, b2})
+	})
+
+	t.Run("keeps resized WebP screenshots in WebP", func(t *testing.T) {
+		webp, err := base64.StdEncoding.DecodeString(webpScreenshot)
+		assert.NoError(t, err)
+		result, err := Process(webp, []types.LLMProvider{"anthropic"}, &types.ImageOptions{
+			Format: types.ImageFormatOriginal, MaxResolution: &types.Resolution{Width: 32, Height: 32},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "image/webp", result.MediaType)
+
+		config, format, err := image.DecodeConfig(bytes.NewReader(result.Image))
+		assert.NoError(t, err)
+		assert.Equal(t, "webp", format)
+		assert.Equal(t, 24, config.Width)
+	})
+
+	t.Run("passes through images that can't be decoded", func(t *testing.T) {
+		webp := []byte("RIFF\x00\x00\x00\x00WEBPVP8 ")
+		result, err := Process(webp, []types.LLMProvider{"anthropic"}, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, "image/webp", result.MediaType)
+		assert.Equal(t, webp, result.Image)
+		assert.Nil(t, result.Target)
+	})
+
+	t.Run("rejects unknown formats", func(t *testing.T) {
+		_, err := Process(screenshot, []types.LLMProvider{"openai"}, &types.ImageOptions{Format: "bmp"})
+		assert.EqualError(t, err, `unsupported image format: "bmp"`)
+	})
+}
+
+func TestTargetResolution(t *testing.T) {
+	original := &types.Resolution{Width: 2560, Height: 1600}
+	tests := []struct {
+		name      string
+		providers []types.LLMProvider
+		opts      *types.ImageOptions
+		expected  types.Resolution
+	}{
+		{name: "anthropic pixel limit", providers: []types.LLMProvider{"anthropic"}, expected: types.Resolution{Width: 1356, Height: 848}},
+		{name: "gemini long edge", providers: []types.LLMProvider{"gemini"}, expected: types.Resolution{Width: 2560, Height: 1600}},
+		{name: "unknown provider", providers: []types.LLMProvider{"groq"}, expected: types.Resolution{Width: 1568, Height: 980}},
+		{
+			name:      "smallest limits of several providers",
+			providers: []types.LLMProvider{"gemini", "openai", "anthropic"},
+			expected:  types.Resolution{Width: 1229, Height: 768},
+		},
+		{
+			name:      "low detail",
+			providers: []types.LLMProvider{"anthropic"},
+			opts:      &types.ImageOptions{Detail: types.ImageDetailLow},
+			expected:  types.Resolution{Width: 512, Height: 320},
+		},
+		{
+			name:      "high detail",
+			providers: []types.LLMProvider{"anthropic"},
+			opts:      &types.ImageOptions{Detail: types.ImageDetailHigh},
+			expected:  types.Resolution{Width: 2560, Height: 1600},
+		},
+		{
+			name:      "max resolution",
+			providers: []types.LLMProvider{"gemini"},
+			opts:      &types.ImageOptions{MaxResolution: &types.Resolution{Width: 1280, Height: 1280}},
+			expected:  types.Resolution{Width: 1280, Height: 800},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, *TargetResolution(original, tt.providers, tt.opts))
+		})
+	}
+}
+
+func TestResult_RemapPoint(t *testing.T) {
+	result := &Result{
+		Original: &types.Resolution{Width: 2560, Height: 1600},
+		Target:   &types.Resolution{Width: 1280, Height: 800},
+	}
+	viewport := &types.Resolution{Width: 1280, Height: 800}
+
+	// The screenshot was taken with a device scale factor of 2 and scaled down by 2
+	assert.Equal(t, &types.Point{X: 300, Y: 200}, result.RemapPoint(&types.Point{X: 300, Y: 200}, viewport))
+	assert.Nil(t, result.RemapPoint(&types.Point{X: 1300, Y: 200}, viewport))
+
+	// Points of images that couldn't be decoded are kept as is
+	assert.Equal(t, &types.Point{X: 10, Y: 20}, (&Result{}).RemapPoint(&types.Point{X: 10, Y: 20}, viewport))
+}
@@ -11,8 +11,12 @@ import (
 	"image/draw"
 	"math"
 	"reflect"
+	"regexp"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"unicode"
 
 	"github.com/antchfx/xmlquery"
 	"github.com/kaptinlin/jsonrepair"
@@ -137,6 +141,149 @@ func SortRerankChunks(chunks []string, results []types.RerankResult) []string {
 	return finalChunks
 }
 
+// TopRerankResults sorts the rerank results by decreasing score, keeping the order of the chunks with the
+// same score.
+// Parameters:
+//   - results: The rerank results to sort, in place
+//   - topN: The number of results to return, all of them if zero
+//
+// Returns the topN first results.
+func TopRerankResults(results []types.RerankResult, topN int) []types.RerankResult {
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	if topN > 0 && topN < len(results) {
+		results = results[:topN]
+	}
+	return results
+}
+
+// stopWords are the common English words that don't tell chunks apart.
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "by": true,
+	"for": true, "from": true, "in": true, "into": true, "is": true, "it": true, "its": true, "of": true,
+	"on": true, "or": true, "that": true, "the": true, "this": true, "to": true, "with": true,
+}
+
+// Tokenize splits a text into lowercase words and numbers, without the stop words.
+// Words written in camel case, like "loginButton", are split as well.
+// Parameters:
+//   - text: The text to tokenize
+//
+// Returns the tokens in the order of the text.
+func Tokenize(text string) []string {
+	tokens := []string{}
+	current := []rune{}
+	flush := func() {
+		if len(current) > 0 {
+			if token := string(current); !stopWords[token] {
+				tokens = append(tokens, token)
+			}
+			current = current[:0]
+		}
+	}
+	previous := rune(0)
+	for _, r := range text {
+		switch {
+		case unicode.IsUpper(r):
+			if unicode.IsLower(previous) || unicode.IsDigit(previous) {
+				flush()
+			}
+			current = append(current, unicode.ToLower(r))
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			current = append(current, r)
+		default:
+			flush()
+		}
+		previous = r
+	}
+	flush()
+	return tokens
+}
+
+// FindElementByLocator finds the element spec in the DOM tree that the given locator is associated with.
+// Parameters:
+//   - dom: The minified DOM to search
+//   - locator: One of the locators of the element
+//
+// Returns the element spec or nil if the locator isn't associated with any visible element.
+func FindElementByLocator(dom *types.DOM, locator string) *types.ElementSpec {
+	if dom == nil || dom.RootElement == nil || dom.Metadata == nil {
+		return nil
+	}
+	elementId := ""
+	for id, locators := range dom.Metadata.LocatorMap {
+		if slices.Contains(locators, locator) {
+			elementId = id
+			break
+		}
+	}
+	if elementId == "" {
+		return nil
+	}
+
+	var find func(element *types.ElementSpec) *types.ElementSpec
+	find = func(element *types.ElementSpec) *types.ElementSpec {
+		if element.Id == elementId {
+			return element
+		}
+		for i := range element.Children {
+			if found := find(&element.Children[i]); found != nil {
+				return found
+			}
+		}
+		return nil
+	}
+	return find(dom.RootElement)
+}
+
+// structuralAttributes lists the attributes whose values describe the structure of a screen rather than its content.
+// Every other attribute, the text and the element IDs are ignored by StructuralHash.
+var structuralAttributes = []string{
+	"role",
+	"aria-role",
+	"type",
+	"resource-id",
+	"data-supported-primitives",
+}
+
+// StructuralHash generates a hash of the element tree that only depends on its structure.
+// Text, element IDs and dynamic attributes are ignored, and consecutive siblings with the same
+// structure (e.g. list items) are collapsed so that lists of different lengths hash the same.
+//
+// Parameters:
+//   - element: The root of the element tree
+//
+// Returns:
+//   - string: The structural hash of the tree
+func StructuralHash(element *types.ElementSpec) string {
+	if element == nil {
+		return ""
+	}
+
+	var builder strings.Builder
+	builder.WriteString(element.TagName)
+	for _, name := range structuralAttributes {
+		if value, ok := element.Attributes[name]; ok {
+			builder.WriteString(fmt.Sprintf("[%s=%s]", name, value))
+		}
+	}
+
+	builder.WriteString("(")
+	previous := ""
+	for i := range element.Children {
+		childHash := StructuralHash(&element.Children[i])
+		if childHash == previous {
+			continue
+		}
+		builder.WriteString(childHash)
+		previous = childHash
+	}
+	builder.WriteString(")")
+
+	return GenerateUniqueId(builder.String())
+}
+
 // XPath to find the first element with a non-empty id attribute.
 // This query looks for any element with an id attribute that's not empty
 // and has either a bounds (android visibility) or visible (ios visibility) attribute
@@ -314,6 +461,36 @@ func ParseJSON(text string) (string, error) {
 	return jsonrepair.JSONRepair(text)
 }
 
+// thinkBlockRegex matches the reasoning blocks emitted by reasoning models (e.g. DeepSeek R1, QwQ).
+var thinkBlockRegex = regexp.MustCompile(`(?s)<think>.*?</think>`)
+
+// ExtractJSON extracts the JSON object from a free-form text response.
+// Reasoning blocks are removed, then the content of the first fenced code block
+// or the outermost braces is returned. If no JSON object is found, the trimmed text is returned.
+//
+// Parameters:
+//   - text: The text containing a JSON object
+//
+// Returns:
+//   - string: The extracted JSON text, to be passed to ParseJSON
+func ExtractJSON(text string) string {
+	text = strings.TrimSpace(thinkBlockRegex.ReplaceAllString(text, ""))
+
+	if start := strings.Index(text, "```"); start != -1 {
+		block := text[start+3:]
+		if end := strings.Index(block, "```"); end != -1 {
+			block = block[:end]
+		}
+		return strings.TrimSpace(strings.TrimPrefix(block, "json"))
+	}
+
+	start, end := strings.Index(text, "{"), strings.LastIndex(text, "}")
+	if start != -1 && end > start {
+		return text[start : end+1]
+	}
+	return text
+}
+
 // GenerateUniqueId generates a unique ID from a given string using MD5 hashing.
 //
 // Parameters:
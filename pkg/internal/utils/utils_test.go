@@ -268,6 +268,134 @@ func TestSortRerankChunks(t *testing.T) {
 	}
 }
 
+func TestTopRerankResults(t *testing.T) {
+	tests := []struct {
+		name    string
+		results []types.RerankResult
+		topN    int
+		want    []types.RerankResult
+	}{
+		{
+			name:    "Sorted by score, ties in order",
+			results: []types.RerankResult{{Index: 0, Score: 0.2}, {Index: 1, Score: 0.9}, {Index: 2, Score: 0.2}},
+			want:    []types.RerankResult{{Index: 1, Score: 0.9}, {Index: 0, Score: 0.2}, {Index: 2, Score: 0.2}},
+		},
+		{
+			name:    "Top N",
+			results: []types.RerankResult{{Index: 0, Score: 0.2}, {Index: 1, Score: 0.9}, {Index: 2, Score: 0.5}},
+			topN:    2,
+			want:    []types.RerankResult{{Index: 1, Score: 0.9}, {Index: 2, Score: 0.5}},
+		},
+		{
+			name:    "Top N above the number of results",
+			results: []types.RerankResult{{Index: 0, Score: 0.2}},
+			topN:    5,
+			want:    []types.RerankResult{{Index: 0, Score: 0.2}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := TopRerankResults(tt.results, tt.topN)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{name: "Stop words removed", text: "Click the Checkout button", want: []string{"click", "checkout", "button"}},
+		{name: "Camel case split", text: "loginButton2FA", want: []string{"login", "button2", "fa"}},
+		{name: "Punctuation and numbers", text: "step-2: café, 10%", want: []string{"step", "2", "café", "10"}},
+		{name: "Only stop words", text: "to the", want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Tokenize(tt.text))
+		})
+	}
+}
+
+func TestFindElementByLocator(t *testing.T) {
+	dom := &types.DOM{
+		RootElement: &types.ElementSpec{
+			Id:      "root",
+			TagName: "body",
+			Children: []types.ElementSpec{
+				{Id: "form", TagName: "form", Children: []types.ElementSpec{
+					{Id: "submit", TagName: "button", Text: "Submit"},
+				}},
+			},
+		},
+		Metadata: &types.DOMMetadata{
+			LocatorMap: map[string][]string{
+				"submit": {"form > button", "#submit"},
+				"hidden": {"#hidden"},
+			},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		locator string
+		wantId  string
+	}{
+		{name: "Nested element", locator: "#submit", wantId: "submit"},
+		{name: "Element not in minified tree", locator: "#hidden"},
+		{name: "Unknown locator", locator: "#unknown"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := FindElementByLocator(dom, tt.locator)
+			if tt.wantId == "" {
+				assert.Nil(t, got)
+				return
+			}
+			assert.NotNil(t, got)
+			assert.Equal(t, tt.wantId, got.Id)
+		})
+	}
+}
+
+func TestStructuralHash(t *testing.T) {
+	listItem := func(text, id string) types.ElementSpec {
+		return types.ElementSpec{
+			Id:         id,
+			TagName:    "li",
+			Text:       text,
+			Attributes: map[string]string{"class": "item-" + id, "data-supported-primitives": "click"},
+		}
+	}
+	screen := func(title string, items ...types.ElementSpec) *types.ElementSpec {
+		return &types.ElementSpec{
+			Id:      "root",
+			TagName: "body",
+			Children: []types.ElementSpec{
+				{Id: "title-" + title, TagName: "h1", Text: title},
+				{Id: "list", TagName: "ul", Children: items},
+			},
+		}
+	}
+
+	base := StructuralHash(screen("Inbox", listItem("Hello", "1"), listItem("World", "2")))
+
+	assert.Equal(t, base, StructuralHash(screen("Archive", listItem("Foo", "3"))),
+		"text, ids, dynamic attributes and list lengths should be ignored")
+	assert.NotEqual(t, base, StructuralHash(screen("Inbox")),
+		"removing a whole list should change the structure")
+
+	changed := screen("Inbox", listItem("Hello", "1"))
+	changed.Children[1].Children[0].Attributes["data-supported-primitives"] = "input_text"
+	assert.NotEqual(t, base, StructuralHash(changed),
+		"changing a structural attribute should change the structure")
+}
+
 func TestExtractFirstUniqueID(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -378,3 +506,38 @@ func TestParseJSON(t *testing.T) {
 		})
 	}
 }
+
+func TestExtractJSON(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "Plain JSON",
+			input: `{"key": "value"}`,
+			want:  `{"key": "value"}`,
+		},
+		{
+			name:  "Think block and code fence",
+			input: "<think>maybe {\"key\": 1}</think>\nHere you go:\n```json\n{\"key\": \"value\"}\n```",
+			want:  `{"key": "value"}`,
+		},
+		{
+			name:  "Surrounding prose",
+			input: "The answer is {\"key\": {\"nested\": true}} as requested.",
+			want:  `{"key": {"nested": true}}`,
+		},
+		{
+			name:  "No JSON",
+			input: "no json here",
+			want:  "no json here",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, ExtractJSON(tt.input))
+		})
+	}
+}
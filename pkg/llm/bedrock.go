@@ -0,0 +1,142 @@
+package llm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	anthropicOption "github.com/anthropics/anthropic-sdk-go/option"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+// bedrockAnthropicVersion is the Anthropic API version expected by Bedrock in the request body.
+const bedrockAnthropicVersion = "bedrock-2023-05-31"
+
+// awsCredentials holds the static AWS credentials used to sign Bedrock requests.
+type awsCredentials struct {
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+}
+
+// resolveBedrockConfig fills the AWS region and credentials from the standard AWS environment variables
+// (AWS_REGION, AWS_DEFAULT_REGION, AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN) if they weren't configured.
+//
+// Parameters:
+//   - cfg: The LLM client configuration
+//
+// Returns:
+//   - string: The Bedrock region
+//   - aws.Credentials: The credentials to sign requests with
+//   - error: Any missing configuration
+func resolveBedrockConfig(cfg *config) (string, aws.Credentials, error) {
+	region := cfg.awsRegion
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		return "", aws.Credentials{}, errors.New("aws region is required for bedrock provider")
+	}
+
+	credentials := cfg.awsCredentials
+	if credentials == nil {
+		credentials = &awsCredentials{
+			accessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			secretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			sessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}
+	}
+	if credentials.accessKeyID == "" || credentials.secretAccessKey == "" {
+		return "", aws.Credentials{}, errors.New("aws credentials are required for bedrock provider")
+	}
+
+	return region, aws.Credentials{
+		AccessKeyID:     credentials.accessKeyID,
+		SecretAccessKey: credentials.secretAccessKey,
+		SessionToken:    credentials.sessionToken,
+	}, nil
+}
+
+// bedrockInvokePath returns the escaped path of the InvokeModel API for the given model ID or inference profile ARN.
+func bedrockInvokePath(model string) string {
+	return fmt.Sprintf("/model/%s/invoke", strings.ReplaceAll(url.PathEscape(model), ":", "%3A"))
+}
+
+// bedrockMiddleware rewrites Anthropic Messages API requests into Bedrock InvokeModel requests and signs them with SigV4.
+//
+// The model is moved from the body into the path, the Anthropic version and beta flags are moved into the body,
+// and the Anthropic authentication headers are replaced by the AWS signature.
+//
+// Parameters:
+//   - region: The Bedrock region
+//   - credentials: The credentials to sign requests with
+//
+// Returns the Anthropic SDK middleware.
+func bedrockMiddleware(region string, credentials aws.Credentials) anthropicOption.Middleware {
+	signer := v4.NewSigner()
+	return func(r *http.Request, next anthropicOption.MiddlewareNext) (*http.Response, error) {
+		fields := map[string]json.RawMessage{}
+		if r.Body != nil {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				return nil, err
+			}
+			r.Body.Close()
+			if err := json.Unmarshal(body, &fields); err != nil {
+				return nil, fmt.Errorf("failed to decode request body: %w", err)
+			}
+		}
+
+		var model string
+		if err := json.Unmarshal(fields["model"], &model); err != nil || model == "" {
+			return nil, errors.New("model is required for bedrock request")
+		}
+		delete(fields, "model")
+		if _, ok := fields["anthropic_version"]; !ok {
+			fields["anthropic_version"], _ = json.Marshal(bedrockAnthropicVersion)
+		}
+		if betas := r.Header.Values("anthropic-beta"); len(betas) > 0 {
+			fields["anthropic_beta"], _ = json.Marshal(betas)
+		}
+		body, err := json.Marshal(fields)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode request body: %w", err)
+		}
+
+		r.URL.RawPath = bedrockInvokePath(model)
+		r.URL.Path, _ = url.PathUnescape(r.URL.RawPath)
+		r.URL.RawQuery = ""
+		for _, header := range []string{"anthropic-beta", "anthropic-version", "x-api-key", "authorization"} {
+			r.Header.Del(header)
+		}
+
+		reader := bytes.NewReader(body)
+		r.Body = io.NopCloser(reader)
+		r.GetBody = func() (io.ReadCloser, error) {
+			_, err := reader.Seek(0, io.SeekStart)
+			return io.NopCloser(reader), err
+		}
+		r.ContentLength = int64(len(body))
+
+		hash := sha256.Sum256(body)
+		if err := signer.SignHTTP(
+			r.Context(), credentials, r, hex.EncodeToString(hash[:]), "bedrock", region, time.Now(),
+		); err != nil {
+			return nil, fmt.Errorf("failed to sign bedrock request: %w", err)
+		}
+		return next(r)
+	}
+}
@@ -0,0 +1,80 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBedrockProvider(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("ANTHROPIC_API_KEY", "anthropic-key")
+
+	received := map[string]any{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/model/anthropic.claude-3-5-sonnet-20241022-v2%3A0/invoke", r.URL.EscapedPath())
+		assert.Empty(t, r.URL.RawQuery)
+		assert.True(t, strings.HasPrefix(
+			r.Header.Get("Authorization"),
+			"AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/",
+		))
+		assert.Contains(t, r.Header.Get("Authorization"), "/eu-west-1/bedrock/aws4_request")
+		assert.Equal(t, "session-token", r.Header.Get("X-Amz-Security-Token"))
+		assert.Empty(t, r.Header.Get("X-Api-Key"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{
+			"id": "msg_1", "type": "message", "role": "assistant", "model": "claude-3-5-sonnet-20241022",
+			"content": [{"type": "text", "text": "\n{\"locator_id\": \"abc\"}\n"}],
+			"stop_reason": "stop_sequence", "stop_sequence": "` + "```" + `",
+			"usage": {"input_tokens": 812, "output_tokens": 14}
+		}`))
+	}))
+	defer server.Close()
+
+	client, err := NewLLMClient(
+		WithProvider(Bedrock),
+		WithModel("anthropic.claude-3-5-sonnet-20241022-v2:0"),
+		WithAWSRegion("eu-west-1"),
+		WithAWSCredentials("AKIDEXAMPLE", "secret", "session-token"),
+		WithBaseURL(server.URL),
+	)
+	assert.NoError(t, err)
+
+	completion, err := client.GetJSONCompletion(ctx, "find the button", pngHeader)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"locator_id": "abc"}`, completion.JSON)
+	assert.Equal(t, 812, completion.InputTokens)
+	assert.Equal(t, 14, completion.OutputTokens)
+	assert.Equal(t, Bedrock, completion.Provider)
+
+	assert.Equal(t, bedrockAnthropicVersion, received["anthropic_version"])
+	assert.NotContains(t, received, "model")
+	assert.Len(t, received["messages"], 2)
+}
+
+func TestResolveBedrockConfig(t *testing.T) {
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_DEFAULT_REGION", "us-east-1")
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDENV")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+
+	region, credentials, err := resolveBedrockConfig(&config{})
+	assert.NoError(t, err)
+	assert.Equal(t, "us-east-1", region)
+	assert.Equal(t, "AKIDENV", credentials.AccessKeyID)
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	_, _, err = resolveBedrockConfig(&config{})
+	assert.EqualError(t, err, "aws credentials are required for bedrock provider")
+
+	t.Setenv("AWS_DEFAULT_REGION", "")
+	_, _, err = resolveBedrockConfig(&config{})
+	assert.EqualError(t, err, "aws region is required for bedrock provider")
+}
@@ -0,0 +1,188 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/vertexcover-io/locatr/pkg/internal/utils"
+	"github.com/vertexcover-io/locatr/pkg/types"
+)
+
+// defaultGeminiBaseURL is the base URL of the Gemini API.
+const defaultGeminiBaseURL = "https://generativelanguage.googleapis.com/v1beta/"
+
+// geminiInlineData is an inline, base64 encoded blob sent as part of a Gemini request.
+type geminiInlineData struct {
+	MimeType string `json:"mime_type"`
+	Data     string `json:"data"`
+}
+
+// geminiPart is a single part of a Gemini message content, either text or inline data.
+type geminiPart struct {
+	Text       string            `json:"text,omitempty"`
+	InlineData *geminiInlineData `json:"inline_data,omitempty"`
+}
+
+// geminiContent is a message sent to or received from a Gemini model.
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+// geminiGenerationConfig configures the generation of a Gemini model.
+type geminiGenerationConfig struct {
+	ResponseMimeType string `json:"responseMimeType,omitempty"`
+}
+
+// geminiRequest is the body of a Gemini generateContent request.
+type geminiRequest struct {
+	Contents         []geminiContent         `json:"contents"`
+	GenerationConfig *geminiGenerationConfig `json:"generationConfig,omitempty"`
+}
+
+// geminiResponse is the body of a Gemini generateContent response.
+type geminiResponse struct {
+	Candidates []struct {
+		Content      geminiContent `json:"content"`
+		FinishReason string        `json:"finishReason"`
+	} `json:"candidates"`
+	UsageMetadata struct {
+		PromptTokenCount     int `json:"promptTokenCount"`
+		CandidatesTokenCount int `json:"candidatesTokenCount"`
+	} `json:"usageMetadata"`
+	PromptFeedback *struct {
+		BlockReason string `json:"blockReason"`
+	} `json:"promptFeedback,omitempty"`
+}
+
+// geminiErrorResponse is the body of a failed Gemini request.
+type geminiErrorResponse struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Status  string `json:"status"`
+	} `json:"error"`
+}
+
+// requestGemini handles API requests to Google's Gemini models.
+//
+// Parameters:
+//   - ctx: Context
+//   - httpClient: The HTTP client used to send the request
+//   - baseURL: Base URL of the Gemini API
+//   - apiKey: Gemini API key
+//   - provider: The service provider (Gemini)
+//   - model: Gemini model identifier
+//   - prompt: The input prompt
+//   - image: Optional image data for vision models
+//   - jsonResponseFormat: Whether to request the JSON response mime type
+//
+// Returns:
+//   - *types.JSONCompletion: The API response and metadata
+//   - error: Any API or processing errors
+func requestGemini(
+	ctx context.Context, httpClient *http.Client, baseURL, apiKey string, provider types.LLMProvider, model, prompt string, image []byte, jsonResponseFormat bool,
+) (*types.JSONCompletion, error) {
+	completion := &types.JSONCompletion{
+		LLMCompletionMeta: types.LLMCompletionMeta{
+			InputTokens:  0,
+			OutputTokens: 0,
+			Provider:     provider,
+			Model:        model,
+		},
+	}
+
+	parts := []geminiPart{{Text: prompt}}
+	if image != nil {
+		parts = append(parts, geminiPart{
+			InlineData: &geminiInlineData{
+				MimeType: http.DetectContentType(image),
+				Data:     base64.StdEncoding.EncodeToString(image),
+			},
+		})
+	}
+	request := geminiRequest{
+		Contents: []geminiContent{{Role: "user", Parts: parts}},
+	}
+	if jsonResponseFormat {
+		request.GenerationConfig = &geminiGenerationConfig{ResponseMimeType: "application/json"}
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return completion, fmt.Errorf("failed to marshal %v request: %w", provider, err)
+	}
+	endpoint := fmt.Sprintf(
+		"%smodels/%s:generateContent", withTrailingSlash(baseURL), url.PathEscape(model),
+	)
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return completion, fmt.Errorf("failed to create %v request: %w", provider, err)
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+	httpRequest.Header.Set("x-goog-api-key", apiKey)
+
+	httpResponse, err := httpClient.Do(httpRequest)
+	if err != nil {
+		return completion, fmt.Errorf("failed to get completion from %v: %w", provider, err)
+	}
+	defer httpResponse.Body.Close()
+
+	responseBody, err := io.ReadAll(httpResponse.Body)
+	if err != nil {
+		return completion, fmt.Errorf("failed to read %v response: %w", provider, err)
+	}
+	if httpResponse.StatusCode != http.StatusOK {
+		message := string(responseBody)
+		var errorResponse geminiErrorResponse
+		if json.Unmarshal(responseBody, &errorResponse) == nil && errorResponse.Error.Message != "" {
+			message = fmt.Sprintf("%v: %v", errorResponse.Error.Status, errorResponse.Error.Message)
+		}
+		return completion, &apiError{
+			provider:   provider,
+			statusCode: httpResponse.StatusCode,
+			header:     httpResponse.Header,
+			message:    message,
+		}
+	}
+
+	var response geminiResponse
+	if err := json.Unmarshal(responseBody, &response); err != nil {
+		return completion, fmt.Errorf("failed to decode %v response: %w", provider, err)
+	}
+
+	completion.InputTokens = response.UsageMetadata.PromptTokenCount
+	completion.OutputTokens = response.UsageMetadata.CandidatesTokenCount
+
+	if len(response.Candidates) == 0 {
+		if response.PromptFeedback != nil && response.PromptFeedback.BlockReason != "" {
+			return completion, fmt.Errorf(
+				"prompt blocked by %v: %v", provider, response.PromptFeedback.BlockReason,
+			)
+		}
+		return completion, fmt.Errorf("no candidates in completion from %v", provider)
+	}
+
+	var text strings.Builder
+	for _, part := range response.Candidates[0].Content.Parts {
+		text.WriteString(part.Text)
+	}
+	content := text.String()
+	if !jsonResponseFormat {
+		// Without the JSON response mime type, the JSON may be surrounded by prose
+		content = utils.ExtractJSON(content)
+	}
+	jsonStr, err := utils.ParseJSON(content)
+	if err != nil {
+		return completion, fmt.Errorf("failed to parse JSON: %w", err)
+	}
+	completion.JSON = jsonStr
+	return completion, nil
+}
@@ -0,0 +1,121 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// geminiTextResponse returns a successful Gemini response with the given candidate text.
+func geminiTextResponse(text string) string {
+	encoded, _ := json.Marshal(text)
+	return `{
+		"candidates": [{"content": {"role": "model", "parts": [{"text": ` + string(encoded) + `}]}}],
+		"usageMetadata": {"promptTokenCount": 1290, "candidatesTokenCount": 9}
+	}`
+}
+
+// pngHeader is enough of a PNG file for its content type to be detected.
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func TestGeminiProvider(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name               string
+		options            []Option
+		image              []byte
+		status             int
+		response           string
+		expectedJSON       string
+		expectedError      string
+		expectedMimeType   string
+		expectedInlineData bool
+	}{
+		{
+			name:               "JSON completion with image",
+			image:              pngHeader,
+			status:             http.StatusOK,
+			response:           geminiTextResponse(`{"locator_id": "abc"}`),
+			expectedJSON:       `{"locator_id": "abc"}`,
+			expectedMimeType:   "application/json",
+			expectedInlineData: true,
+		},
+		{
+			name:         "JSON response format disabled",
+			options:      []Option{WithJSONResponseFormat(false)},
+			status:       http.StatusOK,
+			response:     geminiTextResponse("Here it is:\n```json\n{\"locator_id\": \"abc\"}\n```"),
+			expectedJSON: `{"locator_id": "abc"}`,
+		},
+		{
+			name:          "API error",
+			status:        http.StatusBadRequest,
+			response:      `{"error": {"code": 400, "message": "API key not valid.", "status": "INVALID_ARGUMENT"}}`,
+			expectedError: "failed to get completion from gemini: 400 INVALID_ARGUMENT: API key not valid.",
+		},
+		{
+			name:   "blocked prompt",
+			status: http.StatusOK,
+			response: `{
+				"promptFeedback": {"blockReason": "SAFETY"},
+				"usageMetadata": {"promptTokenCount": 1290}
+			}`,
+			expectedError: "prompt blocked by gemini: SAFETY",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var received geminiRequest
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "/v1beta/models/gemini-2.0-flash:generateContent", r.URL.Path)
+				assert.Equal(t, "test-key", r.Header.Get("x-goog-api-key"))
+				assert.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.response))
+			}))
+			defer server.Close()
+
+			options := append([]Option{
+				WithProvider(Gemini),
+				WithModel("gemini-2.0-flash"),
+				WithAPIKey("test-key"),
+				WithBaseURL(server.URL + "/v1beta"),
+			}, tt.options...)
+			client, err := NewLLMClient(options...)
+			assert.NoError(t, err)
+
+			completion, err := client.GetJSONCompletion(ctx, "find the login button", tt.image)
+			if tt.expectedError != "" {
+				assert.EqualError(t, err, tt.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+			assert.JSONEq(t, tt.expectedJSON, completion.JSON)
+			assert.Equal(t, 1290, completion.InputTokens)
+			assert.Equal(t, 9, completion.OutputTokens)
+			assert.Equal(t, Gemini, completion.Provider)
+			assert.Equal(t, "gemini-2.0-flash", completion.Model)
+
+			assert.Len(t, received.Contents, 1)
+			assert.Equal(t, "find the login button", received.Contents[0].Parts[0].Text)
+			if tt.expectedMimeType != "" {
+				assert.Equal(t, tt.expectedMimeType, received.GenerationConfig.ResponseMimeType)
+			} else {
+				assert.Nil(t, received.GenerationConfig)
+			}
+			if tt.expectedInlineData {
+				assert.Len(t, received.Contents[0].Parts, 2)
+				assert.Equal(t, "image/png", received.Contents[0].Parts[1].InlineData.MimeType)
+			} else {
+				assert.Len(t, received.Contents[0].Parts, 1)
+			}
+		})
+	}
+}
@@ -3,16 +3,23 @@ package llm
 import (
 	"context"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
+	"net/http"
+	"net/url"
 	"os"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/anthropics/anthropic-sdk-go"
 	anthropicOption "github.com/anthropics/anthropic-sdk-go/option"
 	"github.com/openai/openai-go"
 	openaiOption "github.com/openai/openai-go/option"
+	"github.com/vertexcover-io/locatr/pkg/internal/constants"
+	"github.com/vertexcover-io/locatr/pkg/internal/httpclient"
 	"github.com/vertexcover-io/locatr/pkg/internal/utils"
 	"github.com/vertexcover-io/locatr/pkg/logging"
 	"github.com/vertexcover-io/locatr/pkg/types"
@@ -24,13 +31,47 @@ const (
 	Anthropic  types.LLMProvider = "anthropic"   // Anthropic API provider (e.g., Claude models)
 	Groq       types.LLMProvider = "groq"        // Groq API provider
 	OpenRouter types.LLMProvider = "open-router" // OpenRouter API aggregation service
+	// OpenAICompatible is any endpoint implementing the OpenAI chat completions API (e.g. Ollama, vLLM, LM Studio).
+	// It requires a base URL to be set with WithBaseURL.
+	OpenAICompatible types.LLMProvider = "openai-compatible"
+	Gemini           types.LLMProvider = "gemini" // Google Gemini API provider (e.g., Gemini 2.0 Flash)
+	// AzureOpenAI is an Azure OpenAI deployment. The resource endpoint is set with WithBaseURL.
+	AzureOpenAI types.LLMProvider = "azure-openai"
+	// Bedrock is Anthropic's Claude models on AWS Bedrock. The model is a Bedrock model ID or inference profile.
+	Bedrock types.LLMProvider = "bedrock"
 )
 
+// defaultAzureAPIVersion is the Azure OpenAI API version used when none is configured.
+const defaultAzureAPIVersion = "2024-10-21"
+
 type config struct {
 	provider types.LLMProvider
 	model    string
 	apiKey   string
-	logger   *slog.Logger
+	baseURL  string
+	// disableJSONResponseFormat disables the provider's JSON response format for models that don't support it
+	disableJSONResponseFormat bool
+	// azureDeployment is the name of the Azure OpenAI deployment, defaults to the model
+	azureDeployment string
+	// azureAPIVersion is the Azure OpenAI API version
+	azureAPIVersion string
+	// awsRegion is the region of the Bedrock endpoint
+	awsRegion string
+	// awsCredentials are the credentials used to sign Bedrock requests
+	awsCredentials *awsCredentials
+	// maxRetries is the number of times a request failing with a transient error is retried
+	maxRetries int
+	// initialBackoff is the delay before the first retry
+	initialBackoff time.Duration
+	// maxBackoff is the maximum delay between retries
+	maxBackoff time.Duration
+	// requestTimeout bounds each request, zero means no timeout
+	requestTimeout time.Duration
+	// http configures the HTTP client used to reach the provider
+	http httpclient.Config
+	// middlewares wrap every completion, the first one being the outermost
+	middlewares []Middleware
+	logger      *slog.Logger
 }
 
 type Option func(*config)
@@ -56,17 +97,141 @@ func WithAPIKey(apiKey string) Option {
 	}
 }
 
+// WithBaseURL sets the base URL of the provider's API, overriding the provider default.
+// It is required for the OpenAICompatible provider, e.g. "http://localhost:11434/v1" for Ollama.
+func WithBaseURL(baseURL string) Option {
+	return func(c *config) {
+		c.baseURL = baseURL
+	}
+}
+
+// WithJSONResponseFormat sets whether the JSON response format is requested from the provider. Defaults to true.
+// Disable it for models that reject the `response_format` parameter; the JSON is then extracted
+// from the plain text response instead.
+func WithJSONResponseFormat(enabled bool) Option {
+	return func(c *config) {
+		c.disableJSONResponseFormat = !enabled
+	}
+}
+
+// WithAzureDeployment sets the name of the Azure OpenAI deployment. Defaults to the model.
+func WithAzureDeployment(deployment string) Option {
+	return func(c *config) {
+		c.azureDeployment = deployment
+	}
+}
+
+// WithAzureAPIVersion sets the Azure OpenAI API version, e.g. "2024-10-21".
+func WithAzureAPIVersion(apiVersion string) Option {
+	return func(c *config) {
+		c.azureAPIVersion = apiVersion
+	}
+}
+
+// WithAWSRegion sets the AWS region of the Bedrock endpoint.
+// Defaults to the AWS_REGION or AWS_DEFAULT_REGION environment variable.
+func WithAWSRegion(region string) Option {
+	return func(c *config) {
+		c.awsRegion = region
+	}
+}
+
+// WithAWSCredentials sets the static AWS credentials used to sign Bedrock requests.
+// Defaults to the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment variables.
+func WithAWSCredentials(accessKeyID, secretAccessKey, sessionToken string) Option {
+	return func(c *config) {
+		c.awsCredentials = &awsCredentials{
+			accessKeyID:     accessKeyID,
+			secretAccessKey: secretAccessKey,
+			sessionToken:    sessionToken,
+		}
+	}
+}
+
+// WithMaxRetries sets the number of times a request failing with a transient error
+// (rate limit, server error, timeout or connection reset) is retried. Defaults to constants.DEFAULT_LLM_MAX_RETRIES.
+// Set it to 0 to disable retries.
+func WithMaxRetries(maxRetries int) Option {
+	return func(c *config) {
+		c.maxRetries = maxRetries
+	}
+}
+
+// WithBackoff sets the delay before the first retry and the maximum delay between retries.
+// The delay doubles with each retry and is jittered. A Retry-After delay requested by the provider
+// takes precedence, up to the maximum delay.
+// Defaults to constants.DEFAULT_LLM_INITIAL_BACKOFF and constants.DEFAULT_LLM_MAX_BACKOFF.
+func WithBackoff(initialBackoff, maxBackoff time.Duration) Option {
+	return func(c *config) {
+		c.initialBackoff = initialBackoff
+		c.maxBackoff = maxBackoff
+	}
+}
+
+// WithRequestTimeout sets the timeout of each request made to the provider. A request that times out is retried.
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(c *config) {
+		c.requestTimeout = timeout
+	}
+}
+
+// WithHTTPClient sets the HTTP client used to reach the provider.
+// It takes precedence over WithTransport, WithProxy and WithCABundle.
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *config) {
+		c.http.Client = client
+	}
+}
+
+// WithTransport sets the transport of the HTTP client used to reach the provider.
+func WithTransport(transport http.RoundTripper) Option {
+	return func(c *config) {
+		c.http.Transport = transport
+	}
+}
+
+// WithProxy sets the URL of the proxy requests are sent through, e.g. "http://proxy.corp:3128".
+// Defaults to the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+func WithProxy(proxyURL string) Option {
+	return func(c *config) {
+		c.http.ProxyURL = proxyURL
+	}
+}
+
+// WithCABundle sets the path of a PEM file with additional root certificates to trust,
+// e.g. the certificate of a TLS-intercepting corporate proxy.
+func WithCABundle(path string) Option {
+	return func(c *config) {
+		c.http.CABundlePath = path
+	}
+}
+
+// WithLogger sets the logger for the LLM client.
 func WithLogger(logger *slog.Logger) Option {
 	return func(c *config) {
 		c.logger = logger
 	}
 }
 
+// WithMiddleware adds middlewares wrapping every completion of the client, including its retries.
+// The middlewares are applied in order, the first one being the outermost.
+func WithMiddleware(middlewares ...Middleware) Option {
+	return func(c *config) {
+		c.middlewares = append(c.middlewares, middlewares...)
+	}
+}
+
+// Handler gets a completion for the given prompt. The schema is nil for plain JSON completions.
+type Handler func(ctx context.Context, prompt string, image []byte, schema *types.JSONSchema) (*types.JSONCompletion, error)
+
 // llmClient represents a client for interacting with Language Model APIs.
 // It encapsulates the provider configuration and json completion request handler.
 type llmClient struct {
-	config  *config
-	handler func(ctx context.Context, prompt string, image []byte) (*types.JSONCompletion, error)
+	config *config
+	// handler sends a single completion request to the provider
+	handler Handler
+	// complete gets a completion with retries, wrapped by the middlewares
+	complete Handler
 }
 
 // NewLLMClient creates a new LLM client instance with the specified configuration.
@@ -78,7 +243,7 @@ type llmClient struct {
 //   - error: Any initialization errors
 func NewLLMClient(opts ...Option) (*llmClient, error) {
 
-	cfg := &config{}
+	cfg := &config{maxRetries: constants.DEFAULT_LLM_MAX_RETRIES}
 	for _, opt := range opts {
 		opt(cfg)
 	}
@@ -91,8 +256,26 @@ func NewLLMClient(opts ...Option) (*llmClient, error) {
 	if cfg.logger == nil {
 		cfg.logger = logging.DefaultLogger
 	}
+	if cfg.maxRetries < 0 {
+		cfg.maxRetries = 0
+	}
+	if cfg.initialBackoff <= 0 {
+		cfg.initialBackoff = constants.DEFAULT_LLM_INITIAL_BACKOFF
+	}
+	if cfg.maxBackoff <= 0 {
+		cfg.maxBackoff = constants.DEFAULT_LLM_MAX_BACKOFF
+	}
+	if cfg.maxBackoff < cfg.initialBackoff {
+		cfg.maxBackoff = cfg.initialBackoff
+	}
+
+	// The HTTP client and the provider clients are built once so that connections are reused
+	httpClient, err := httpclient.New(&cfg.http)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create http client: %w", err)
+	}
 
-	var handler func(ctx context.Context, prompt string, image []byte) (*types.JSONCompletion, error)
+	var handler Handler
 	switch cfg.provider {
 	case Anthropic:
 		betas := []anthropic.AnthropicBeta{}
@@ -102,23 +285,113 @@ func NewLLMClient(opts ...Option) (*llmClient, error) {
 		if strings.HasPrefix(cfg.model, "claude-3-7-sonnet") {
 			betas = append(betas, anthropic.AnthropicBetaComputerUse2025_01_24)
 		}
-		handler = func(ctx context.Context, prompt string, image []byte) (*types.JSONCompletion, error) {
-			client := anthropic.NewClient(anthropicOption.WithAPIKey(cfg.apiKey))
+		// Retries are handled by the client, see completeWithRetries
+		options := []anthropicOption.RequestOption{
+			anthropicOption.WithAPIKey(cfg.apiKey), anthropicOption.WithMaxRetries(0),
+		}
+		if cfg.baseURL != "" {
+			options = append(options, anthropicOption.WithBaseURL(withTrailingSlash(cfg.baseURL)))
+		}
+		client := anthropic.NewClient(append(options, anthropicOption.WithHTTPClient(httpClient))...)
+		handler = func(ctx context.Context, prompt string, image []byte, schema *types.JSONSchema) (*types.JSONCompletion, error) {
+			return requestAnthropic(
+				ctx, client, cfg.provider, cfg.model, prompt, image, schema, &betas,
+			)
+		}
+
+	case Bedrock:
+		region, credentials, err := resolveBedrockConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		baseURL := cfg.baseURL
+		if baseURL == "" {
+			baseURL = fmt.Sprintf("https://bedrock-runtime.%s.amazonaws.com", region)
+		}
+		options := []anthropicOption.RequestOption{
+			anthropicOption.WithBaseURL(withTrailingSlash(baseURL)),
+			anthropicOption.WithMiddleware(bedrockMiddleware(region, credentials)),
+			anthropicOption.WithMaxRetries(0),
+		}
+		client := anthropic.NewClient(append(options, anthropicOption.WithHTTPClient(httpClient))...)
+		handler = func(ctx context.Context, prompt string, image []byte, schema *types.JSONSchema) (*types.JSONCompletion, error) {
 			return requestAnthropic(
-				ctx, client, cfg.provider, cfg.model, prompt, image, &betas,
+				ctx, client, cfg.provider, cfg.model, prompt, image, schema, nil,
 			)
 		}
 
-	case OpenAI, Groq, OpenRouter:
-		options := []openaiOption.RequestOption{openaiOption.WithAPIKey(cfg.apiKey)}
-		if cfg.provider == Groq {
-			options = append(options, openaiOption.WithBaseURL("https://api.groq.com/openai/v1/"))
-		} else if cfg.provider == OpenRouter {
-			options = append(options, openaiOption.WithBaseURL("https://openrouter.ai/api/v1/"))
+	case AzureOpenAI:
+		if cfg.baseURL == "" {
+			return nil, errors.New("endpoint is required for azure-openai provider")
+		}
+		deployment := cfg.azureDeployment
+		if deployment == "" {
+			deployment = cfg.model
+		}
+		apiVersion := cfg.azureAPIVersion
+		if apiVersion == "" {
+			apiVersion = defaultAzureAPIVersion
+		}
+		options := []openaiOption.RequestOption{
+			openaiOption.WithBaseURL(fmt.Sprintf(
+				"%sopenai/deployments/%s/", withTrailingSlash(cfg.baseURL), url.PathEscape(deployment),
+			)),
+			openaiOption.WithQueryAdd("api-version", apiVersion),
+			openaiOption.WithHeader("Api-Key", cfg.apiKey),
+			// Never forward an OPENAI_API_KEY picked up from the environment to the Azure endpoint
+			openaiOption.WithHeaderDel("Authorization"),
+			openaiOption.WithMaxRetries(0),
 		}
-		handler = func(ctx context.Context, prompt string, image []byte) (*types.JSONCompletion, error) {
+		jsonResponseFormat := !cfg.disableJSONResponseFormat
+		client := openai.NewClient(append(options, openaiOption.WithHTTPClient(httpClient))...)
+		handler = func(ctx context.Context, prompt string, image []byte, schema *types.JSONSchema) (*types.JSONCompletion, error) {
 			return requestOpenAI(
-				ctx, openai.NewClient(options...), cfg.provider, cfg.model, prompt, image,
+				ctx, client, cfg.provider, cfg.model, prompt, image, jsonResponseFormat, schema,
+			)
+		}
+
+	case OpenAI, Groq, OpenRouter, OpenAICompatible:
+		baseURL := cfg.baseURL
+		if baseURL == "" {
+			switch cfg.provider {
+			case Groq:
+				baseURL = "https://api.groq.com/openai/v1/"
+			case OpenRouter:
+				baseURL = "https://openrouter.ai/api/v1/"
+			case OpenAICompatible:
+				return nil, errors.New("base url is required for openai-compatible provider")
+			}
+		}
+		options := []openaiOption.RequestOption{
+			openaiOption.WithAPIKey(cfg.apiKey), openaiOption.WithMaxRetries(0),
+		}
+		if baseURL != "" {
+			options = append(options, openaiOption.WithBaseURL(withTrailingSlash(baseURL)))
+		}
+		jsonResponseFormat := !cfg.disableJSONResponseFormat
+		// The json_schema response format isn't supported by every OpenAI-compatible endpoint,
+		// structured completions fall back to the JSON response format for them
+		jsonSchemaSupported := cfg.provider == OpenAI
+		client := openai.NewClient(append(options, openaiOption.WithHTTPClient(httpClient))...)
+		handler = func(ctx context.Context, prompt string, image []byte, schema *types.JSONSchema) (*types.JSONCompletion, error) {
+			if !jsonSchemaSupported {
+				schema = nil
+			}
+			return requestOpenAI(
+				ctx, client, cfg.provider, cfg.model, prompt, image, jsonResponseFormat, schema,
+			)
+		}
+
+	case Gemini:
+		baseURL := cfg.baseURL
+		if baseURL == "" {
+			baseURL = defaultGeminiBaseURL
+		}
+		jsonResponseFormat := !cfg.disableJSONResponseFormat
+		// Structured completions fall back to the JSON response mime type
+		handler = func(ctx context.Context, prompt string, image []byte, schema *types.JSONSchema) (*types.JSONCompletion, error) {
+			return requestGemini(
+				ctx, httpClient, baseURL, cfg.apiKey, cfg.provider, cfg.model, prompt, image, jsonResponseFormat,
 			)
 		}
 
@@ -126,7 +399,13 @@ func NewLLMClient(opts ...Option) (*llmClient, error) {
 		return nil, errors.New("invalid provider for llm")
 	}
 
-	return &llmClient{config: cfg, handler: handler}, nil
+	client := &llmClient{config: cfg, handler: handler}
+	complete := chain(client.completeWithRetries, cfg.middlewares)
+	model := requestModel{provider: cfg.provider, model: cfg.model}
+	client.complete = func(ctx context.Context, prompt string, image []byte, schema *types.JSONSchema) (*types.JSONCompletion, error) {
+		return complete(context.WithValue(ctx, requestModelKey{}, model), prompt, image, schema)
+	}
+	return client, nil
 }
 
 var errDefaultLLMAPIKeyNotSet = errors.New("'LOCATR_ANTHROPIC_API_KEY' or 'ANTHROPIC_API_KEY' environment variable is not set")
@@ -164,7 +443,24 @@ func (client *llmClient) GetJSONCompletion(ctx context.Context, prompt string, i
 		"[LLM Completion] provider: %v, model: %v", client.config.provider, client.config.model,
 	)
 	defer logging.CreateTopic(topic, client.config.logger)()
-	return client.handler(ctx, prompt, image)
+	return client.complete(ctx, prompt, image, nil)
+}
+
+// GetStructuredCompletion returns the JSON completion for the given prompt, conforming to the given schema.
+// The schema is enforced with tool use for Anthropic and Bedrock, and with the json_schema response format
+// for OpenAI and Azure OpenAI. Other providers fall back to the JSON completion.
+func (client *llmClient) GetStructuredCompletion(
+	ctx context.Context, prompt string, image []byte, schema *types.JSONSchema,
+) (*types.JSONCompletion, error) {
+	if schema == nil {
+		return nil, errors.New("schema is required for structured completion")
+	}
+	topic := fmt.Sprintf(
+		"[LLM Structured Completion] provider: %v, model: %v, schema: %v",
+		client.config.provider, client.config.model, schema.Name,
+	)
+	defer logging.CreateTopic(topic, client.config.logger)()
+	return client.complete(ctx, prompt, image, schema)
 }
 
 // GetProvider returns the configured LLM service provider for this client.
@@ -177,7 +473,15 @@ func (client *llmClient) GetModel() string {
 	return client.config.model
 }
 
-// requestOpenAI handles API requests to OpenAI-compatible endpoints (OpenAI, Groq, OpenRouter).
+// withTrailingSlash makes sure the base URL ends with a slash, so that API paths are resolved relative to it.
+func withTrailingSlash(baseURL string) string {
+	if strings.HasSuffix(baseURL, "/") {
+		return baseURL
+	}
+	return baseURL + "/"
+}
+
+// requestOpenAI handles API requests to OpenAI-compatible endpoints (OpenAI, Groq, OpenRouter, self-hosted).
 //
 // Parameters:
 //   - ctx: Context
@@ -186,12 +490,14 @@ func (client *llmClient) GetModel() string {
 //   - model: Model identifier
 //   - prompt: The input prompt
 //   - image: Optional image data for vision models
+//   - jsonResponseFormat: Whether to request the JSON object response format
+//   - schema: Optional schema enforced with the json_schema response format
 //
 // Returns:
 //   - *types.JSONCompletion: The API response and metadata
 //   - error: Any API or processing errors
 func requestOpenAI(
-	ctx context.Context, client *openai.Client, provider types.LLMProvider, model, prompt string, image []byte,
+	ctx context.Context, client *openai.Client, provider types.LLMProvider, model, prompt string, image []byte, jsonResponseFormat bool, schema *types.JSONSchema,
 ) (*types.JSONCompletion, error) {
 	completion := &types.JSONCompletion{
 		LLMCompletionMeta: types.LLMCompletionMeta{
@@ -205,24 +511,40 @@ func requestOpenAI(
 		openai.UserMessage(prompt),
 	}
 	if image != nil {
-		messages = append(messages, openai.UserMessageParts(
-			openai.ImagePart(fmt.Sprintf(
-				"data:image/jpeg;base64,%s", base64.StdEncoding.EncodeToString(image),
-			)),
+		imagePart := openai.ImagePart(fmt.Sprintf(
+			"data:%s;base64,%s", http.DetectContentType(image), base64.StdEncoding.EncodeToString(image),
 		))
+		if detail := types.ContextImageDetail(ctx); detail != "" {
+			imagePart.ImageURL.Value.Detail = openai.F(openai.ChatCompletionContentPartImageImageURLDetail(detail))
+		}
+		messages = append(messages, openai.UserMessageParts(imagePart))
 	}
 
-	response, err := client.Chat.Completions.New(
-		ctx, openai.ChatCompletionNewParams{
-			Model:    openai.F(model),
-			Messages: openai.F(messages),
-			ResponseFormat: openai.F[openai.ChatCompletionNewParamsResponseFormatUnion](
-				openai.ResponseFormatJSONObjectParam{
-					Type: openai.F(openai.ResponseFormatJSONObjectTypeJSONObject),
-				},
-			),
-		},
-	)
+	params := openai.ChatCompletionNewParams{
+		Model:    openai.F(model),
+		Messages: openai.F(messages),
+	}
+	if schema != nil {
+		params.ResponseFormat = openai.F[openai.ChatCompletionNewParamsResponseFormatUnion](
+			openai.ResponseFormatJSONSchemaParam{
+				Type: openai.F(openai.ResponseFormatJSONSchemaTypeJSONSchema),
+				JSONSchema: openai.F(openai.ResponseFormatJSONSchemaJSONSchemaParam{
+					Name:        openai.F(schema.Name),
+					Description: openai.F(schema.Description),
+					Schema:      openai.F[any](schema.Schema),
+					Strict:      openai.F(true),
+				}),
+			},
+		)
+	} else if jsonResponseFormat {
+		params.ResponseFormat = openai.F[openai.ChatCompletionNewParamsResponseFormatUnion](
+			openai.ResponseFormatJSONObjectParam{
+				Type: openai.F(openai.ResponseFormatJSONObjectTypeJSONObject),
+			},
+		)
+	}
+
+	response, err := client.Chat.Completions.New(ctx, params)
 	if err != nil {
 		return completion, fmt.Errorf("failed to get completion from %v: %w", provider, err)
 	}
@@ -230,7 +552,15 @@ func requestOpenAI(
 	completion.InputTokens = int(response.Usage.PromptTokens)
 	completion.OutputTokens = int(response.Usage.CompletionTokens)
 
-	jsonStr, err := utils.ParseJSON(response.Choices[0].Message.Content)
+	if len(response.Choices) == 0 {
+		return completion, fmt.Errorf("no choices in completion from %v", provider)
+	}
+	content := response.Choices[0].Message.Content
+	if schema == nil && !jsonResponseFormat {
+		// Without the JSON response format, the JSON may be surrounded by reasoning or prose
+		content = utils.ExtractJSON(content)
+	}
+	jsonStr, err := utils.ParseJSON(content)
 	if err != nil {
 		return completion, fmt.Errorf("failed to parse JSON: %w", err)
 	}
@@ -246,13 +576,14 @@ func requestOpenAI(
 //   - model: Claude model identifier
 //   - prompt: The input prompt
 //   - image: Optional image data for vision models
+//   - schema: Optional schema enforced with a forced tool use
 //   - betas: Optional beta features to enable
 //
 // Returns:
 //   - *types.JSONCompletion: The API response and metadata
 //   - error: Any API or processing errors
 func requestAnthropic(
-	ctx context.Context, client *anthropic.Client, provider types.LLMProvider, model, prompt string, image []byte, betas *[]anthropic.AnthropicBeta,
+	ctx context.Context, client *anthropic.Client, provider types.LLMProvider, model, prompt string, image []byte, schema *types.JSONSchema, betas *[]anthropic.AnthropicBeta,
 ) (*types.JSONCompletion, error) {
 	completion := &types.JSONCompletion{
 		LLMCompletionMeta: types.LLMCompletionMeta{
@@ -263,33 +594,63 @@ func requestAnthropic(
 		},
 	}
 
-	messageContent := []anthropic.BetaContentBlockParamUnion{
-		anthropic.BetaTextBlockParam{
-			Type: anthropic.F(anthropic.BetaTextBlockParamTypeText),
-			Text: anthropic.String(prompt),
-		},
-	}
+	messageContent := anthropicPromptBlocks(prompt, types.PromptCachePrefixes(ctx))
 	if image != nil {
 		messageContent = append(messageContent, anthropic.BetaImageBlockParam{
 			Type: anthropic.F(anthropic.BetaImageBlockParamTypeImage),
 			Source: anthropic.F[anthropic.BetaImageBlockParamSourceUnion](
 				anthropic.BetaImageBlockParamSource{
 					Type:      anthropic.F(anthropic.BetaImageBlockParamSourceTypeBase64),
-					MediaType: anthropic.F(anthropic.BetaImageBlockParamSourceMediaTypeImagePNG),
+					MediaType: anthropic.F(anthropicMediaType(image)),
 					Data:      anthropic.F(base64.StdEncoding.EncodeToString(image)),
 				},
 			),
 		})
 	}
 
+	userMessage := anthropic.BetaMessageParam{
+		Role:    anthropic.F(anthropic.BetaMessageParamRoleUser),
+		Content: anthropic.F(messageContent),
+	}
+	// Unknown models get the default, which is within the output limit of every Claude model
+	capabilities, _ := types.LookupModelCapabilities(model)
 	params := anthropic.BetaMessageNewParams{
 		Model:     anthropic.F(model),
-		MaxTokens: anthropic.F(int64(1024)),
-		Messages: anthropic.F([]anthropic.BetaMessageParam{
-			{
-				Role:    anthropic.F(anthropic.BetaMessageParamRoleUser),
-				Content: anthropic.F(messageContent),
+		MaxTokens: anthropic.F(int64(capabilities.CompletionTokens())),
+	}
+	if schema != nil {
+		// The model is forced to call a tool whose input is the structured output
+		inputSchema := anthropic.BetaToolInputSchemaParam{
+			Type:        anthropic.F(anthropic.BetaToolInputSchemaTypeObject),
+			ExtraFields: map[string]any{},
+		}
+		for key, value := range schema.Schema {
+			switch key {
+			case "type":
+			case "properties":
+				inputSchema.Properties = anthropic.F(value)
+			default:
+				inputSchema.ExtraFields[key] = value
+			}
+		}
+		params.Messages = anthropic.F([]anthropic.BetaMessageParam{userMessage})
+		params.Tools = anthropic.F([]anthropic.BetaToolUnionUnionParam{
+			anthropic.BetaToolParam{
+				Name:        anthropic.F(schema.Name),
+				Description: anthropic.F(schema.Description),
+				InputSchema: anthropic.F(inputSchema),
 			},
+		})
+		params.ToolChoice = anthropic.F[anthropic.BetaToolChoiceUnionParam](
+			anthropic.BetaToolChoiceToolParam{
+				Type:                   anthropic.F(anthropic.BetaToolChoiceToolTypeTool),
+				Name:                   anthropic.F(schema.Name),
+				DisableParallelToolUse: anthropic.F(true),
+			},
+		)
+	} else {
+		params.Messages = anthropic.F([]anthropic.BetaMessageParam{
+			userMessage,
 			{
 				Role: anthropic.F(anthropic.BetaMessageParamRoleAssistant),
 				Content: anthropic.F([]anthropic.BetaContentBlockParamUnion{
@@ -299,8 +660,8 @@ func requestAnthropic(
 					},
 				}),
 			},
-		}),
-		StopSequences: anthropic.F([]string{"```"}),
+		})
+		params.StopSequences = anthropic.F([]string{"```"})
 	}
 	if betas != nil {
 		params.Betas = anthropic.F(*betas)
@@ -313,7 +674,26 @@ func requestAnthropic(
 
 	completion.InputTokens = int(response.Usage.InputTokens)
 	completion.OutputTokens = int(response.Usage.OutputTokens)
+	completion.CacheCreationInputTokens = int(response.Usage.CacheCreationInputTokens)
+	completion.CacheReadInputTokens = int(response.Usage.CacheReadInputTokens)
+
+	if schema != nil {
+		for _, block := range response.Content {
+			if block.Type == anthropic.BetaContentBlockTypeToolUse && block.Name == schema.Name {
+				input, err := json.Marshal(block.Input)
+				if err != nil {
+					return completion, fmt.Errorf("failed to encode tool input: %w", err)
+				}
+				completion.JSON = string(input)
+				return completion, nil
+			}
+		}
+		return completion, fmt.Errorf("no %v tool use in completion from %v", schema.Name, provider)
+	}
 
+	if len(response.Content) == 0 {
+		return completion, fmt.Errorf("no content in completion from %v", provider)
+	}
 	jsonStr, err := utils.ParseJSON(response.Content[0].Text)
 	if err != nil {
 		return completion, fmt.Errorf("failed to parse JSON: %w", err)
@@ -321,3 +701,58 @@ func requestAnthropic(
 	completion.JSON = jsonStr
 	return completion, nil
 }
+
+// anthropicMediaType returns the media type of the given image, defaulting to PNG for unsupported types.
+func anthropicMediaType(image []byte) anthropic.BetaImageBlockParamSourceMediaType {
+	mediaType := anthropic.BetaImageBlockParamSourceMediaType(http.DetectContentType(image))
+	if !mediaType.IsKnown() {
+		return anthropic.BetaImageBlockParamSourceMediaTypeImagePNG
+	}
+	return mediaType
+}
+
+// maxAnthropicCacheBreakpoints is the maximum number of cache_control breakpoints in an Anthropic request.
+const maxAnthropicCacheBreakpoints = 4
+
+// anthropicPromptBlocks splits the prompt into text blocks at the end of its cache prefixes
+// (see types.WithPromptCachePrefixes). Each block ending a prefix gets an ephemeral cache_control, so that
+// the prefix is cached and billed at a reduced rate when it's sent again. Prefixes that don't start the prompt
+// are ignored, and only the longest ones are kept beyond the limit.
+func anthropicPromptBlocks(prompt string, prefixes []string) []anthropic.BetaContentBlockParamUnion {
+	ends := []int{}
+	for _, prefix := range prefixes {
+		// Anthropic rejects text blocks without any non-whitespace text
+		if strings.TrimSpace(prefix) == "" || len(prefix) == len(prompt) || !strings.HasPrefix(prompt, prefix) {
+			continue
+		}
+		if strings.TrimSpace(prompt[len(prefix):]) == "" || slices.Contains(ends, len(prefix)) {
+			continue
+		}
+		ends = append(ends, len(prefix))
+	}
+	slices.Sort(ends)
+	if len(ends) > maxAnthropicCacheBreakpoints {
+		ends = ends[len(ends)-maxAnthropicCacheBreakpoints:]
+	}
+
+	blocks := []anthropic.BetaContentBlockParamUnion{}
+	start := 0
+	for _, end := range append(ends, len(prompt)) {
+		segment := prompt[start:end]
+		if strings.TrimSpace(segment) == "" {
+			continue
+		}
+		block := anthropic.BetaTextBlockParam{
+			Type: anthropic.F(anthropic.BetaTextBlockParamTypeText),
+			Text: anthropic.String(segment),
+		}
+		if end < len(prompt) {
+			block.CacheControl = anthropic.F(anthropic.BetaCacheControlEphemeralParam{
+				Type: anthropic.F(anthropic.BetaCacheControlEphemeralTypeEphemeral),
+			})
+		}
+		blocks = append(blocks, block)
+		start = end
+	}
+	return blocks
+}
@@ -0,0 +1,417 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/vertexcover-io/locatr/pkg/types"
+)
+
+// newChatCompletionServer starts a stub OpenAI-compatible server that answers every
+// chat completion request with the given content and records the received request body.
+func newChatCompletionServer(t *testing.T, content string, received *map[string]any) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			http.NotFound(w, r)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if received != nil {
+			_ = json.Unmarshal(body, received)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"id":      "chatcmpl-1",
+			"object":  "chat.completion",
+			"created": 0,
+			"model":   "llama3.2",
+			"choices": []map[string]any{{
+				"index":         0,
+				"finish_reason": "stop",
+				"message":       map[string]any{"role": "assistant", "content": content},
+			}},
+			"usage": map[string]any{"prompt_tokens": 12, "completion_tokens": 5, "total_tokens": 17},
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestOpenAICompatibleProvider(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("requests JSON response format by default", func(t *testing.T) {
+		received := map[string]any{}
+		server := newChatCompletionServer(t, `{"element_id": "abc"}`, &received)
+
+		client, err := NewLLMClient(
+			WithProvider(OpenAICompatible),
+			WithModel("llama3.2"),
+			WithBaseURL(server.URL+"/v1"),
+		)
+		assert.NoError(t, err)
+
+		completion, err := client.GetJSONCompletion(ctx, "find the button", nil)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"element_id": "abc"}`, completion.JSON)
+		assert.Equal(t, 12, completion.InputTokens)
+		assert.Equal(t, 5, completion.OutputTokens)
+		assert.Equal(t, OpenAICompatible, completion.Provider)
+		assert.Equal(t, "llama3.2", received["model"])
+		assert.Equal(t, map[string]any{"type": "json_object"}, received["response_format"])
+	})
+
+	t.Run("extracts JSON when response format is disabled", func(t *testing.T) {
+		received := map[string]any{}
+		server := newChatCompletionServer(
+			t, "<think>The button is {maybe} abc</think>Sure! Here it is:\n```json\n{\"element_id\": \"abc\"}\n```", &received,
+		)
+
+		client, err := NewLLMClient(
+			WithProvider(OpenAICompatible),
+			WithModel("deepseek-r1"),
+			WithBaseURL(server.URL+"/v1/"),
+			WithJSONResponseFormat(false),
+		)
+		assert.NoError(t, err)
+
+		completion, err := client.GetJSONCompletion(ctx, "find the button", nil)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"element_id": "abc"}`, completion.JSON)
+		assert.NotContains(t, received, "response_format")
+	})
+
+	t.Run("requires a base URL", func(t *testing.T) {
+		_, err := NewLLMClient(WithProvider(OpenAICompatible), WithModel("llama3.2"))
+		assert.EqualError(t, err, "base url is required for openai-compatible provider")
+	})
+}
+
+func TestAzureOpenAIProvider(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("OPENAI_API_KEY", "openai-key")
+
+	received := map[string]any{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/openai/deployments/locatr-gpt4o/chat/completions", r.URL.Path)
+		assert.Equal(t, "2024-06-01", r.URL.Query().Get("api-version"))
+		assert.Equal(t, "azure-key", r.Header.Get("Api-Key"))
+		assert.Empty(t, r.Header.Get("Authorization"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{
+			"id": "chatcmpl-1", "object": "chat.completion", "created": 0, "model": "gpt-4o",
+			"choices": [{"index": 0, "finish_reason": "stop", "message": {"role": "assistant", "content": "{\"locator_id\": \"abc\"}"}}],
+			"usage": {"prompt_tokens": 30, "completion_tokens": 7, "total_tokens": 37}
+		}`))
+	}))
+	defer server.Close()
+
+	client, err := NewLLMClient(
+		WithProvider(AzureOpenAI),
+		WithModel("gpt-4o"),
+		WithAPIKey("azure-key"),
+		WithBaseURL(server.URL),
+		WithAzureDeployment("locatr-gpt4o"),
+		WithAzureAPIVersion("2024-06-01"),
+	)
+	assert.NoError(t, err)
+
+	completion, err := client.GetJSONCompletion(ctx, "find the button", nil)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"locator_id": "abc"}`, completion.JSON)
+	assert.Equal(t, 30, completion.InputTokens)
+	assert.Equal(t, 7, completion.OutputTokens)
+	assert.Equal(t, AzureOpenAI, completion.Provider)
+
+	_, err = NewLLMClient(WithProvider(AzureOpenAI), WithModel("gpt-4o"))
+	assert.EqualError(t, err, "endpoint is required for azure-openai provider")
+}
+
+// countingTransport counts the requests sent through it.
+type countingTransport struct {
+	requests int
+}
+
+func (t *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	t.requests++
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestHTTPClientOptions(t *testing.T) {
+	ctx := context.Background()
+	server := newChatCompletionServer(t, `{"element_id": "abc"}`, nil)
+
+	t.Run("custom transport is reused across requests", func(t *testing.T) {
+		transport := &countingTransport{}
+		client, err := NewLLMClient(
+			WithProvider(OpenAICompatible),
+			WithModel("llama3.2"),
+			WithBaseURL(server.URL+"/v1"),
+			WithTransport(transport),
+		)
+		assert.NoError(t, err)
+
+		for range 3 {
+			_, err := client.GetJSONCompletion(ctx, "find the button", nil)
+			assert.NoError(t, err)
+		}
+		assert.Equal(t, 3, transport.requests)
+	})
+
+	t.Run("custom http client", func(t *testing.T) {
+		transport := &countingTransport{}
+		client, err := NewLLMClient(
+			WithProvider(Anthropic),
+			WithModel("claude-3-5-sonnet-latest"),
+			WithBaseURL(server.URL),
+			WithHTTPClient(&http.Client{Transport: transport}),
+			WithMaxRetries(0),
+		)
+		assert.NoError(t, err)
+
+		// The stub only serves chat completions, the request is expected to fail
+		_, err = client.GetJSONCompletion(ctx, "find the button", nil)
+		assert.Error(t, err)
+		assert.Equal(t, 1, transport.requests)
+	})
+
+	t.Run("invalid proxy", func(t *testing.T) {
+		_, err := NewLLMClient(
+			WithProvider(OpenAI), WithModel("gpt-4o"), WithProxy("not a url"),
+		)
+		assert.EqualError(t, err, `couldn't create http client: invalid proxy url: "not a url"`)
+	})
+}
+
+func TestGetStructuredCompletion(t *testing.T) {
+	ctx := context.Background()
+	schema := &types.JSONSchema{
+		Name:        "dom_analysis_output",
+		Description: "The matching element",
+		Schema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"element_id": map[string]any{"type": "string"},
+			},
+			"required":             []string{"element_id"},
+			"additionalProperties": false,
+		},
+	}
+
+	t.Run("anthropic forces tool use", func(t *testing.T) {
+		received := map[string]any{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/v1/messages", r.URL.Path)
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{
+				"id": "msg_1", "type": "message", "role": "assistant", "model": "claude-3-5-haiku-latest",
+				"content": [
+					{"type": "text", "text": "Looking at the DOM..."},
+					{"type": "tool_use", "id": "toolu_1", "name": "dom_analysis_output", "input": {"element_id": "abc"}}
+				],
+				"stop_reason": "tool_use",
+				"usage": {"input_tokens": 400, "output_tokens": 20}
+			}`))
+		}))
+		defer server.Close()
+
+		client, err := NewLLMClient(
+			WithProvider(Anthropic), WithModel("claude-3-5-haiku-latest"), WithBaseURL(server.URL),
+		)
+		assert.NoError(t, err)
+
+		completion, err := client.GetStructuredCompletion(ctx, "find the button", nil, schema)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"element_id": "abc"}`, completion.JSON)
+		assert.Equal(t, 400, completion.InputTokens)
+
+		assert.Equal(t, map[string]any{
+			"type": "tool", "name": "dom_analysis_output", "disable_parallel_tool_use": true,
+		}, received["tool_choice"])
+		tools := received["tools"].([]any)
+		assert.Len(t, tools, 1)
+		assert.Equal(t, map[string]any{
+			"type":                 "object",
+			"properties":           map[string]any{"element_id": map[string]any{"type": "string"}},
+			"required":             []any{"element_id"},
+			"additionalProperties": false,
+		}, tools[0].(map[string]any)["input_schema"])
+		assert.NotContains(t, received, "stop_sequences")
+		assert.Len(t, received["messages"], 1)
+	})
+
+	t.Run("openai uses json_schema response format", func(t *testing.T) {
+		received := map[string]any{}
+		server := newChatCompletionServer(t, `{"element_id": "abc"}`, &received)
+
+		client, err := NewLLMClient(WithProvider(OpenAI), WithModel("gpt-4o"), WithBaseURL(server.URL+"/v1"))
+		assert.NoError(t, err)
+
+		completion, err := client.GetStructuredCompletion(ctx, "find the button", nil, schema)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"element_id": "abc"}`, completion.JSON)
+
+		responseFormat := received["response_format"].(map[string]any)
+		assert.Equal(t, "json_schema", responseFormat["type"])
+		jsonSchema := responseFormat["json_schema"].(map[string]any)
+		assert.Equal(t, "dom_analysis_output", jsonSchema["name"])
+		assert.Equal(t, true, jsonSchema["strict"])
+		assert.Equal(t, "object", jsonSchema["schema"].(map[string]any)["type"])
+	})
+
+	t.Run("other providers fall back to JSON mode", func(t *testing.T) {
+		received := map[string]any{}
+		server := newChatCompletionServer(t, `{"element_id": "abc"}`, &received)
+
+		client, err := NewLLMClient(WithProvider(Groq), WithModel("llama-3.3-70b"), WithBaseURL(server.URL+"/v1"))
+		assert.NoError(t, err)
+
+		completion, err := client.GetStructuredCompletion(ctx, "find the button", nil, schema)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"element_id": "abc"}`, completion.JSON)
+		assert.Equal(t, map[string]any{"type": "json_object"}, received["response_format"])
+	})
+
+	t.Run("schema is required", func(t *testing.T) {
+		client, err := NewLLMClient(WithProvider(OpenAI), WithModel("gpt-4o"))
+		assert.NoError(t, err)
+		_, err = client.GetStructuredCompletion(ctx, "find the button", nil, nil)
+		assert.EqualError(t, err, "schema is required for structured completion")
+	})
+}
+
+func TestPromptCaching(t *testing.T) {
+	prompt := "instructions dom request"
+	ctx := types.WithPromptCachePrefixes(context.Background(), "instructions", "instructions dom", "rewritten")
+
+	t.Run("anthropic caches the prompt prefixes", func(t *testing.T) {
+		received := map[string]any{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{
+				"id": "msg_1", "type": "message", "role": "assistant", "model": "claude-3-5-haiku-latest",
+				"content": [{"type": "text", "text": "{\"element_id\": \"abc\"}"}],
+				"stop_reason": "stop_sequence",
+				"usage": {
+					"input_tokens": 20, "output_tokens": 10,
+					"cache_creation_input_tokens": 300, "cache_read_input_tokens": 1200
+				}
+			}`))
+		}))
+		defer server.Close()
+
+		client, err := NewLLMClient(
+			WithProvider(Anthropic), WithModel("claude-3-5-haiku-latest"), WithBaseURL(server.URL),
+		)
+		assert.NoError(t, err)
+
+		completion, err := client.GetJSONCompletion(ctx, prompt, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, 20, completion.InputTokens)
+		assert.Equal(t, 300, completion.CacheCreationInputTokens)
+		assert.Equal(t, 1200, completion.CacheReadInputTokens)
+		assert.Equal(t, float64(types.DefaultMaxCompletionTokens), received["max_tokens"])
+
+		ephemeral := map[string]any{"type": "ephemeral"}
+		content := received["messages"].([]any)[0].(map[string]any)["content"]
+		assert.Equal(t, []any{
+			map[string]any{"type": "text", "text": "instructions", "cache_control": ephemeral},
+			map[string]any{"type": "text", "text": " dom", "cache_control": ephemeral},
+			map[string]any{"type": "text", "text": " request"},
+		}, content)
+	})
+
+	t.Run("other providers send the prompt as is", func(t *testing.T) {
+		received := map[string]any{}
+		server := newChatCompletionServer(t, `{"element_id": "abc"}`, &received)
+
+		client, err := NewLLMClient(WithProvider(OpenAI), WithModel("gpt-4o"), WithBaseURL(server.URL+"/v1"))
+		assert.NoError(t, err)
+
+		_, err = client.GetJSONCompletion(ctx, prompt, nil)
+		assert.NoError(t, err)
+		message := received["messages"].([]any)[0].(map[string]any)
+		assert.Equal(t, []any{
+			map[string]any{"type": "text", "text": "instructions dom request"},
+		}, message["content"])
+	})
+}
+
+func TestImageMediaType(t *testing.T) {
+	received := map[string]any{}
+	server := newChatCompletionServer(t, `{"element_point": "1, 2"}`, &received)
+
+	client, err := NewLLMClient(WithProvider(OpenAI), WithModel("gpt-4o"), WithBaseURL(server.URL+"/v1"))
+	assert.NoError(t, err)
+	_, err = client.GetJSONCompletion(context.Background(), "find the button", pngHeader)
+	assert.NoError(t, err)
+
+	messages := received["messages"].([]any)
+	part := messages[1].(map[string]any)["content"].([]any)[0].(map[string]any)
+	url := part["image_url"].(map[string]any)["url"].(string)
+	assert.True(t, strings.HasPrefix(url, "data:image/png;base64,"), url)
+	assert.NotContains(t, part["image_url"], "detail")
+
+	ctx := types.WithImageDetail(context.Background(), types.ImageDetailLow)
+	_, err = client.GetJSONCompletion(ctx, "find the button", pngHeader)
+	assert.NoError(t, err)
+	messages = received["messages"].([]any)
+	part = messages[1].(map[string]any)["content"].([]any)[0].(map[string]any)
+	assert.Equal(t, "low", part["image_url"].(map[string]any)["detail"])
+
+	assert.Equal(t, anthropic.BetaImageBlockParamSourceMediaTypeImagePNG, anthropicMediaType(pngHeader))
+	assert.Equal(t, anthropic.BetaImageBlockParamSourceMediaTypeImageJPEG, anthropicMediaType([]byte("\xff\xd8\xff\xe0")))
+	assert.Equal(t, anthropic.BetaImageBlockParamSourceMediaTypeImagePNG, anthropicMediaType([]byte("not an image")))
+}
+
+func TestAnthropicPromptBlocks(t *testing.T) {
+	texts := func(blocks []anthropic.BetaContentBlockParamUnion) (texts []string, cached []bool) {
+		for _, block := range blocks {
+			text := block.(anthropic.BetaTextBlockParam)
+			texts = append(texts, text.Text.Value)
+			cached = append(cached, text.CacheControl.Present)
+		}
+		return texts, cached
+	}
+	tests := []struct {
+		name           string
+		prompt         string
+		prefixes       []string
+		expectedTexts  []string
+		expectedCached []bool
+	}{
+		{name: "no prefixes", prompt: "abc", expectedTexts: []string{"abc"}, expectedCached: []bool{false}},
+		{
+			name: "unsorted and duplicate prefixes", prompt: "a b c", prefixes: []string{"a b", "a", "a b"},
+			expectedTexts: []string{"a", " b", " c"}, expectedCached: []bool{true, true, false},
+		},
+		{
+			name: "whole prompt and whitespace remainders", prompt: "a b ", prefixes: []string{"a b ", "a b", " "},
+			expectedTexts: []string{"a b "}, expectedCached: []bool{false},
+		},
+		{
+			name: "longest prefixes beyond the limit", prompt: "abcdefg", prefixes: []string{"a", "ab", "abc", "abcd", "abcde"},
+			expectedTexts:  []string{"ab", "c", "d", "e", "fg"},
+			expectedCached: []bool{true, true, true, true, false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			texts, cached := texts(anthropicPromptBlocks(tt.prompt, tt.prefixes))
+			assert.Equal(t, tt.expectedTexts, texts)
+			assert.Equal(t, tt.expectedCached, cached)
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package llm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/vertexcover-io/locatr/pkg/types"
+)
+
+// Middleware wraps a completion handler, e.g. to log, measure, mutate or cache completions.
+type Middleware func(next Handler) Handler
+
+// requestModelKey is the context key of the provider and model of the client a completion is requested from.
+type requestModelKey struct{}
+
+// requestModel is the provider and model of the client a completion is requested from, set on the context
+// of the middlewares so that a middleware shared by several clients can tell their completions apart.
+type requestModel struct {
+	provider types.LLMProvider
+	model    string
+}
+
+// chain wraps the handler with the middlewares, the first middleware being the outermost.
+func chain(handler Handler, middlewares []Middleware) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// LoggingMiddleware logs the duration, token usage and error of every completion.
+//
+// Parameters:
+//   - logger: The logger to log the completions with
+//
+// Returns the logging middleware.
+func LoggingMiddleware(logger *slog.Logger) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, prompt string, image []byte, schema *types.JSONSchema) (*types.JSONCompletion, error) {
+			start := time.Now()
+			completion, err := next(ctx, prompt, image, schema)
+			attrs := []any{"duration", time.Since(start).String(), "prompt_length", len(prompt), "image_size", len(image)}
+			if completion != nil {
+				attrs = append(attrs,
+					"provider", completion.Provider, "model", completion.Model, "retries", completion.Retries,
+					"input_tokens", completion.InputTokens, "output_tokens", completion.OutputTokens,
+				)
+			}
+			if err != nil {
+				logger.Error("llm completion failed", append(attrs, "error", err)...)
+			} else {
+				logger.Info("llm completion", attrs...)
+			}
+			return completion, err
+		}
+	}
+}
+
+// CompletionMetrics are the measurements of a completion reported by MetricsMiddleware.
+type CompletionMetrics struct {
+	Provider     types.LLMProvider // Provider of the language model
+	Model        string            // Model used for the completion
+	Duration     time.Duration     // Duration of the completion, including retries
+	InputTokens  int               // Number of input tokens used
+	OutputTokens int               // Number of output tokens generated
+	Retries      int               // Number of retried requests
+	Err          error             // Error of the completion, if it failed
+}
+
+// MetricsMiddleware reports the measurements of every completion, e.g. to a Prometheus or OpenTelemetry exporter.
+//
+// Parameters:
+//   - record: The function called with the measurements of each completion
+//
+// Returns the metrics middleware.
+func MetricsMiddleware(record func(metrics CompletionMetrics)) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, prompt string, image []byte, schema *types.JSONSchema) (*types.JSONCompletion, error) {
+			start := time.Now()
+			completion, err := next(ctx, prompt, image, schema)
+			metrics := CompletionMetrics{Duration: time.Since(start), Err: err}
+			if completion != nil {
+				metrics.Provider, metrics.Model = completion.Provider, completion.Model
+				metrics.InputTokens, metrics.OutputTokens = completion.InputTokens, completion.OutputTokens
+				metrics.Retries = completion.Retries
+			}
+			record(metrics)
+			return completion, err
+		}
+	}
+}
+
+// RedactionMiddleware replaces the matches of the pattern in the prompt before it is sent,
+// e.g. to keep secrets or personal data out of the requests to the provider.
+//
+// Parameters:
+//   - pattern: The pattern to redact
+//   - replacement: The replacement of the matches, which may refer to submatches like regexp.ReplaceAllString
+//
+// Returns the redaction middleware.
+func RedactionMiddleware(pattern *regexp.Regexp, replacement string) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, prompt string, image []byte, schema *types.JSONSchema) (*types.JSONCompletion, error) {
+			return next(ctx, pattern.ReplaceAllString(prompt, replacement), image, schema)
+		}
+	}
+}
+
+// CacheMiddleware keeps the successful completions in memory and returns them for identical requests
+// to the same provider and model, without any token usage. The options carried by the context of the request,
+// its image detail and prompt cache prefixes (see types.WithImageDetail and types.WithPromptCachePrefixes),
+// are part of the request. The oldest completion is evicted when the cache is full.
+//
+// Parameters:
+//   - maxEntries: The maximum number of cached completions, zero for no limit
+//
+// Returns the cache middleware, which can be shared by several clients.
+func CacheMiddleware(maxEntries int) Middleware {
+	var mu sync.Mutex
+	entries := map[string]types.JSONCompletion{}
+	keys := []string{}
+
+	return func(next Handler) Handler {
+		return func(ctx context.Context, prompt string, image []byte, schema *types.JSONSchema) (*types.JSONCompletion, error) {
+			model, _ := ctx.Value(requestModelKey{}).(requestModel)
+			prefixes := types.PromptCachePrefixes(ctx)
+			hash := sha256.New()
+			fmt.Fprintf(
+				hash, "%s\x00%s\x00%s\x00%d\x00",
+				model.provider, model.model, types.ContextImageDetail(ctx), len(prefixes),
+			)
+			for _, prefix := range prefixes {
+				fmt.Fprintf(hash, "%d:%s\x00", len(prefix), prefix)
+			}
+			fmt.Fprintf(hash, "%d:%s\x00", len(prompt), prompt)
+			hash.Write(image)
+			if schema != nil {
+				serialized, err := json.Marshal(schema)
+				if err != nil {
+					return nil, fmt.Errorf("failed to serialize schema: %w", err)
+				}
+				hash.Write([]byte{0})
+				hash.Write(serialized)
+			}
+			key := hex.EncodeToString(hash.Sum(nil))
+
+			mu.Lock()
+			cached, ok := entries[key]
+			mu.Unlock()
+			if ok {
+				return &types.JSONCompletion{
+					JSON:              cached.JSON,
+					LLMCompletionMeta: types.LLMCompletionMeta{Provider: cached.Provider, Model: cached.Model},
+				}, nil
+			}
+
+			completion, err := next(ctx, prompt, image, schema)
+			if err != nil || completion == nil {
+				return completion, err
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if _, ok := entries[key]; !ok {
+				if maxEntries > 0 && len(keys) >= maxEntries {
+					delete(entries, keys[0])
+					keys = keys[1:]
+				}
+				keys = append(keys, key)
+			}
+			entries[key] = *completion
+			return completion, nil
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vertexcover-io/locatr/pkg/types"
+)
+
+func TestMiddleware(t *testing.T) {
+	ctx := context.Background()
+	var requests atomic.Int32
+	var lastBody atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		lastBody.Store(string(body))
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(geminiTextResponse(`{"element_id": "1"}`)))
+	}))
+	defer server.Close()
+
+	order := []string{}
+	tracing := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(ctx context.Context, prompt string, image []byte, schema *types.JSONSchema) (*types.JSONCompletion, error) {
+				order = append(order, name+" before")
+				completion, err := next(ctx, prompt, image, schema)
+				order = append(order, name+" after")
+				return completion, err
+			}
+		}
+	}
+	metrics := []CompletionMetrics{}
+	logs := new(bytes.Buffer)
+
+	client, err := NewLLMClient(
+		WithProvider(Gemini),
+		WithModel("gemini-2.0-flash"),
+		WithBaseURL(server.URL),
+		WithMiddleware(tracing("outer"), tracing("inner")),
+		WithMiddleware(
+			LoggingMiddleware(slog.New(slog.NewTextHandler(logs, nil))),
+			MetricsMiddleware(func(m CompletionMetrics) { metrics = append(metrics, m) }),
+			CacheMiddleware(1),
+			RedactionMiddleware(regexp.MustCompile(`[\w.]+@[\w.]+`), "[email]"),
+		),
+	)
+	assert.NoError(t, err)
+
+	completion, err := client.GetJSONCompletion(ctx, "log in as jane@example.com", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"element_id": "1"}`, completion.JSON)
+	assert.Equal(t, 1290, completion.InputTokens)
+	assert.Equal(t, []string{"outer before", "inner before", "inner after", "outer after"}, order)
+	assert.NotContains(t, lastBody.Load(), "jane@example.com")
+	assert.Contains(t, lastBody.Load(), "log in as [email]")
+	assert.Contains(t, logs.String(), "input_tokens=1290")
+
+	t.Run("returns cached completions without usage", func(t *testing.T) {
+		completion, err := client.GetJSONCompletion(ctx, "log in as jane@example.com", nil)
+		assert.NoError(t, err)
+		assert.Equal(t, `{"element_id": "1"}`, completion.JSON)
+		assert.Equal(t, 0, completion.InputTokens)
+		assert.Equal(t, int32(1), requests.Load())
+		assert.Len(t, metrics, 2)
+		assert.Equal(t, 1290, metrics[0].InputTokens)
+		assert.Equal(t, Gemini, metrics[1].Provider)
+	})
+
+	t.Run("evicts the oldest completion", func(t *testing.T) {
+		_, err := client.GetJSONCompletion(ctx, "sign up", nil)
+		assert.NoError(t, err)
+		_, err = client.GetJSONCompletion(ctx, "log in as jane@example.com", nil)
+		assert.NoError(t, err)
+		assert.Equal(t, int32(3), requests.Load())
+	})
+}
+
+func TestCacheMiddleware_Key(t *testing.T) {
+	ctx := context.Background()
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(geminiTextResponse(`{"element_id": "1"}`)))
+	}))
+	defer server.Close()
+
+	cache := CacheMiddleware(0)
+	newClient := func(model string) *llmClient {
+		client, err := NewLLMClient(WithProvider(Gemini), WithModel(model), WithBaseURL(server.URL), WithMiddleware(cache))
+		assert.NoError(t, err)
+		return client
+	}
+	flash, pro := newClient("gemini-2.0-flash"), newClient("gemini-2.5-pro")
+	schema := func(required string) *types.JSONSchema {
+		return &types.JSONSchema{Name: "output", Schema: map[string]any{"type": "object", "required": []string{required}}}
+	}
+
+	tests := []struct {
+		name             string
+		client           *llmClient
+		schema           *types.JSONSchema
+		ctx              context.Context
+		expectedRequests int32
+	}{
+		{name: "first request", client: flash, schema: schema("element_id"), ctx: ctx, expectedRequests: 1},
+		{name: "identical request", client: flash, schema: schema("element_id"), ctx: ctx, expectedRequests: 1},
+		{name: "another model", client: pro, schema: schema("element_id"), ctx: ctx, expectedRequests: 2},
+		{name: "another schema with the same name", client: flash, schema: schema("element_point"), ctx: ctx, expectedRequests: 3},
+		{
+			name: "another image detail", client: flash, schema: schema("element_id"),
+			ctx: types.WithImageDetail(ctx, types.ImageDetailLow), expectedRequests: 4,
+		},
+		{
+			name: "prompt cache prefixes", client: flash, schema: schema("element_id"),
+			ctx: types.WithPromptCachePrefixes(ctx, "find"), expectedRequests: 5,
+		},
+		{
+			name: "other prompt cache prefixes", client: flash, schema: schema("element_id"),
+			ctx: types.WithPromptCachePrefixes(ctx, "find the"), expectedRequests: 6,
+		},
+		{
+			name: "identical prompt cache prefixes", client: flash, schema: schema("element_id"),
+			ctx: types.WithPromptCachePrefixes(ctx, "find the"), expectedRequests: 6,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.client.GetStructuredCompletion(tt.ctx, "find the button", nil, tt.schema)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedRequests, requests.Load())
+		})
+	}
+}
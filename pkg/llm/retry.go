@@ -0,0 +1,222 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/openai/openai-go"
+	"github.com/vertexcover-io/locatr/pkg/types"
+)
+
+// apiError is returned by the providers that are not backed by an SDK when the API responds with a non-2xx status.
+type apiError struct {
+	provider   types.LLMProvider
+	statusCode int
+	header     http.Header
+	message    string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("failed to get completion from %v: %v %v", e.provider, e.statusCode, e.message)
+}
+
+// retryableStatusCodes are the HTTP statuses worth retrying: timeouts, conflicts, rate limits and server errors.
+var retryableStatusCodes = map[int]bool{
+	http.StatusRequestTimeout:      true,
+	http.StatusConflict:            true,
+	http.StatusTooManyRequests:     true,
+	http.StatusInternalServerError: true,
+	http.StatusBadGateway:          true,
+	http.StatusServiceUnavailable:  true,
+	http.StatusGatewayTimeout:      true,
+	529:                            true, // Anthropic's overloaded status
+}
+
+// classifyError reports whether a failed request may succeed if retried, along with the delay
+// requested by the server through the Retry-After headers, if any.
+//
+// Parameters:
+//   - ctx: The context of the whole completion, used to tell per-request timeouts from cancellation
+//   - err: The request error
+//
+// Returns:
+//   - bool: Whether the error is transient
+//   - time.Duration: The delay requested by the server, zero if none
+func classifyError(ctx context.Context, err error) (bool, time.Duration) {
+	if ctx.Err() != nil {
+		return false, 0
+	}
+
+	var statusCode int
+	var header http.Header
+	var openaiErr *openai.Error
+	var anthropicErr *anthropic.Error
+	var providerErr *apiError
+	switch {
+	case errors.As(err, &openaiErr):
+		statusCode = openaiErr.StatusCode
+		if openaiErr.Response != nil {
+			header = openaiErr.Response.Header
+		}
+	case errors.As(err, &anthropicErr):
+		statusCode = anthropicErr.StatusCode
+		if anthropicErr.Response != nil {
+			header = anthropicErr.Response.Header
+		}
+	case errors.As(err, &providerErr):
+		statusCode = providerErr.statusCode
+		header = providerErr.header
+	}
+	if statusCode != 0 {
+		// Other server errors, like 501 Not Implemented, are permanent
+		if !retryableStatusCodes[statusCode] {
+			return false, 0
+		}
+		return true, parseRetryAfter(header, time.Now())
+	}
+
+	// The per-request timeout expired while the completion context is still alive
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true, 0
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true, 0
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED), 0
+}
+
+// parseRetryAfter parses the delay requested by the server from the `retry-after-ms` header
+// or the `Retry-After` header, which holds either a number of seconds or an HTTP date.
+//
+// Parameters:
+//   - header: The response headers
+//   - now: The current time, used to resolve HTTP dates
+//
+// Returns the requested delay, zero if none or invalid.
+func parseRetryAfter(header http.Header, now time.Time) time.Duration {
+	if header == nil {
+		return 0
+	}
+	if value := header.Get("retry-after-ms"); value != "" {
+		if ms, err := strconv.ParseFloat(value, 64); err == nil && ms > 0 {
+			return time.Duration(ms * float64(time.Millisecond))
+		}
+	}
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		if seconds > 0 {
+			return time.Duration(seconds * float64(time.Second))
+		}
+		return 0
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
+
+// backoffDelay returns the jittered exponential delay before the given retry.
+// The delay doubles with each retry up to maxBackoff, and a random half of it is jittered
+// so that concurrent clients don't retry in lockstep.
+//
+// Parameters:
+//   - retry: The zero based retry number
+//   - initialBackoff: The delay before the first retry
+//   - maxBackoff: The maximum delay
+//
+// Returns the delay to wait.
+func backoffDelay(retry int, initialBackoff, maxBackoff time.Duration) time.Duration {
+	delay := maxBackoff
+	if retry < 32 {
+		if exponential := initialBackoff << retry; exponential > 0 && exponential < maxBackoff {
+			delay = exponential
+		}
+	}
+	half := delay / 2
+	return half + rand.N(half+1)
+}
+
+// completeWithRetries sends the completion request, retrying transient errors with jittered exponential
+// backoff. A delay requested by the server through Retry-After is honored, up to the maximum backoff.
+// Each request is bounded by the configured request timeout.
+//
+// Parameters:
+//   - ctx: Context
+//   - prompt: The input prompt
+//   - image: Optional image data for vision models
+//   - schema: Optional schema of the structured output
+//
+// Returns:
+//   - *types.JSONCompletion: The completion of the last request, with the usage of all the requests
+//   - error: The error of the last request
+func (client *llmClient) completeWithRetries(
+	ctx context.Context, prompt string, image []byte, schema *types.JSONSchema,
+) (*types.JSONCompletion, error) {
+	cfg := client.config
+	usage := types.LLMCompletionMeta{}
+
+	for retry := 0; ; retry++ {
+		completion, err := client.request(ctx, prompt, image, schema)
+		if completion == nil {
+			completion = &types.JSONCompletion{
+				LLMCompletionMeta: types.LLMCompletionMeta{Provider: cfg.provider, Model: cfg.model},
+			}
+		}
+		usage.Accumulate(completion.LLMCompletionMeta)
+		completion.InputTokens, completion.OutputTokens = usage.InputTokens, usage.OutputTokens
+		completion.CacheCreationInputTokens = usage.CacheCreationInputTokens
+		completion.CacheReadInputTokens = usage.CacheReadInputTokens
+		completion.Retries = retry
+		if err == nil || retry >= cfg.maxRetries {
+			return completion, err
+		}
+
+		retryable, retryAfter := classifyError(ctx, err)
+		if !retryable {
+			return completion, err
+		}
+		delay := backoffDelay(retry, cfg.initialBackoff, cfg.maxBackoff)
+		if retryAfter > 0 {
+			delay = min(retryAfter, cfg.maxBackoff)
+		}
+		cfg.logger.Warn(
+			"retrying llm request after transient error",
+			"retry", retry+1, "delay", delay.String(), "error", err,
+		)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return completion, fmt.Errorf("%w (gave up retrying: %v)", err, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// request sends a single completion request, bounded by the configured request timeout.
+func (client *llmClient) request(
+	ctx context.Context, prompt string, image []byte, schema *types.JSONSchema,
+) (*types.JSONCompletion, error) {
+	if client.config.requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, client.config.requestTimeout)
+		defer cancel()
+	}
+	return client.handler(ctx, prompt, image, schema)
+}
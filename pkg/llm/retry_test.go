@@ -0,0 +1,222 @@
+package llm
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vertexcover-io/locatr/pkg/types"
+)
+
+func TestCompleteWithRetries(t *testing.T) {
+	ctx := context.Background()
+	successResponse := geminiTextResponse(`{"locator_id": "abc"}`)
+
+	tests := []struct {
+		name             string
+		responses        []int
+		retryAfter       string
+		options          []Option
+		expectedRequests int32
+		expectedRetries  int
+		expectError      bool
+	}{
+		{
+			name:             "retries rate limits and server errors",
+			responses:        []int{http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusOK},
+			retryAfter:       "0",
+			expectedRequests: 3,
+			expectedRetries:  2,
+		},
+		{
+			name:             "does not retry fatal errors",
+			responses:        []int{http.StatusBadRequest, http.StatusOK},
+			expectedRequests: 1,
+			expectedRetries:  0,
+			expectError:      true,
+		},
+		{
+			name:             "gives up after max retries",
+			responses:        []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusOK},
+			options:          []Option{WithMaxRetries(1)},
+			expectedRequests: 2,
+			expectedRetries:  1,
+			expectError:      true,
+		},
+		{
+			name:             "retries disabled",
+			responses:        []int{http.StatusTooManyRequests, http.StatusOK},
+			options:          []Option{WithMaxRetries(0)},
+			expectedRequests: 1,
+			expectedRetries:  0,
+			expectError:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				status := tt.responses[requests.Add(1)-1]
+				w.Header().Set("Content-Type", "application/json")
+				if tt.retryAfter != "" {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				w.WriteHeader(status)
+				if status == http.StatusOK {
+					_, _ = w.Write([]byte(successResponse))
+				} else {
+					_, _ = w.Write([]byte(`{"error": {"code": 1, "message": "failed", "status": "FAILED"}}`))
+				}
+			}))
+			defer server.Close()
+
+			options := append([]Option{
+				WithProvider(Gemini),
+				WithModel("gemini-2.0-flash"),
+				WithBaseURL(server.URL),
+				WithBackoff(time.Millisecond, 5*time.Millisecond),
+			}, tt.options...)
+			client, err := NewLLMClient(options...)
+			assert.NoError(t, err)
+
+			completion, err := client.GetJSONCompletion(ctx, "find the button", nil)
+			if tt.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.JSONEq(t, `{"locator_id": "abc"}`, completion.JSON)
+			}
+			assert.NotNil(t, completion)
+			assert.Equal(t, tt.expectedRequests, requests.Load())
+			assert.Equal(t, tt.expectedRetries, completion.Retries)
+		})
+	}
+}
+
+func TestCompleteWithRetries_Usage(t *testing.T) {
+	client, err := NewLLMClient(
+		WithProvider(Anthropic), WithModel("claude-3-5-sonnet-latest"), WithBackoff(time.Millisecond, time.Millisecond),
+	)
+	assert.NoError(t, err)
+	requests := 0
+	client.handler = func(ctx context.Context, prompt string, image []byte, schema *types.JSONSchema) (*types.JSONCompletion, error) {
+		requests++
+		completion := &types.JSONCompletion{LLMCompletionMeta: types.LLMCompletionMeta{
+			InputTokens: 10, OutputTokens: 5, CacheCreationInputTokens: 1000, CacheReadInputTokens: 200,
+		}}
+		if requests == 1 {
+			// The response was cut short by a server error, after the prompt was cached
+			return completion, &apiError{provider: Anthropic, statusCode: http.StatusServiceUnavailable}
+		}
+		completion.CacheCreationInputTokens = 0
+		return completion, nil
+	}
+
+	completion, err := client.GetJSONCompletion(context.Background(), "find the button", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, completion.Retries)
+	assert.Equal(t, 20, completion.InputTokens)
+	assert.Equal(t, 10, completion.OutputTokens)
+	assert.Equal(t, 1000, completion.CacheCreationInputTokens)
+	assert.Equal(t, 400, completion.CacheReadInputTokens)
+}
+
+func TestRequestTimeout(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(geminiTextResponse(`{"locator_id": "abc"}`)))
+	}))
+	defer server.Close()
+
+	client, err := NewLLMClient(
+		WithProvider(Gemini),
+		WithModel("gemini-2.0-flash"),
+		WithBaseURL(server.URL),
+		WithBackoff(time.Millisecond, 5*time.Millisecond),
+		WithRequestTimeout(50*time.Millisecond),
+	)
+	assert.NoError(t, err)
+
+	completion, err := client.GetJSONCompletion(context.Background(), "find the button", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, completion.Retries)
+	assert.Equal(t, int32(2), requests.Load())
+}
+
+func TestClassifyError_StatusCodes(t *testing.T) {
+	tests := []struct {
+		statusCode int
+		retryable  bool
+	}{
+		{statusCode: http.StatusBadRequest, retryable: false},
+		{statusCode: http.StatusTooManyRequests, retryable: true},
+		{statusCode: http.StatusInternalServerError, retryable: true},
+		{statusCode: http.StatusNotImplemented, retryable: false},
+		{statusCode: http.StatusBadGateway, retryable: true},
+		{statusCode: http.StatusServiceUnavailable, retryable: true},
+		{statusCode: http.StatusGatewayTimeout, retryable: true},
+		{statusCode: http.StatusHTTPVersionNotSupported, retryable: false},
+		{statusCode: 529, retryable: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.statusCode), func(t *testing.T) {
+			retryable, _ := classifyError(context.Background(), &apiError{provider: Gemini, statusCode: tt.statusCode})
+			assert.Equal(t, tt.retryable, retryable)
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		header   http.Header
+		expected time.Duration
+	}{
+		{name: "no header", header: http.Header{}, expected: 0},
+		{name: "seconds", header: http.Header{"Retry-After": {"2"}}, expected: 2 * time.Second},
+		{
+			name:     "milliseconds take precedence",
+			header:   http.Header{"Retry-After": {"2"}, "Retry-After-Ms": {"1500"}},
+			expected: 1500 * time.Millisecond,
+		},
+		{
+			name:     "HTTP date",
+			header:   http.Header{"Retry-After": {now.Add(3 * time.Second).Format(http.TimeFormat)}},
+			expected: 3 * time.Second,
+		},
+		{name: "invalid", header: http.Header{"Retry-After": {"soon"}}, expected: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, parseRetryAfter(tt.header, now))
+		})
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	for retry, expectedMax := range []time.Duration{100, 200, 400, 500, 500} {
+		delay := backoffDelay(retry, 100*time.Millisecond, 500*time.Millisecond)
+		assert.GreaterOrEqual(t, delay, expectedMax*time.Millisecond/2)
+		assert.LessOrEqual(t, delay, expectedMax*time.Millisecond)
+	}
+	// Large retry numbers must not overflow
+	delay := backoffDelay(100, 100*time.Millisecond, time.Second)
+	assert.GreaterOrEqual(t, delay, 500*time.Millisecond)
+	assert.LessOrEqual(t, delay, time.Second)
+}
@@ -0,0 +1,250 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/vertexcover-io/locatr/pkg/logging"
+	"github.com/vertexcover-io/locatr/pkg/types"
+)
+
+// Tier is a step of the escalation ladder of a router client.
+type Tier struct {
+	// Name of the tier, recorded in the completion usage. Defaults to the model of the client
+	Name string
+	// Client used to get the completions of the tier
+	Client types.LLMClientInterface
+	// MinConfidence escalates the responses whose "confidence" field is lower than it to the next tier.
+	// Responses without a confidence are accepted. Zero disables the check
+	MinConfidence float64
+}
+
+// EscalationFunc decides whether a successful completion of a tier is escalated to the next tier.
+//
+// Parameters:
+//   - tier: The tier that returned the completion
+//   - completion: The completion of the tier
+//
+// Returns the reason of the escalation, or an empty string to accept the completion.
+type EscalationFunc func(tier Tier, completion *types.JSONCompletion) string
+
+type routerConfig struct {
+	escalate EscalationFunc
+	logger   *slog.Logger
+}
+
+type RouterOption func(*routerConfig)
+
+// WithEscalation sets the function deciding whether a completion is escalated to the next tier.
+// Defaults to DefaultEscalation. Failed requests are always escalated.
+func WithEscalation(escalate EscalationFunc) RouterOption {
+	return func(c *routerConfig) {
+		c.escalate = escalate
+	}
+}
+
+// WithRouterLogger sets the logger for the router client.
+func WithRouterLogger(logger *slog.Logger) RouterOption {
+	return func(c *routerConfig) {
+		c.logger = logger
+	}
+}
+
+// routerClient is an LLM client that tries the tiers of an escalation ladder in order,
+// e.g. a cheap model first and a stronger model only when the cheap one fails.
+type routerClient struct {
+	tiers  []Tier
+	config *routerConfig
+}
+
+// NewRouterClient creates a new LLM client escalating each completion through the given tiers.
+// A completion is escalated to the next tier when the request fails or the escalation function rejects it,
+// and the response of the last tier is returned as is.
+//
+// Parameters:
+//   - tiers: The escalation ladder, from the cheapest to the strongest model
+//   - opts: Configuration options for the router client
+//
+// Returns:
+//   - *routerClient: Configured client instance
+//   - error: If no tier is given, a tier has no client or two tiers have the same name
+func NewRouterClient(tiers []Tier, opts ...RouterOption) (*routerClient, error) {
+	if len(tiers) == 0 {
+		return nil, errors.New("at least one tier is required")
+	}
+	cfg := &routerConfig{escalate: DefaultEscalation}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if cfg.logger == nil {
+		cfg.logger = logging.DefaultLogger
+	}
+
+	names := make(map[string]bool, len(tiers))
+	ladder := make([]Tier, len(tiers))
+	for i, tier := range tiers {
+		if tier.Client == nil {
+			return nil, fmt.Errorf("client of tier %d is required", i)
+		}
+		if tier.Name == "" {
+			tier.Name = tier.Client.GetModel()
+		}
+		if names[tier.Name] {
+			return nil, fmt.Errorf("duplicate tier name: %q", tier.Name)
+		}
+		names[tier.Name] = true
+		ladder[i] = tier
+	}
+	return &routerClient{tiers: ladder, config: cfg}, nil
+}
+
+// DefaultEscalation escalates the completions that aren't valid JSON objects or have a "confidence" field
+// lower than the MinConfidence of the tier. Errors reported in the "error" field are accepted, as the element
+// is usually missing from the prompt rather than beyond the model, see EscalateReportedErrors.
+func DefaultEscalation(tier Tier, completion *types.JSONCompletion) string {
+	var output struct {
+		Confidence *float64 `json:"confidence"`
+	}
+	if err := json.Unmarshal([]byte(completion.JSON), &output); err != nil {
+		return fmt.Sprintf("invalid JSON: %v", err)
+	}
+	if output.Confidence != nil && *output.Confidence < tier.MinConfidence {
+		return fmt.Sprintf("confidence %v is lower than %v", *output.Confidence, tier.MinConfidence)
+	}
+	return ""
+}
+
+// EscalateReportedErrors escalates the completions escalated by DefaultEscalation, and those reporting
+// an error in their "error" field.
+func EscalateReportedErrors(tier Tier, completion *types.JSONCompletion) string {
+	if reason := DefaultEscalation(tier, completion); reason != "" {
+		return reason
+	}
+	var output struct {
+		Error string `json:"error"`
+	}
+	_ = json.Unmarshal([]byte(completion.JSON), &output)
+	if strings.TrimSpace(output.Error) != "" {
+		return fmt.Sprintf("reported error: %v", output.Error)
+	}
+	return ""
+}
+
+// GetJSONCompletion returns the JSON completion of the first tier whose completion isn't escalated.
+func (client *routerClient) GetJSONCompletion(ctx context.Context, prompt string, image []byte) (*types.JSONCompletion, error) {
+	return client.complete(ctx, prompt, image, nil)
+}
+
+// GetStructuredCompletion returns the structured completion of the first tier whose completion isn't escalated.
+func (client *routerClient) GetStructuredCompletion(
+	ctx context.Context, prompt string, image []byte, schema *types.JSONSchema,
+) (*types.JSONCompletion, error) {
+	if schema == nil {
+		return nil, errors.New("schema is required for structured completion")
+	}
+	return client.complete(ctx, prompt, image, schema)
+}
+
+// GetProvider returns the provider of the first tier.
+// See types.ClientProviders for the providers of all the tiers.
+func (client *routerClient) GetProvider() types.LLMProvider {
+	return client.tiers[0].Client.GetProvider()
+}
+
+// GetModel returns the model of the first tier.
+// See types.LookupClientCapabilities for the limits of all the tiers.
+func (client *routerClient) GetModel() string {
+	return client.tiers[0].Client.GetModel()
+}
+
+// GetTierClients returns the clients of the tiers, in escalation order.
+func (client *routerClient) GetTierClients() []types.LLMClientInterface {
+	clients := make([]types.LLMClientInterface, len(client.tiers))
+	for i, tier := range client.tiers {
+		clients[i] = tier.Client
+	}
+	return clients
+}
+
+// complete requests the completion from each tier until one isn't escalated.
+//
+// Parameters:
+//   - ctx: Context
+//   - prompt: The input prompt
+//   - image: Optional image data for vision models
+//   - schema: Optional schema of the structured output
+//
+// Returns:
+//   - *types.JSONCompletion: The completion of the answering tier, with the usage of all the tiers tried.
+//     The tiers of nested routers are reported in place of the tier they make up
+//   - error: The error of the last tier tried, if it failed
+func (client *routerClient) complete(
+	ctx context.Context, prompt string, image []byte, schema *types.JSONSchema,
+) (*types.JSONCompletion, error) {
+	usage := types.LLMCompletionMeta{}
+	var completion *types.JSONCompletion
+	var err error
+	var tier Tier
+
+	for i := range client.tiers {
+		tier = client.tiers[i]
+		if schema == nil {
+			completion, err = tier.Client.GetJSONCompletion(ctx, prompt, image)
+		} else {
+			completion, err = tier.Client.GetStructuredCompletion(ctx, prompt, image, schema)
+		}
+
+		if completion != nil {
+			usage.Accumulate(completion.LLMCompletionMeta)
+		}
+		// A nested router reports the usage of its own tiers, which would be counted twice by a tier of its own
+		if completion == nil || len(completion.Tiers) == 0 {
+			tierUsage := types.TierUsage{
+				Tier: tier.Name, Provider: tier.Client.GetProvider(), Model: tier.Client.GetModel(), Requests: 1,
+			}
+			if completion != nil {
+				tierUsage.InputTokens, tierUsage.OutputTokens = completion.InputTokens, completion.OutputTokens
+				tierUsage.CacheCreationInputTokens = completion.CacheCreationInputTokens
+				tierUsage.CacheReadInputTokens = completion.CacheReadInputTokens
+			}
+			usage.Accumulate(types.LLMCompletionMeta{Tiers: []types.TierUsage{tierUsage}})
+		}
+
+		reason := ""
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			reason = err.Error()
+		} else {
+			reason = client.config.escalate(tier, completion)
+		}
+		if reason == "" {
+			break
+		}
+		if i < len(client.tiers)-1 {
+			client.config.logger.Warn(
+				"escalating completion to the next tier",
+				"tier", tier.Name, "next_tier", client.tiers[i+1].Name, "reason", reason,
+			)
+		}
+	}
+
+	result := &types.JSONCompletion{LLMCompletionMeta: usage}
+	result.Provider, result.Model, result.AnsweringTier = tier.Client.GetProvider(), tier.Client.GetModel(), ""
+	if completion != nil {
+		result.JSON = completion.JSON
+	}
+	if err != nil {
+		return result, err
+	}
+	result.AnsweringTier = tier.Name
+	if len(completion.Tiers) > 0 {
+		result.AnsweringTier = completion.AnsweringTier
+	}
+	return result, nil
+}
@@ -0,0 +1,211 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vertexcover-io/locatr/pkg/types"
+)
+
+// stubTierClient returns a fixed completion or error.
+type stubTierClient struct {
+	model string
+	json  string
+	err   error
+}
+
+func (c *stubTierClient) GetProvider() types.LLMProvider { return OpenAI }
+
+func (c *stubTierClient) GetModel() string { return c.model }
+
+func (c *stubTierClient) GetJSONCompletion(ctx context.Context, prompt string, image []byte) (*types.JSONCompletion, error) {
+	return c.GetStructuredCompletion(ctx, prompt, image, nil)
+}
+
+func (c *stubTierClient) GetStructuredCompletion(
+	ctx context.Context, prompt string, image []byte, schema *types.JSONSchema,
+) (*types.JSONCompletion, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &types.JSONCompletion{
+		JSON: c.json,
+		LLMCompletionMeta: types.LLMCompletionMeta{
+			InputTokens: 100, OutputTokens: 10, Provider: OpenAI, Model: c.model,
+		},
+	}, nil
+}
+
+func TestRouterClient(t *testing.T) {
+	schema := &types.JSONSchema{Name: "output", Schema: map[string]any{"type": "object"}}
+
+	tests := []struct {
+		name          string
+		cheap         *stubTierClient
+		escalation    EscalationFunc
+		expectedJSON  string
+		expectedTier  string
+		expectedTiers []types.TierUsage
+	}{
+		{
+			name:          "answers with the cheap model",
+			cheap:         &stubTierClient{model: "gpt-4o-mini", json: `{"element_id": "1", "confidence": 0.9}`},
+			expectedJSON:  `{"element_id": "1", "confidence": 0.9}`,
+			expectedTier:  "cheap",
+			expectedTiers: []types.TierUsage{{Tier: "cheap", Provider: OpenAI, Model: "gpt-4o-mini", Requests: 1, InputTokens: 100, OutputTokens: 10}},
+		},
+		{
+			name:         "escalates on failure",
+			cheap:        &stubTierClient{model: "gpt-4o-mini", err: errors.New("rate limited")},
+			expectedJSON: `{"element_id": "2"}`,
+			expectedTier: "gpt-4o",
+			expectedTiers: []types.TierUsage{
+				{Tier: "cheap", Provider: OpenAI, Model: "gpt-4o-mini", Requests: 1},
+				{Tier: "gpt-4o", Provider: OpenAI, Model: "gpt-4o", Requests: 1, InputTokens: 100, OutputTokens: 10},
+			},
+		},
+		{
+			name:         "accepts a reported error",
+			cheap:        &stubTierClient{model: "gpt-4o-mini", json: `{"element_id": "", "error": "not found"}`},
+			expectedJSON: `{"element_id": "", "error": "not found"}`,
+			expectedTier: "cheap",
+			expectedTiers: []types.TierUsage{
+				{Tier: "cheap", Provider: OpenAI, Model: "gpt-4o-mini", Requests: 1, InputTokens: 100, OutputTokens: 10},
+			},
+		},
+		{
+			name:         "escalates a reported error if configured",
+			cheap:        &stubTierClient{model: "gpt-4o-mini", json: `{"element_id": "", "error": "not found"}`},
+			escalation:   EscalateReportedErrors,
+			expectedJSON: `{"element_id": "2"}`,
+			expectedTier: "gpt-4o",
+			expectedTiers: []types.TierUsage{
+				{Tier: "cheap", Provider: OpenAI, Model: "gpt-4o-mini", Requests: 1, InputTokens: 100, OutputTokens: 10},
+				{Tier: "gpt-4o", Provider: OpenAI, Model: "gpt-4o", Requests: 1, InputTokens: 100, OutputTokens: 10},
+			},
+		},
+		{
+			name:         "escalates on low confidence",
+			cheap:        &stubTierClient{model: "gpt-4o-mini", json: `{"element_id": "1", "confidence": 0.4}`},
+			expectedJSON: `{"element_id": "2"}`,
+			expectedTier: "gpt-4o",
+			expectedTiers: []types.TierUsage{
+				{Tier: "cheap", Provider: OpenAI, Model: "gpt-4o-mini", Requests: 1, InputTokens: 100, OutputTokens: 10},
+				{Tier: "gpt-4o", Provider: OpenAI, Model: "gpt-4o", Requests: 1, InputTokens: 100, OutputTokens: 10},
+			},
+		},
+		{
+			name:         "escalates invalid JSON",
+			cheap:        &stubTierClient{model: "gpt-4o-mini", json: `{"element_id":`},
+			expectedJSON: `{"element_id": "2"}`,
+			expectedTier: "gpt-4o",
+			expectedTiers: []types.TierUsage{
+				{Tier: "cheap", Provider: OpenAI, Model: "gpt-4o-mini", Requests: 1, InputTokens: 100, OutputTokens: 10},
+				{Tier: "gpt-4o", Provider: OpenAI, Model: "gpt-4o", Requests: 1, InputTokens: 100, OutputTokens: 10},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strong := &stubTierClient{model: "gpt-4o", json: `{"element_id": "2"}`}
+			opts := []RouterOption{}
+			if tt.escalation != nil {
+				opts = append(opts, WithEscalation(tt.escalation))
+			}
+			client, err := NewRouterClient([]Tier{
+				{Name: "cheap", Client: tt.cheap, MinConfidence: 0.7},
+				{Client: strong},
+			}, opts...)
+			assert.NoError(t, err)
+			assert.Equal(t, "gpt-4o-mini", client.GetModel())
+			assert.Equal(t, []types.LLMClientInterface{tt.cheap, strong}, client.GetTierClients())
+
+			completion, err := client.GetStructuredCompletion(context.Background(), "prompt", nil, schema)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedJSON, completion.JSON)
+			assert.Equal(t, tt.expectedTier, completion.AnsweringTier)
+			assert.Equal(t, tt.expectedTiers, completion.Tiers)
+
+			inputTokens := 0
+			for _, usage := range tt.expectedTiers {
+				inputTokens += usage.InputTokens
+			}
+			assert.Equal(t, inputTokens, completion.InputTokens)
+		})
+	}
+}
+
+func TestRouterClient_LastTierFails(t *testing.T) {
+	client, err := NewRouterClient([]Tier{
+		{Name: "cheap", Client: &stubTierClient{model: "gpt-4o-mini", json: `{"element_id":`}},
+		{Name: "strong", Client: &stubTierClient{model: "gpt-4o", err: errors.New("overloaded")}},
+	})
+	assert.NoError(t, err)
+
+	completion, err := client.GetJSONCompletion(context.Background(), "prompt", nil)
+	assert.EqualError(t, err, "overloaded")
+	assert.Equal(t, 100, completion.InputTokens)
+	assert.Empty(t, completion.AnsweringTier)
+	assert.Len(t, completion.Tiers, 2)
+
+	// The response of the last tier is returned even if it would be escalated
+	client, err = NewRouterClient([]Tier{
+		{Name: "cheap", Client: &stubTierClient{model: "gpt-4o-mini", json: `{"error": "not found"}`}},
+	}, WithEscalation(func(tier Tier, completion *types.JSONCompletion) string { return "always" }))
+	assert.NoError(t, err)
+	completion, err = client.GetJSONCompletion(context.Background(), "prompt", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"error": "not found"}`, completion.JSON)
+	assert.Equal(t, "cheap", completion.AnsweringTier)
+}
+
+func TestRouterClient_Nested(t *testing.T) {
+	nested, err := NewRouterClient([]Tier{
+		{Name: "mini", Client: &stubTierClient{model: "gpt-4o-mini", json: `{"element_id": "1", "confidence": 0.4}`}, MinConfidence: 0.7},
+		{Name: "4o", Client: &stubTierClient{model: "gpt-4o", json: `{"element_id": "2", "confidence": 0.5}`}},
+	})
+	assert.NoError(t, err)
+	client, err := NewRouterClient([]Tier{
+		{Name: "openai", Client: nested, MinConfidence: 0.6},
+		{Name: "o1", Client: &stubTierClient{model: "o1", json: `{"element_id": "3"}`}},
+	})
+	assert.NoError(t, err)
+
+	completion, err := client.GetJSONCompletion(context.Background(), "prompt", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"element_id": "3"}`, completion.JSON)
+	assert.Equal(t, "o1", completion.AnsweringTier)
+	assert.Equal(t, []types.TierUsage{
+		{Tier: "mini", Provider: OpenAI, Model: "gpt-4o-mini", Requests: 1, InputTokens: 100, OutputTokens: 10},
+		{Tier: "4o", Provider: OpenAI, Model: "gpt-4o", Requests: 1, InputTokens: 100, OutputTokens: 10},
+		{Tier: "o1", Provider: OpenAI, Model: "o1", Requests: 1, InputTokens: 100, OutputTokens: 10},
+	}, completion.Tiers)
+	assert.Equal(t, 300, completion.InputTokens)
+	assert.Equal(t, 30, completion.OutputTokens)
+
+	// The answering tier of the nested router is reported when it answers
+	client, err = NewRouterClient([]Tier{{Name: "openai", Client: nested}})
+	assert.NoError(t, err)
+	completion, err = client.GetJSONCompletion(context.Background(), "prompt", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "4o", completion.AnsweringTier)
+	assert.Len(t, completion.Tiers, 2)
+	assert.Equal(t, 200, completion.InputTokens)
+}
+
+func TestNewRouterClient_Errors(t *testing.T) {
+	_, err := NewRouterClient(nil)
+	assert.EqualError(t, err, "at least one tier is required")
+
+	_, err = NewRouterClient([]Tier{{Name: "cheap"}})
+	assert.EqualError(t, err, "client of tier 0 is required")
+
+	_, err = NewRouterClient([]Tier{
+		{Client: &stubTierClient{model: "gpt-4o"}},
+		{Client: &stubTierClient{model: "gpt-4o"}},
+	})
+	assert.EqualError(t, err, `duplicate tier name: "gpt-4o"`)
+}
@@ -14,12 +14,15 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/vertexcover-io/locatr/pkg/internal/constants"
 	"github.com/vertexcover-io/locatr/pkg/internal/utils"
 	"github.com/vertexcover-io/locatr/pkg/llm"
 	"github.com/vertexcover-io/locatr/pkg/logging"
 	"github.com/vertexcover-io/locatr/pkg/mode"
+	"github.com/vertexcover-io/locatr/pkg/plugins"
+	"github.com/vertexcover-io/locatr/pkg/redact"
 	"github.com/vertexcover-io/locatr/pkg/reranker"
 	"github.com/vertexcover-io/locatr/pkg/types"
 )
@@ -39,6 +42,9 @@ type config struct {
 	mode           types.LocatrMode
 	useCache       bool
 	cachePath      string
+	cacheKey       CacheKey
+	driftThreshold *float64
+	redactor       *redact.Redactor
 	logger         *slog.Logger
 }
 
@@ -79,6 +85,45 @@ func EnableCache(path *string) Option {
 	}
 }
 
+// CacheKey determines how cache entries are scoped.
+type CacheKey string
+
+const (
+	// CacheKeyURL scopes cache entries to the current context returned by the plugin (page URL or app activity).
+	CacheKeyURL CacheKey = "url"
+	// CacheKeyDOMStructure scopes cache entries to a "screen", identified by a structural hash of the minified DOM
+	// that ignores text and dynamic attributes. Useful for single-URL SPAs and activities shared across screens.
+	CacheKeyDOMStructure CacheKey = "dom-structure"
+)
+
+// StructureCacheKeyPrefix prefixes the cache keys derived from the DOM structure to tell them apart from URLs.
+const StructureCacheKeyPrefix = "dom-structure:"
+
+// WithCacheKey sets how cache entries are scoped. Defaults to CacheKeyURL.
+func WithCacheKey(key CacheKey) Option {
+	return func(opts *config) {
+		opts.cacheKey = key
+	}
+}
+
+// WithDriftThreshold sets the minimum fingerprint similarity (0 to 1) required for a cached locator to be used.
+// Cached locators resolving to an element less similar than this are treated as a cache miss.
+// A threshold of 0 disables the drift check. Defaults to constants.DEFAULT_DRIFT_THRESHOLD.
+func WithDriftThreshold(threshold float64) Option {
+	return func(opts *config) {
+		opts.driftThreshold = &threshold
+	}
+}
+
+// WithRedactor sets the redactor applied to the minified DOM before it is sent to the LLM and reranker,
+// replacing personal data and secrets with placeholders. Element IDs and locators are kept.
+// Use a redactor with a key (see redact.WithKey) with the cache, so that cached fingerprints survive restarts.
+func WithRedactor(redactor *redact.Redactor) Option {
+	return func(opts *config) {
+		opts.redactor = redactor
+	}
+}
+
 // WithLogger sets the logger for the config.
 func WithLogger(logger *slog.Logger) Option {
 	return func(opts *config) {
@@ -104,6 +149,15 @@ func NewLocatr(plugin types.PluginInterface, opts ...Option) (*Locatr, error) {
 		cfg.logger = logging.DefaultLogger
 	}
 
+	if cfg.cacheKey == "" {
+		cfg.cacheKey = CacheKeyURL
+	}
+
+	if cfg.driftThreshold == nil {
+		threshold := constants.DEFAULT_DRIFT_THRESHOLD
+		cfg.driftThreshold = &threshold
+	}
+
 	if cfg.llmClient == nil {
 		llmClient, err := llm.DefaultLLMClient(cfg.logger)
 		if err != nil {
@@ -124,6 +178,16 @@ func NewLocatr(plugin types.PluginInterface, opts ...Option) (*Locatr, error) {
 		cfg.mode = &mode.DOMAnalysisMode{}
 	}
 
+	if cfg.redactor != nil {
+		plugin = plugins.NewRedactedPlugin(plugin, cfg.redactor)
+		if cfg.useCache && cfg.redactor.HasRandomKey() {
+			cfg.logger.Warn(
+				"redactor without a key used with the cache, the fingerprints of cached entries " +
+					"will drift after a restart, set one with redact.WithKey",
+			)
+		}
+	}
+
 	instance := &Locatr{
 		plugin: plugin,
 		config: cfg,
@@ -149,6 +213,10 @@ func NewLocatr(plugin types.PluginInterface, opts ...Option) (*Locatr, error) {
 //   - error if element location fails
 func (l *Locatr) Locate(ctx context.Context, request string) (types.LocatrCompletion, error) {
 	defer logging.CreateTopic(fmt.Sprintf("[Locate] '%s'", request), l.config.logger)()
+	if l.config.redactor != nil {
+		// The values redacted from the DOMs of this call are restored apart from those of concurrent calls
+		ctx = plugins.WithRedactionScope(ctx)
+	}
 
 	completion := &types.LocatrCompletion{
 		Locators:    []string{},
@@ -162,11 +230,17 @@ func (l *Locatr) Locate(ctx context.Context, request string) (types.LocatrComple
 		},
 	}
 
+	cacheKey := ""
 	if l.config.useCache {
-		if err := l.processCacheRequest(ctx, request, completion); err == nil {
-			return *completion, nil
+		if key, err := l.getCacheKey(ctx); err != nil {
+			l.config.logger.Error("couldn't get cache key", "error", err)
 		} else {
-			l.config.logger.Error("couldn't process cache request", "error", err)
+			cacheKey = key
+			if err := l.processCacheRequest(ctx, cacheKey, request, completion); err == nil {
+				return *completion, nil
+			} else {
+				l.config.logger.Error("couldn't process cache request", "error", err)
+			}
 		}
 	}
 
@@ -183,24 +257,33 @@ func (l *Locatr) Locate(ctx context.Context, request string) (types.LocatrComple
 		return *completion, err
 	}
 
-	if l.config.useCache {
-		url, err := l.plugin.GetCurrentContext(ctx)
-		if err == nil && url != nil {
-			if _, ok := l.cache[*url]; !ok {
-				l.cache[*url] = []types.CacheEntry{}
-			}
-
-			l.cache[*url] = append(l.cache[*url], types.CacheEntry{
-				UserRequest: request,
-				Locators:    completion.Locators,
-				LocatorType: completion.LocatorType,
-			})
+	if cacheKey != "" {
+		createdAt := time.Now().UTC()
+		entry := types.CacheEntry{
+			UserRequest: request,
+			Locators:    completion.Locators,
+			LocatorType: completion.LocatorType,
+			CreatedAt:   &createdAt,
+		}
+		if element, err := l.locateElement(ctx, completion.Locators[0]); err == nil {
+			entry.Fingerprint = types.NewElementFingerprint(element)
+		} else {
+			l.config.logger.Warn("couldn't fingerprint located element", "error", err)
+		}
 
-			if err := l.persistCache(); err != nil {
-				l.config.logger.Error("couldn't persist cache", "error", err)
+		// Replace the stale entry for the same request, if any
+		entries := []types.CacheEntry{}
+		for _, cached := range l.cache[cacheKey] {
+			if cached.UserRequest != request {
+				entries = append(entries, cached)
 			}
+		}
+		l.cache[cacheKey] = append(entries, entry)
+		// The drift was fixed by the fresh locators
+		completion.CacheRefreshed = completion.CacheDrift != nil
 
-			return *completion, err
+		if err := l.persistCache(); err != nil {
+			l.config.logger.Error("couldn't persist cache", "error", err)
 		}
 	}
 	return *completion, nil
@@ -336,7 +419,7 @@ func (l *Locatr) persistCache() error {
 	if err = os.MkdirAll(filepath.Dir(l.config.cachePath), 0755); err != nil {
 		return err
 	}
-	file, err := os.OpenFile(l.config.cachePath, os.O_CREATE|os.O_WRONLY, 0644)
+	file, err := os.OpenFile(l.config.cachePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
 	if err != nil {
 		return fmt.Errorf("failed to create file: %v", err)
 	}
@@ -348,19 +431,35 @@ func (l *Locatr) persistCache() error {
 	return nil
 }
 
-// processCacheRequest attempts to find locators associated with the user request and current context in the cache.
+// getCacheKey returns the key under which the cache entries of the current screen are stored.
+// Depending on the configured CacheKey, this is either the current context (URL or activity)
+// or a structural hash of the minified DOM.
+func (l *Locatr) getCacheKey(ctx context.Context) (string, error) {
+	if l.config.cacheKey == CacheKeyDOMStructure {
+		dom, err := l.plugin.GetMinifiedDOM(ctx)
+		if err != nil {
+			return "", fmt.Errorf("couldn't get minified DOM: %w", err)
+		}
+		return StructureCacheKeyPrefix + utils.StructuralHash(dom.RootElement), nil
+	}
+
+	url, err := l.plugin.GetCurrentContext(ctx)
+	if err != nil || url == nil {
+		return "", errors.New("couldn't get current context")
+	}
+	return *url, nil
+}
+
+// processCacheRequest attempts to find locators associated with the user request under the given cache key.
 // Parameters:
+//   - cacheKey: Key of the current screen, see getCacheKey
 //   - request: Natural language description to look up
 //   - completion: Output structure to populate with cache results
 //
 // Returns error if no valid cached locators are found.
-func (l *Locatr) processCacheRequest(ctx context.Context, request string, completion *types.LocatrCompletion) error {
-	l.config.logger.Info("Searching for locators in cache")
-	url, err := l.plugin.GetCurrentContext(ctx)
-	if err != nil && url == nil {
-		return errors.New("couldn't get current context")
-	}
-	if entries, ok := l.cache[*url]; ok {
+func (l *Locatr) processCacheRequest(ctx context.Context, cacheKey, request string, completion *types.LocatrCompletion) error {
+	l.config.logger.Info("Searching for locators in cache", "key", cacheKey)
+	if entries, ok := l.cache[cacheKey]; ok {
 		for _, entry := range entries {
 			if entry.UserRequest != request {
 				continue
@@ -375,14 +474,58 @@ func (l *Locatr) processCacheRequest(ctx context.Context, request string, comple
 				validLocators = append(validLocators, locator)
 			}
 
-			if len(validLocators) > 0 {
-				l.config.logger.Info("Cache hit", "request", entry.UserRequest)
-				completion.Locators = validLocators
-				completion.LocatorType = entry.LocatorType
-				completion.CacheHit = true
-				return nil
+			if len(validLocators) == 0 {
+				continue
+			}
+
+			if entry.Fingerprint != nil && *l.config.driftThreshold > 0 {
+				drift := l.checkDrift(ctx, validLocators[0], entry.Fingerprint)
+				if drift.Similarity < *l.config.driftThreshold {
+					l.config.logger.Warn(
+						"Cached locator drifted from its fingerprint",
+						"request", entry.UserRequest,
+						"similarity", drift.Similarity,
+						"mismatches", drift.Mismatches,
+					)
+					completion.CacheDrift = drift
+					return fmt.Errorf("cached element drifted for user request: %v", request)
+				}
 			}
+
+			l.config.logger.Info("Cache hit", "request", entry.UserRequest)
+			completion.Locators = validLocators
+			completion.LocatorType = entry.LocatorType
+			completion.CacheHit = true
+			return nil
 		}
 	}
 	return fmt.Errorf("no cache entry found for user request: %v", request)
 }
+
+// locateElement finds the element spec in the current minified DOM that the locator resolves to.
+func (l *Locatr) locateElement(ctx context.Context, locator string) (*types.ElementSpec, error) {
+	dom, err := l.plugin.GetMinifiedDOM(ctx)
+	if err != nil {
+		return nil, err
+	}
+	element := utils.FindElementByLocator(dom, locator)
+	if element == nil {
+		return nil, fmt.Errorf("no element found in the minified DOM for locator: %v", locator)
+	}
+	return element, nil
+}
+
+// checkDrift compares the fingerprint of a cached element with the live element its locator resolves to.
+// Parameters:
+//   - locator: Locator of the cached element
+//   - fingerprint: Fingerprint stored with the cache entry
+//
+// Returns the drift between the cached and the live element.
+func (l *Locatr) checkDrift(ctx context.Context, locator string, fingerprint *types.ElementFingerprint) *types.CacheDrift {
+	element, err := l.locateElement(ctx, locator)
+	if err != nil {
+		return &types.CacheDrift{Similarity: 0, Mismatches: []string{err.Error()}}
+	}
+	similarity, mismatches := fingerprint.Compare(types.NewElementFingerprint(element))
+	return &types.CacheDrift{Similarity: similarity, Mismatches: mismatches}
+}
@@ -0,0 +1,129 @@
+package locatr
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vertexcover-io/locatr/pkg/types"
+)
+
+// fakePagePlugin serves a page where the cached "#old" locator now resolves to a footer.
+type fakePagePlugin struct {
+	types.PluginInterface
+}
+
+func (p *fakePagePlugin) GetCurrentContext(ctx context.Context) (*string, error) {
+	url := "https://example.com/login"
+	return &url, nil
+}
+
+func (p *fakePagePlugin) GetMinifiedDOM(ctx context.Context) (*types.DOM, error) {
+	return &types.DOM{
+		RootElement: &types.ElementSpec{
+			Id: "root", TagName: "div",
+			Children: []types.ElementSpec{
+				{Id: "1", TagName: "p", Text: "Footer"},
+				{
+					Id: "2", TagName: "button", Text: "Login",
+					Attributes: map[string]string{"data-supported-primitives": "click"},
+				},
+			},
+		},
+		Metadata: &types.DOMMetadata{
+			LocatorType: types.CssSelectorType,
+			LocatorMap:  map[string][]string{"1": {"#old"}, "2": {"#new"}},
+		},
+	}, nil
+}
+
+func (p *fakePagePlugin) IsLocatorValid(ctx context.Context, locator string) (bool, error) {
+	return true, nil
+}
+
+// fakeLLMClient only describes the model, the completions are made by fakeMode.
+type fakeLLMClient struct {
+	types.LLMClientInterface
+}
+
+func (c *fakeLLMClient) GetProvider() types.LLMProvider { return "openai" }
+
+func (c *fakeLLMClient) GetModel() string { return "gpt-4o" }
+
+// fakeMode locates the given locators, or fails if there are none.
+type fakeMode struct {
+	locators []string
+}
+
+func (m *fakeMode) ProcessRequest(
+	ctx context.Context,
+	request string,
+	plugin types.PluginInterface,
+	llmClient types.LLMClientInterface,
+	rerankerClient types.RerankerClientInterface,
+	logger *slog.Logger,
+	completion *types.LocatrCompletion,
+) error {
+	if len(m.locators) == 0 {
+		return errors.New("no relevant element ID found in the DOM")
+	}
+	completion.Locators = m.locators
+	completion.LocatorType = types.CssSelectorType
+	return nil
+}
+
+func TestLocatr_CacheDrift(t *testing.T) {
+	tests := []struct {
+		name              string
+		locators          []string
+		expectedErr       string
+		expectedRefreshed bool
+		expectedCached    []string
+	}{
+		{
+			name:              "refreshes the drifted entry",
+			locators:          []string{"#new"},
+			expectedRefreshed: true,
+			expectedCached:    []string{"#new"},
+		},
+		{
+			name:           "keeps the drifted entry if the element can't be located again",
+			expectedErr:    "no relevant element ID found in the DOM",
+			expectedCached: []string{"#old"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cachePath := filepath.Join(t.TempDir(), "cache.json")
+			instance, err := NewLocatr(
+				&fakePagePlugin{},
+				WithLLMClient(&fakeLLMClient{}),
+				WithRerankerClient(struct{ types.RerankerClientInterface }{}),
+				WithMode(&fakeMode{locators: tt.locators}),
+				EnableCache(&cachePath),
+			)
+			assert.NoError(t, err)
+			instance.cache["https://example.com/login"] = []types.CacheEntry{{
+				UserRequest: "login button",
+				Locators:    []string{"#old"},
+				LocatorType: types.CssSelectorType,
+				Fingerprint: &types.ElementFingerprint{TagName: "button", Text: "Login", SupportedPrimitives: "click"},
+			}}
+
+			completion, err := instance.Locate(context.Background(), "login button")
+			if tt.expectedErr != "" {
+				assert.EqualError(t, err, tt.expectedErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.False(t, completion.CacheHit)
+			assert.NotNil(t, completion.CacheDrift)
+			assert.Equal(t, tt.expectedRefreshed, completion.CacheRefreshed)
+			assert.Equal(t, tt.expectedCached, instance.cache["https://example.com/login"][0].Locators)
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package mode
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/vertexcover-io/locatr/pkg/internal/utils"
+	"github.com/vertexcover-io/locatr/pkg/types"
+)
+
+// interactionDensityWeight is the weight of the density of interactable elements in the heuristic score of a chunk,
+// relative to the share of the words of the request found in it.
+const interactionDensityWeight = 0.25
+
+// openingTagPattern matches the opening tags of an HTML chunk.
+var openingTagPattern = regexp.MustCompile(`<[a-zA-Z][^>]*>`)
+
+// heuristicRanking ranks the chunks without a reranker, by the share of the words of the request found in
+// each chunk and the density of its elements supporting interactions (see data-supported-primitives).
+//
+// Parameters:
+//   - request: The user request
+//   - chunks: The DOM chunks to rank
+//   - topN: The number of results to return, all of them if zero
+//
+// Returns the TopN chunks sorted by decreasing score, ties keeping the order of the chunks.
+func heuristicRanking(request string, chunks []string, topN int) []types.RerankResult {
+	terms := map[string]bool{}
+	for _, word := range utils.Tokenize(request) {
+		terms[word] = true
+	}
+
+	results := make([]types.RerankResult, len(chunks))
+	for i, chunk := range chunks {
+		score := 0.0
+		if len(terms) > 0 {
+			words := map[string]bool{}
+			for _, word := range utils.Tokenize(chunk) {
+				words[word] = true
+			}
+			matches := 0
+			for term := range terms {
+				if words[term] {
+					matches++
+				}
+			}
+			score = float64(matches) / float64(len(terms))
+		}
+		if tags := len(openingTagPattern.FindAllStringIndex(chunk, -1)); tags > 0 {
+			interactable := strings.Count(chunk, "data-supported-primitives")
+			score += interactionDensityWeight * min(float64(interactable)/float64(tags), 1)
+		}
+		results[i] = types.RerankResult{Index: i, Score: score}
+	}
+
+	return utils.TopRerankResults(results, topN)
+}
+
+// rankChunks ranks the chunks for the request with the reranker. When the reranker fails and the policy
+// allows it, the chunks are ranked with heuristicRanking instead and the completion is flagged with DegradedRanking.
+// The token usage of rerankers using a language model is added to the RerankUsage of the completion.
+//
+// Parameters:
+//   - rerankerClient: The reranker
+//   - policy: How a failure of the reranker is handled, the zero value falling back to the heuristic
+//   - request: The user request
+//   - chunks: The DOM chunks to rank
+//   - topN: The number of results to return
+//   - logger: The logger of the request
+//   - completion: The completion flagged on degradation, and charged with the usage of the reranker
+//
+// Returns:
+//   - []RerankResult: The ranking of the chunks
+//   - error: If the policy is invalid, or the reranker fails and the policy is to abort or the context is done
+func rankChunks(
+	ctx context.Context,
+	rerankerClient types.RerankerClientInterface,
+	policy types.RerankerFailurePolicy,
+	request string,
+	chunks []string,
+	topN int,
+	logger *slog.Logger,
+	completion *types.LocatrCompletion,
+) ([]types.RerankResult, error) {
+	if policy != "" && policy != types.RerankerFailureFallback && policy != types.RerankerFailureAbort {
+		return nil, fmt.Errorf("invalid reranker failure policy: %q", policy)
+	}
+	usage := &types.LLMCompletionMeta{}
+	results, err := rerankerClient.Rerank(
+		types.WithRerankUsage(ctx, usage), &types.RerankRequest{Query: request, Documents: chunks, TopN: topN},
+	)
+	if usage.Model != "" {
+		if completion.RerankUsage == nil {
+			completion.RerankUsage = &types.LLMCompletionMeta{Provider: usage.Provider, Model: usage.Model}
+		}
+		completion.RerankUsage.Accumulate(*usage)
+	}
+	if err == nil {
+		return results, nil
+	}
+	if policy == types.RerankerFailureAbort || ctx.Err() != nil {
+		return nil, err
+	}
+	logger.Warn("reranker failed, ranking the chunks with a local heuristic", "error", err)
+	completion.DegradedRanking = true
+	return heuristicRanking(request, chunks, topN), nil
+}
@@ -0,0 +1,172 @@
+package mode
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/vertexcover-io/locatr/pkg/types"
+)
+
+func TestHeuristicRanking(t *testing.T) {
+	chunks := []string{
+		`<div id="1"><p id="2">Your cart is empty</p></div>`,
+		`<div id="3"><a id="4" data-supported-primitives="click">Help</a><a id="5" data-supported-primitives="click">Terms</a></div>`,
+		`<div id="6"><button id="7" data-supported-primitives="click">Checkout</button><p id="8">Total</p></div>`,
+	}
+	tests := []struct {
+		name     string
+		request  string
+		topN     int
+		expected []int
+	}{
+		{name: "text overlap first", request: "Click the Checkout button", topN: 2, expected: []int{2, 1}},
+		{name: "interactable density breaks ties", request: "open the settings", expected: []int{1, 2, 0}},
+		{name: "partial overlap", request: "empty cart", expected: []int{0, 1, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := heuristicRanking(tt.request, chunks, tt.topN)
+			indexes := []int{}
+			for _, result := range results {
+				indexes = append(indexes, result.Index)
+			}
+			assert.Equal(t, tt.expected, indexes)
+		})
+	}
+}
+
+func TestRankChunks(t *testing.T) {
+	chunks := []string{`<p id="1">Footer</p>`, `<button id="2" data-supported-primitives="click">Login</button>`}
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name             string
+		ctx              context.Context
+		policy           types.RerankerFailurePolicy
+		rerankErr        error
+		expected         []types.RerankResult
+		expectedErr      string
+		expectedDegraded bool
+	}{
+		{
+			name:     "reranker results",
+			ctx:      context.Background(),
+			expected: []types.RerankResult{{Index: 0, Score: 0.7}},
+		},
+		{
+			name:             "fallback by default",
+			ctx:              context.Background(),
+			rerankErr:        errors.New("quota exceeded"),
+			expected:         []types.RerankResult{{Index: 1, Score: 1.25}},
+			expectedDegraded: true,
+		},
+		{
+			name:             "explicit fallback",
+			ctx:              context.Background(),
+			policy:           types.RerankerFailureFallback,
+			rerankErr:        errors.New("quota exceeded"),
+			expected:         []types.RerankResult{{Index: 1, Score: 1.25}},
+			expectedDegraded: true,
+		},
+		{
+			name:        "abort",
+			ctx:         context.Background(),
+			policy:      types.RerankerFailureAbort,
+			rerankErr:   errors.New("quota exceeded"),
+			expectedErr: "quota exceeded",
+		},
+		{
+			name:        "canceled context",
+			ctx:         canceled,
+			rerankErr:   context.Canceled,
+			expectedErr: "context canceled",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockReranker := new(MockRerankerClient)
+			mockReranker.On("Rerank", mock.Anything, &types.RerankRequest{Query: "login", Documents: chunks, TopN: 1}).
+				Return([]types.RerankResult{{Index: 0, Score: 0.7}}, tt.rerankErr)
+			completion := &types.LocatrCompletion{}
+
+			results, err := rankChunks(tt.ctx, mockReranker, tt.policy, "login", chunks, 1, slog.Default(), completion)
+			if tt.expectedErr != "" {
+				assert.EqualError(t, err, tt.expectedErr)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expected, results)
+			}
+			assert.Equal(t, tt.expectedDegraded, completion.DegradedRanking)
+			mockReranker.AssertExpectations(t)
+		})
+	}
+
+	t.Run("invalid policy", func(t *testing.T) {
+		_, err := rankChunks(
+			context.Background(), new(MockRerankerClient), "retry", "login", chunks, 1, slog.Default(),
+			&types.LocatrCompletion{},
+		)
+		assert.EqualError(t, err, `invalid reranker failure policy: "retry"`)
+	})
+
+	t.Run("reranker usage", func(t *testing.T) {
+		mockReranker := new(MockRerankerClient)
+		mockReranker.On("Rerank", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			types.AddRerankUsage(args.Get(0).(context.Context), types.LLMCompletionMeta{
+				Provider: "openai", Model: "gpt-4o-mini", InputTokens: 120, OutputTokens: 12,
+			})
+		}).Return([]types.RerankResult{{Index: 0, Score: 0.7}}, nil)
+		completion := &types.LocatrCompletion{}
+
+		for range 2 {
+			_, err := rankChunks(
+				context.Background(), mockReranker, "", "login", chunks, 1, slog.Default(), completion,
+			)
+			assert.NoError(t, err)
+		}
+		assert.Equal(t, &types.LLMCompletionMeta{
+			Provider: "openai", Model: "gpt-4o-mini", InputTokens: 240, OutputTokens: 24,
+		}, completion.RerankUsage)
+		assert.Zero(t, completion.InputTokens)
+	})
+}
+
+func TestDOMAnalysisMode_DegradedRanking(t *testing.T) {
+	ctx := context.Background()
+	mockPlugin := new(MockPlugin)
+	mockLLM := new(MockLLMClient)
+	mockReranker := new(MockRerankerClient)
+
+	mockPlugin.On("GetMinifiedDOM", ctx).Return(&types.DOM{
+		RootElement: &types.ElementSpec{
+			Id: "root", TagName: "div",
+			Children: []types.ElementSpec{{Id: "elem-123", TagName: "button", Text: "Login"}},
+		},
+		Metadata: &types.DOMMetadata{LocatorMap: map[string][]string{"elem-123": {"//*[@id='login']"}}},
+	}, nil)
+	mockReranker.On("Rerank", mock.Anything, mock.Anything).Return([]types.RerankResult(nil), errors.New("connection refused"))
+	mockLLM.On("GetModel").Return("claude-3-5-sonnet-latest").Maybe()
+	mockLLM.On("GetStructuredCompletion", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(&types.JSONCompletion{
+		JSON: `{"element_id": "elem-123", "error": "", "confidence": 0.9}`,
+	}, nil)
+
+	completion := &types.LocatrCompletion{}
+	err := (&DOMAnalysisMode{}).ProcessRequest(
+		ctx, "find the login button", mockPlugin, mockLLM, mockReranker, slog.Default(), completion,
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"//*[@id='login']"}, completion.Locators)
+	assert.True(t, completion.DegradedRanking)
+
+	err = (&DOMAnalysisMode{RerankerFailure: types.RerankerFailureAbort}).ProcessRequest(
+		ctx, "find the login button", mockPlugin, mockLLM, mockReranker, slog.Default(), &types.LocatrCompletion{},
+	)
+	assert.EqualError(t, err, "connection refused")
+}
@@ -6,6 +6,8 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"math"
+	"slices"
 	"strings"
 
 	"github.com/vertexcover-io/locatr/pkg/internal/constants"
@@ -18,7 +20,16 @@ import (
 // DOM_ANALYSIS_PROMPT_TEMPLATE defines the system prompt for extracting element IDs from DOM.
 // The prompt instructs the LLM to identify elements based on user requirements and supported interactions
 // (clickable, hoverable, inputable, selectable) by analyzing data-supported-primitives attributes.
-const DOM_ANALYSIS_PROMPT_TEMPLATE string = `Your task is to identify the element that matches a user's requirement from a given DOM structure and return its unique_id in a JSON format. If the element is not found, provide an appropriate error message in the JSON output.
+// The instructions and the DOM come first and are declared as prompt cache prefixes, so that providers supporting
+// prompt caching don't bill them in full when they are sent again by retries or other requests on the same page.
+const DOM_ANALYSIS_PROMPT_TEMPLATE string = domAnalysisInstructions + domAnalysisDOMInputTemplate + `
+  "user_request": "%s"
+}
+Process the input accordingly and ensure that if the element is not found, the "error" field contains a relevant message.
+`
+
+// domAnalysisInstructions are the static instructions of the DOM analysis prompt.
+const domAnalysisInstructions string = `Your task is to identify the element that matches a user's requirement from a given DOM structure and return its unique_id in a JSON format. If the element is not found, provide an appropriate error message in the JSON output.
 
 Each element may contain an attribute called "data-supported-primitives" which indicates its supported interactions. The following attributes determine whether an element is "clickable", "hoverable", "inputable", or "selectable":
 
@@ -30,17 +41,60 @@ Each element may contain an attribute called "data-supported-primitives" which i
 Provide your response in valid JSON format with the following structure:
 {
   "element_id": "str",     // The unique id of the element that matches the user's requirement.
-  "error": "str"           // An appropriate error message if the element is not found.
+  "error": "str",          // An appropriate error message if the element is not found.
+  "confidence": float      // How confident you are that the element matches the user's requirement, between 0 and 1.
 }
+`
 
+// domAnalysisDOMInputTemplate is the input of the DOM analysis prompt up to the user request.
+const domAnalysisDOMInputTemplate string = `
 Input:
 {
-  "dom": "%s",
-  "user_request": "%s"
-}
-Process the input accordingly and ensure that if the element is not found, the "error" field contains a relevant message.
+  "dom": "%s",`
+
+// DOM_ANALYSIS_FEEDBACK_TEMPLATE defines the follow-up of a DOM analysis prompt after a failed attempt.
+// It is appended to the earlier turns with the rejected response, the reason it was rejected and
+// optionally more of the DOM (see DOM_ANALYSIS_ADDITIONAL_DOM_TEMPLATE).
+const DOM_ANALYSIS_FEEDBACK_TEMPLATE string = `
+Your previous response was:
+%s
+
+It was rejected because %s
+%s
+Correct your response for the same user request, using all of the DOM given so far and the same JSON format.
+`
+
+// DOM_ANALYSIS_ADDITIONAL_DOM_TEMPLATE defines the additional DOM chunks of a follow-up prompt.
+const DOM_ANALYSIS_ADDITIONAL_DOM_TEMPLATE string = `
+Here is more of the DOM structure, the element may be part of it:
+%s
 `
 
+// domAnalysisOutputSchema is the JSON schema of the output of the DOM analysis prompt.
+var domAnalysisOutputSchema = &types.JSONSchema{
+	Name:        "dom_analysis_output",
+	Description: "The element of the DOM that matches the user's requirement",
+	Schema: map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"element_id": map[string]any{
+				"type":        "string",
+				"description": "The unique id of the element that matches the user's requirement, or an empty string if not found",
+			},
+			"error": map[string]any{
+				"type":        "string",
+				"description": "An appropriate error message if the element is not found, otherwise an empty string",
+			},
+			"confidence": map[string]any{
+				"type":        "number",
+				"description": "How confident you are that the element matches the user's requirement, between 0 and 1",
+			},
+		},
+		"required":             []string{"element_id", "error", "confidence"},
+		"additionalProperties": false,
+	},
+}
+
 type DOMAnalysisMode struct {
 	// The size of the chunks to process. Defaults to constants.DEFAULT_CHUNK_SIZE
 	ChunkSize int `json:"chunk_size"`
@@ -48,6 +102,28 @@ type DOMAnalysisMode struct {
 	MaxAttempts int `json:"max_attempts"`
 	// The number of chunks to process per attempt. Defaults to constants.DEFAULT_CHUNKS_PER_ATTEMPT
 	ChunksPerAttempt int `json:"chunks_per_attempt"`
+	// The share of the context window of the model to fill with chunks in each attempt, between 0 and 1.
+	// When set and the model is known (see types.LookupClientCapabilities), ChunksPerAttempt is derived from it,
+	// and ChunkSize is reduced if a single chunk doesn't fit. Disabled by default.
+	ContextWindowShare float64 `json:"context_window_share"`
+	// The tokenizer used to estimate the size of prompts. Defaults to types.HeuristicTokenizer
+	Tokenizer types.Tokenizer `json:"-"`
+	// The maximum number of turns of a conversation. Failed attempts are fed back to the model in the next
+	// attempt along with the earlier context, until a new conversation is started after MaxTurns turns, or when
+	// the earlier turns would outgrow the context window of a known model (or its ContextWindowShare).
+	// Defaults to constants.DEFAULT_MAX_TURNS, 1 makes every attempt start from scratch
+	MaxTurns int `json:"max_turns"`
+	// Whether to check that the located element is on the page, feeding it back to the model otherwise
+	VerifyLocators bool `json:"verify_locators"`
+	// How a failure of the reranker is handled. Defaults to types.RerankerFailureFallback, ranking the chunks
+	// with a local heuristic
+	RerankerFailure types.RerankerFailurePolicy `json:"reranker_failure"`
+}
+
+// attemptFeedback is the response of a failed attempt and the reason it was rejected.
+type attemptFeedback struct {
+	response string
+	reason   string
 }
 
 func (m *DOMAnalysisMode) ProcessRequest(
@@ -65,13 +141,27 @@ func (m *DOMAnalysisMode) ProcessRequest(
 	if err != nil {
 		return err
 	}
-	domChunks := splitters.SplitHtml(dom.RootElement.Repr(), constants.HTML_SEPARATORS, m.ChunkSize)
+	domRepr := dom.RootElement.Repr()
 
-	results, err := rerankerClient.Rerank(
-		ctx,
-		&types.RerankRequest{
-			Query: request, Documents: domChunks, TopN: m.MaxAttempts * m.ChunksPerAttempt,
-		},
+	model := llmClient.GetModel()
+	capabilities, knownModel := types.LookupClientCapabilities(llmClient)
+	chunkSize, chunksPerAttempt := m.ChunkSize, m.ChunksPerAttempt
+	if m.ContextWindowShare > 0 {
+		if knownModel {
+			chunkSize, chunksPerAttempt = m.sizeChunks(domRepr, request, capabilities)
+			logger.Info(
+				"Sized chunks for the context window",
+				"model", model, "chunk_size", chunkSize, "chunks_per_attempt", chunksPerAttempt,
+			)
+		} else {
+			logger.Warn("unknown context window, using the configured chunk sizes", "model", model)
+		}
+	}
+	domChunks := splitters.SplitHtml(domRepr, constants.HTML_SEPARATORS, chunkSize)
+
+	results, err := rankChunks(
+		ctx, rerankerClient, m.RerankerFailure, request, domChunks, m.MaxAttempts*chunksPerAttempt,
+		logger, completion,
 	)
 	if err != nil {
 		return err
@@ -82,57 +172,153 @@ func (m *DOMAnalysisMode) ProcessRequest(
 		return fmt.Errorf("no chunks to process")
 	}
 
-	locatorMap := dom.Metadata.LocatorMap
-	var analysisOutput struct {
-		ElementId    string `json:"element_id"`
-		ErrorMessage string `json:"error"`
+	// The earlier turns of a conversation count against the prompt budget, so that follow-ups
+	// don't outgrow the share of the context window the chunks were sized for
+	promptBudget := 0
+	if knownModel {
+		share := 1.0
+		if m.ContextWindowShare > 0 {
+			share = min(m.ContextWindowShare, 1)
+		}
+		promptBudget = int(share*float64(capabilities.ContextWindow)) - capabilities.CompletionTokens()
 	}
 
-	for attempt := range m.MaxAttempts {
-		startIndex := attempt * m.ChunksPerAttempt
-		endIndex := startIndex + m.ChunksPerAttempt
+	locatorMap := dom.Metadata.LocatorMap
+	prompt, turns, nextChunk := "", 0, 0
+	var cachePrefixes []string
+	var feedback *attemptFeedback
 
-		if startIndex >= len(domChunks) {
-			break
+	for attempt := range m.MaxAttempts {
+		var chunks []string
+		if nextChunk < len(domChunks) {
+			endIndex := min(nextChunk+chunksPerAttempt, len(domChunks))
+			chunks = domChunks[nextChunk:endIndex]
+			nextChunk = endIndex
 		}
 
-		if endIndex > len(domChunks) {
-			endIndex = len(domChunks)
+		if turns >= m.MaxTurns {
+			// Start a new conversation, so that the prompt doesn't grow without bounds
+			feedback = nil
 		}
+		if feedback != nil {
+			// Keep the earlier turns and tell the model what was wrong, so that it can correct itself
+			additionalDOM := ""
+			if len(chunks) > 0 {
+				additionalDOM = fmt.Sprintf(DOM_ANALYSIS_ADDITIONAL_DOM_TEMPLATE, strings.Join(chunks, "\n"))
+			}
+			followUp := prompt + fmt.Sprintf(
+				DOM_ANALYSIS_FEEDBACK_TEMPLATE, feedback.response, feedback.reason, additionalDOM,
+			)
+			if tokens := m.Tokenizer.CountTokens(followUp); promptBudget > 0 && tokens > promptBudget {
+				logger.Info(
+					"conversation would exceed the prompt budget, starting a new one",
+					"estimated_tokens", tokens, "budget", promptBudget,
+				)
+				feedback = nil
+			} else {
+				// The earlier turns are sent again as they are, so they are cached as well
+				cachePrefixes = append(cachePrefixes, prompt)
+				prompt = followUp
+				turns++
+			}
+		}
+		if feedback == nil {
+			if len(chunks) == 0 {
+				break
+			}
+			domInput := strings.Join(chunks, "\n")
+			prompt = fmt.Sprintf(DOM_ANALYSIS_PROMPT_TEMPLATE, domInput, request)
+			cachePrefixes = []string{
+				domAnalysisInstructions,
+				fmt.Sprintf(domAnalysisInstructions+domAnalysisDOMInputTemplate, domInput),
+			}
+			turns = 1
+		}
+		logger.Info("Attempt number", "attempt", attempt+1, "turn", turns)
 
-		chunks := domChunks[startIndex:endIndex]
-		logger.Info("Attempt number", "attempt", attempt+1)
-
-		prompt := fmt.Sprintf(DOM_ANALYSIS_PROMPT_TEMPLATE, strings.Join(chunks, "\n"), request)
-		jsonCompletion, err := llmClient.GetJSONCompletion(ctx, prompt, nil)
-		completion.InputTokens += jsonCompletion.InputTokens
-		completion.OutputTokens += jsonCompletion.OutputTokens
+		if knownModel {
+			if tokens := m.Tokenizer.CountTokens(prompt); tokens+capabilities.CompletionTokens() > capabilities.ContextWindow {
+				logger.Warn(
+					"prompt may overflow the context window of the model",
+					"model", model, "estimated_tokens", tokens, "context_window", capabilities.ContextWindow,
+				)
+			}
+		}
+		jsonCompletion, err := llmClient.GetStructuredCompletion(
+			types.WithPromptCachePrefixes(ctx, cachePrefixes...), prompt, nil, domAnalysisOutputSchema,
+		)
+		if jsonCompletion != nil {
+			completion.Accumulate(jsonCompletion.LLMCompletionMeta)
+		}
 		if err != nil {
 			logger.Error("couldn't get JSON completion", "error", err)
+			// There is no response to give feedback on, the next attempt starts over
+			feedback = nil
 			continue
 		}
+		feedback = &attemptFeedback{response: jsonCompletion.JSON}
 
+		var analysisOutput struct {
+			ElementId    string `json:"element_id"`
+			ErrorMessage string `json:"error"`
+		}
 		if err = json.Unmarshal([]byte(jsonCompletion.JSON), &analysisOutput); err != nil {
 			logger.Error("failed to unmarshal JSON", "error", err)
+			feedback.reason = fmt.Sprintf("it isn't valid JSON (%v).", err)
 			continue
 		}
 
 		// Check if there's an error message
 		if strings.TrimSpace(analysisOutput.ErrorMessage) != "" {
 			logger.Error("error getting relevant element ID", "error", analysisOutput.ErrorMessage)
+			feedback.reason = fmt.Sprintf(
+				"you reported an error: %q. Reconsider the DOM given so far before reporting an error again.",
+				analysisOutput.ErrorMessage,
+			)
 			continue
 		}
 
 		if strings.TrimSpace(analysisOutput.ElementId) == "" {
 			logger.Error("no relevant element ID found")
+			feedback.reason = "it contains neither an element_id nor an error."
 			continue
 		}
 
 		locators := locatorMap[analysisOutput.ElementId]
 		if len(locators) == 0 {
 			logger.Error("no locators found associated with element ID", "element_id", analysisOutput.ElementId)
+			feedback.reason = fmt.Sprintf(
+				"the element_id %q doesn't exist in the DOM. Only use the id attribute of the elements given.",
+				analysisOutput.ElementId,
+			)
 			continue
 		}
+
+		// Only the DOMs of web pages carry the interactions supported by their elements
+		if dom.Metadata.LocatorType == types.CssSelectorType {
+			if element := utils.FindElementByLocator(dom, locators[0]); element != nil && !isInteractable(element) {
+				logger.Error("located element is not interactable", "element_id", analysisOutput.ElementId)
+				feedback.reason = fmt.Sprintf(
+					"the element %q is not interactable, its data-supported-primitives is %q. "+
+						"Choose an element supporting the interaction of the user request.",
+					analysisOutput.ElementId, element.Attributes["data-supported-primitives"],
+				)
+				continue
+			}
+		}
+		if m.VerifyLocators {
+			valid, err := plugin.IsLocatorValid(ctx, locators[0])
+			if err != nil {
+				logger.Warn("couldn't verify locator", "locator", locators[0], "error", err)
+			} else if !valid {
+				logger.Error("located element is not on the page", "element_id", analysisOutput.ElementId)
+				feedback.reason = fmt.Sprintf(
+					"the element %q can't be found on the page. Choose another element.",
+					analysisOutput.ElementId,
+				)
+				continue
+			}
+		}
 		completion.Locators = locators
 		completion.LocatorType = dom.Metadata.LocatorType
 		return nil
@@ -140,6 +326,19 @@ func (m *DOMAnalysisMode) ProcessRequest(
 	return errors.New("no relevant element ID found in the DOM")
 }
 
+// interactionPrimitives are the values of the data-supported-primitives attribute described by the prompt.
+var interactionPrimitives = []string{"click", "hover", "input_text", "select_option"}
+
+// isInteractable reports whether the element supports one of the interactionPrimitives.
+func isInteractable(element *types.ElementSpec) bool {
+	for _, primitive := range strings.Split(element.Attributes["data-supported-primitives"], ",") {
+		if slices.Contains(interactionPrimitives, strings.TrimSpace(primitive)) {
+			return true
+		}
+	}
+	return false
+}
+
 func (m *DOMAnalysisMode) applyDefaults() {
 	if m.ChunkSize <= 0 {
 		m.ChunkSize = constants.DEFAULT_CHUNK_SIZE
@@ -150,4 +349,28 @@ func (m *DOMAnalysisMode) applyDefaults() {
 	if m.ChunksPerAttempt <= 0 {
 		m.ChunksPerAttempt = constants.DEFAULT_CHUNKS_PER_ATTEMPT
 	}
+	if m.Tokenizer == nil {
+		m.Tokenizer = types.HeuristicTokenizer{}
+	}
+	if m.MaxTurns <= 0 {
+		m.MaxTurns = constants.DEFAULT_MAX_TURNS
+	}
+}
+
+// sizeChunks returns the chunk size and the number of chunks per attempt filling the configured share
+// of the context window of the model, keeping room for the instructions and the completion.
+func (m *DOMAnalysisMode) sizeChunks(dom, request string, capabilities types.ModelCapabilities) (int, int) {
+	overhead := m.Tokenizer.CountTokens(fmt.Sprintf(DOM_ANALYSIS_PROMPT_TEMPLATE, "", request))
+	share := min(m.ContextWindowShare, 1)
+	budget := int(share*float64(capabilities.ContextWindow)) - overhead - capabilities.CompletionTokens()
+	tokensPerChar := float64(m.Tokenizer.CountTokens(dom)) / float64(max(len(dom), 1))
+	if budget <= 0 || tokensPerChar == 0 {
+		return m.ChunkSize, m.ChunksPerAttempt
+	}
+
+	chunkTokens := int(math.Ceil(float64(m.ChunkSize) * tokensPerChar))
+	if chunkTokens >= budget {
+		return max(1, int(float64(budget)/tokensPerChar)), 1
+	}
+	return m.ChunkSize, budget / chunkTokens
 }
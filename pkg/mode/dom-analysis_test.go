@@ -3,7 +3,12 @@ package mode
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
+	"math"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -76,6 +81,11 @@ func (m *MockLLMClient) GetJSONCompletion(ctx context.Context, prompt string, im
 	return args.Get(0).(*types.JSONCompletion), args.Error(1)
 }
 
+func (m *MockLLMClient) GetStructuredCompletion(ctx context.Context, prompt string, image []byte, schema *types.JSONSchema) (*types.JSONCompletion, error) {
+	args := m.Called(ctx, prompt, image, schema)
+	return args.Get(0).(*types.JSONCompletion), args.Error(1)
+}
+
 type MockRerankerClient struct {
 	mock.Mock
 }
@@ -114,7 +124,7 @@ func TestDOMAnalysisMode_ProcessRequest(t *testing.T) {
 				}, nil)
 
 				// Mock reranker response with non-empty results
-				mr.On("Rerank", ctx, mock.Anything).Return([]types.RerankResult{
+				mr.On("Rerank", mock.Anything, mock.Anything).Return([]types.RerankResult{
 					{Index: 0, Score: 0.9},
 				}, nil)
 
@@ -124,7 +134,7 @@ func TestDOMAnalysisMode_ProcessRequest(t *testing.T) {
 					"error":      "",
 				}
 				jsonBytes, _ := json.Marshal(jsonResponse)
-				ml.On("GetJSONCompletion", ctx, mock.Anything, mock.Anything).Return(&types.JSONCompletion{
+				ml.On("GetStructuredCompletion", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(&types.JSONCompletion{
 					JSON: string(jsonBytes),
 					LLMCompletionMeta: types.LLMCompletionMeta{
 						InputTokens:  100,
@@ -143,7 +153,7 @@ func TestDOMAnalysisMode_ProcessRequest(t *testing.T) {
 					Metadata:    &types.DOMMetadata{},
 				}, nil)
 
-				mr.On("Rerank", ctx, mock.Anything).Return([]types.RerankResult{
+				mr.On("Rerank", mock.Anything, mock.Anything).Return([]types.RerankResult{
 					{Index: 0, Score: 0.1},
 				}, nil)
 
@@ -152,12 +162,31 @@ func TestDOMAnalysisMode_ProcessRequest(t *testing.T) {
 					"error":      "Element not found",
 				}
 				jsonBytes, _ := json.Marshal(jsonResponse)
-				ml.On("GetJSONCompletion", ctx, mock.Anything, mock.Anything).Return(&types.JSONCompletion{
+				ml.On("GetStructuredCompletion", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(&types.JSONCompletion{
 					JSON: string(jsonBytes),
 				}, nil)
 			},
 			expectedError: "no relevant element ID found in the DOM",
 		},
+		{
+			name:    "nil completion on LLM error",
+			request: "find the login button",
+			mockSetup: func(ctx context.Context, mp *MockPlugin, ml *MockLLMClient, mr *MockRerankerClient) {
+				mp.On("GetMinifiedDOM", ctx).Return(&types.DOM{
+					RootElement: &types.ElementSpec{Id: "root", TagName: "div", Text: "Login"},
+					Metadata:    &types.DOMMetadata{},
+				}, nil)
+
+				mr.On("Rerank", mock.Anything, mock.Anything).Return([]types.RerankResult{
+					{Index: 0, Score: 0.9},
+				}, nil)
+
+				ml.On("GetStructuredCompletion", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(
+					(*types.JSONCompletion)(nil), errors.New("rate limited"),
+				)
+			},
+			expectedError: "no relevant element ID found in the DOM",
+		},
 		{
 			name:    "empty DOM",
 			request: "find button",
@@ -167,9 +196,9 @@ func TestDOMAnalysisMode_ProcessRequest(t *testing.T) {
 					Metadata:    &types.DOMMetadata{},
 				}, nil)
 
-				mr.On("Rerank", ctx, mock.Anything).Return([]types.RerankResult{}, nil)
+				mr.On("Rerank", mock.Anything, mock.Anything).Return([]types.RerankResult{}, nil)
 
-				ml.On("GetJSONCompletion", ctx, mock.Anything, mock.Anything).Return(&types.JSONCompletion{
+				ml.On("GetStructuredCompletion", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(&types.JSONCompletion{
 					JSON: `{"element_id": "", "error": "No DOM content available"}`,
 				}, nil)
 			},
@@ -183,6 +212,7 @@ func TestDOMAnalysisMode_ProcessRequest(t *testing.T) {
 			mockPlugin := new(MockPlugin)
 			mockLLM := new(MockLLMClient)
 			mockReranker := new(MockRerankerClient)
+			mockLLM.On("GetModel").Return("claude-3-5-sonnet-latest").Maybe()
 
 			// Setup mocks with context
 			tt.mockSetup(ctx, mockPlugin, mockLLM, mockReranker)
@@ -237,6 +267,8 @@ func TestDOMAnalysisMode_applyDefaults(t *testing.T) {
 				ChunkSize:        constants.DEFAULT_CHUNK_SIZE,
 				MaxAttempts:      constants.DEFAULT_MAX_ATTEMPTS,
 				ChunksPerAttempt: constants.DEFAULT_CHUNKS_PER_ATTEMPT,
+				Tokenizer:        types.HeuristicTokenizer{},
+				MaxTurns:         constants.DEFAULT_MAX_TURNS,
 			},
 		},
 		{
@@ -245,11 +277,14 @@ func TestDOMAnalysisMode_applyDefaults(t *testing.T) {
 				ChunkSize:        500,
 				MaxAttempts:      5,
 				ChunksPerAttempt: 3,
+				MaxTurns:         2,
 			},
 			expected: DOMAnalysisMode{
 				ChunkSize:        500,
 				MaxAttempts:      5,
 				ChunksPerAttempt: 3,
+				Tokenizer:        types.HeuristicTokenizer{},
+				MaxTurns:         2,
 			},
 		},
 	}
@@ -261,3 +296,251 @@ func TestDOMAnalysisMode_applyDefaults(t *testing.T) {
 		})
 	}
 }
+
+// fixedTokenizer counts one token per character.
+type fixedTokenizer struct{}
+
+func (fixedTokenizer) CountTokens(text string) int { return len(text) }
+
+func TestDOMAnalysisMode_sizeChunks(t *testing.T) {
+	overhead := len(fmt.Sprintf(DOM_ANALYSIS_PROMPT_TEMPLATE, "", "find the button"))
+	tests := []struct {
+		name                     string
+		share                    float64
+		contextWindow            int
+		maxOutputTokens          int
+		expectedChunkSize        int
+		expectedChunksPerAttempt int
+	}{
+		{
+			name:                     "fills the share of a large context window",
+			share:                    0.5,
+			contextWindow:            200_000,
+			expectedChunkSize:        1000,
+			expectedChunksPerAttempt: (100_000 - overhead - types.DefaultMaxCompletionTokens) / 1000,
+		},
+		{
+			name:                     "shrinks chunks for a small context window",
+			share:                    1,
+			contextWindow:            overhead + types.DefaultMaxCompletionTokens + 600,
+			expectedChunkSize:        600,
+			expectedChunksPerAttempt: 1,
+		},
+		{
+			name:                     "reserves the maximum output of the model",
+			share:                    1,
+			contextWindow:            overhead + 256 + 600,
+			maxOutputTokens:          256,
+			expectedChunkSize:        600,
+			expectedChunksPerAttempt: 1,
+		},
+		{
+			name:                     "keeps the configured sizes if nothing fits",
+			share:                    0.1,
+			contextWindow:            overhead,
+			expectedChunkSize:        1000,
+			expectedChunksPerAttempt: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mode := &DOMAnalysisMode{
+				ChunkSize: 1000, ChunksPerAttempt: 2, ContextWindowShare: tt.share, Tokenizer: fixedTokenizer{},
+			}
+			chunkSize, chunksPerAttempt := mode.sizeChunks(
+				"<div></div>", "find the button",
+				types.ModelCapabilities{ContextWindow: tt.contextWindow, MaxOutputTokens: tt.maxOutputTokens},
+			)
+			assert.Equal(t, tt.expectedChunkSize, chunkSize)
+			assert.Equal(t, tt.expectedChunksPerAttempt, chunksPerAttempt)
+		})
+	}
+}
+
+// followUpTokenizer counts one token per character, and more than any context window for follow-up prompts.
+type followUpTokenizer struct{}
+
+func (followUpTokenizer) CountTokens(text string) int {
+	if strings.Contains(text, "Your previous response was") {
+		return math.MaxInt32
+	}
+	return len(text)
+}
+
+func TestDOMAnalysisMode_Feedback(t *testing.T) {
+	ctx := context.Background()
+	dom := &types.DOM{
+		RootElement: &types.ElementSpec{
+			Id: "root", TagName: "div",
+			Children: []types.ElementSpec{
+				{Id: "form-1", TagName: "div", Children: []types.ElementSpec{
+					{Id: "label-1", TagName: "label", Text: "Login"},
+					{
+						Id: "button-1", TagName: "button", Text: "Login",
+						Attributes: map[string]string{"data-supported-primitives": "click"},
+					},
+				}},
+				{Id: "form-2", TagName: "div", Children: []types.ElementSpec{{Id: "button-2", TagName: "button", Text: "Sign up"}}},
+			},
+		},
+		Metadata: &types.DOMMetadata{
+			LocatorMap: map[string][]string{
+				"label-1":  {"#login-label"},
+				"button-1": {"#login"},
+				"button-2": {"#signup"},
+			},
+		},
+	}
+	structuredResponse := func(elementID string) *types.JSONCompletion {
+		return &types.JSONCompletion{
+			JSON:              fmt.Sprintf(`{"element_id": %q, "error": ""}`, elementID),
+			LLMCompletionMeta: types.LLMCompletionMeta{InputTokens: 100, OutputTokens: 10},
+		}
+	}
+
+	tests := []struct {
+		name          string
+		responses     []*types.JSONCompletion
+		locatorValid  bool
+		webPage       bool
+		maxTurns      int
+		tokenizer     types.Tokenizer
+		startsOver    bool
+		expectedTurn2 []string // Substrings of the second prompt
+		notInTurn2    []string
+		expected      []string
+	}{
+		{
+			name:      "feeds back an unknown element id",
+			responses: []*types.JSONCompletion{structuredResponse("button-9"), structuredResponse("button-1")},
+			expectedTurn2: []string{
+				`Your previous response was:` + "\n" + `{"element_id": "button-9", "error": ""}`,
+				`the element_id "button-9" doesn't exist in the DOM`,
+				`"user_request": "click login"`,
+			},
+			expected: []string{"#login"},
+		},
+		{
+			name: "feeds back the error of the model",
+			responses: []*types.JSONCompletion{
+				{
+					JSON:              `{"element_id": "", "error": "no login button"}`,
+					LLMCompletionMeta: types.LLMCompletionMeta{InputTokens: 100, OutputTokens: 10},
+				},
+				structuredResponse("button-1"),
+			},
+			expectedTurn2: []string{`you reported an error: "no login button"`},
+			expected:      []string{"#login"},
+		},
+		{
+			name:         "feeds back elements that aren't on the page",
+			responses:    []*types.JSONCompletion{structuredResponse("button-2"), structuredResponse("button-1")},
+			locatorValid: true,
+			expectedTurn2: []string{
+				`the element "button-2" can't be found on the page`,
+			},
+			expected: []string{"#login"},
+		},
+		{
+			name:      "feeds back elements that aren't interactable",
+			responses: []*types.JSONCompletion{structuredResponse("label-1"), structuredResponse("button-1")},
+			webPage:   true,
+			expectedTurn2: []string{
+				`the element "label-1" is not interactable, its data-supported-primitives is ""`,
+			},
+			expected: []string{"#login"},
+		},
+		{
+			name:      "accepts elements without primitives outside of web pages",
+			responses: []*types.JSONCompletion{structuredResponse("button-9"), structuredResponse("label-1")},
+			expected:  []string{"#login-label"},
+		},
+		{
+			name:          "starts over after max turns",
+			responses:     []*types.JSONCompletion{structuredResponse("button-9"), structuredResponse("button-1")},
+			maxTurns:      1,
+			startsOver:    true,
+			notInTurn2:    []string{"Your previous response was"},
+			expectedTurn2: []string{`"user_request": "click login"`},
+			expected:      []string{"#login"},
+		},
+		{
+			name:          "starts over when the conversation exceeds the context window",
+			responses:     []*types.JSONCompletion{structuredResponse("button-9"), structuredResponse("button-1")},
+			tokenizer:     followUpTokenizer{},
+			startsOver:    true,
+			notInTurn2:    []string{"Your previous response was"},
+			expectedTurn2: []string{`"user_request": "click login"`},
+			expected:      []string{"#login"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockPlugin := new(MockPlugin)
+			mockLLM := new(MockLLMClient)
+			mockReranker := new(MockRerankerClient)
+			mockLLM.On("GetModel").Return("claude-3-5-sonnet-latest")
+			dom := *dom
+			if tt.webPage {
+				dom.Metadata = &types.DOMMetadata{LocatorType: types.CssSelectorType, LocatorMap: dom.Metadata.LocatorMap}
+			}
+			mockPlugin.On("GetMinifiedDOM", ctx).Return(&dom, nil)
+			// The DOM is split into one chunk per button when starting over, so that there are new chunks to send
+			mockReranker.On("Rerank", mock.Anything, mock.Anything).Return([]types.RerankResult{
+				{Index: 0, Score: 0.9}, {Index: 1, Score: 0.8}, {Index: 2, Score: 0.7},
+			}, nil)
+			if tt.locatorValid {
+				mockPlugin.On("IsLocatorValid", ctx, "#signup").Return(false, nil)
+				mockPlugin.On("IsLocatorValid", ctx, "#login").Return(true, nil)
+			}
+
+			prompts, cachePrefixes := []string{}, [][]string{}
+			for _, response := range tt.responses {
+				mockLLM.On("GetStructuredCompletion", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+					Run(func(args mock.Arguments) {
+						prompts = append(prompts, args.String(1))
+						cachePrefixes = append(cachePrefixes, types.PromptCachePrefixes(args.Get(0).(context.Context)))
+					}).
+					Return(response, nil).Once()
+			}
+
+			mode := &DOMAnalysisMode{
+				MaxAttempts: 3, MaxTurns: tt.maxTurns, VerifyLocators: tt.locatorValid, Tokenizer: tt.tokenizer,
+			}
+			if tt.startsOver {
+				mode.ChunkSize, mode.ChunksPerAttempt = 50, 1
+			}
+			completion := &types.LocatrCompletion{}
+			err := mode.ProcessRequest(
+				ctx, "click login", mockPlugin, mockLLM, mockReranker, slog.Default(), completion,
+			)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, completion.Locators)
+			assert.Equal(t, 200, completion.InputTokens)
+
+			if !assert.Len(t, prompts, 2) {
+				return
+			}
+			assert.True(t, strings.HasPrefix(prompts[1], prompts[0]) == !tt.startsOver)
+			// The instructions, the DOM and the earlier turns are cached, and the prompt is left untouched
+			for i, prompt := range prompts {
+				assert.Equal(t, domAnalysisInstructions, cachePrefixes[i][0])
+				assert.True(t, strings.HasSuffix(cachePrefixes[i][1], `",`))
+				for _, prefix := range cachePrefixes[i] {
+					assert.True(t, strings.HasPrefix(prompt, prefix))
+				}
+			}
+			assert.Equal(t, !tt.startsOver, slices.Contains(cachePrefixes[1], prompts[0]))
+			for _, expected := range tt.expectedTurn2 {
+				assert.Contains(t, prompts[1], expected)
+			}
+			for _, unexpected := range tt.notInTurn2 {
+				assert.NotContains(t, prompts[1], unexpected)
+			}
+			mockPlugin.AssertExpectations(t)
+			mockLLM.AssertExpectations(t)
+		})
+	}
+}
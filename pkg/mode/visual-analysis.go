@@ -10,6 +10,7 @@ import (
 	"strings"
 
 	"github.com/vertexcover-io/locatr/pkg/internal/constants"
+	"github.com/vertexcover-io/locatr/pkg/internal/imageproc"
 	"github.com/vertexcover-io/locatr/pkg/internal/splitters"
 	"github.com/vertexcover-io/locatr/pkg/internal/utils"
 	"github.com/vertexcover-io/locatr/pkg/logging"
@@ -19,7 +20,16 @@ import (
 // VISUAL_ANALYSIS_PROMPT_TEMPLATE defines the system prompt for identifying coordinates in screenshots.
 // The prompt guides the LLM to determine precise (X, Y) coordinates for UI elements in the given resolution
 // screenshot based on user requests and element types (buttons, text fields, etc.).
-const VISUAL_ANALYSIS_PROMPT_TEMPLATE string = `Your task is to identify the exact (X, Y) coordinates for a described element or area on a screenshot of a web page with a resolution of %d x %d.
+// The instructions come first and are declared as a prompt cache prefix, so that they are cached by providers
+// supporting prompt caching.
+const VISUAL_ANALYSIS_PROMPT_TEMPLATE string = visualAnalysisInstructionsTemplate + `
+User request: %s
+Be precise in your coordinate estimation as these will be used for automated interactions.
+`
+
+// visualAnalysisInstructionsTemplate are the instructions of the visual analysis prompt, given the resolution
+// of the screenshot.
+const visualAnalysisInstructionsTemplate string = `Your task is to identify the exact (X, Y) coordinates for a described element or area on a screenshot of a web page with a resolution of %d x %d.
 
 Analyze the screenshot and the user's request carefully to determine the appropriate coordinates. The coordinates should point to the center of the described element when possible.
 
@@ -33,18 +43,47 @@ If you cannot confidently determine the coordinates based on the provided inform
 Provide your response in valid JSON format with the following structure:
 {
     "element_point": "x, y",  // Comma-separated X and Y coordinates, or empty string if coordinates cannot be determined
-    "error": ""       // A descriptive error message if coordinates cannot be determined, otherwise an empty string
+    "error": "",      // A descriptive error message if coordinates cannot be determined, otherwise an empty string
+    "confidence": 0.0 // How confident you are that the point is on the described element, between 0 and 1
 }
-
-User request: %s
-Be precise in your coordinate estimation as these will be used for automated interactions.
 `
 
+// visualAnalysisOutputSchema is the JSON schema of the output of the visual analysis prompt.
+var visualAnalysisOutputSchema = &types.JSONSchema{
+	Name:        "visual_analysis_output",
+	Description: "The coordinates of the described element on the screenshot",
+	Schema: map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"element_point": map[string]any{
+				"type":        "string",
+				"description": "Comma-separated X and Y coordinates, or an empty string if coordinates cannot be determined",
+			},
+			"error": map[string]any{
+				"type":        "string",
+				"description": "A descriptive error message if coordinates cannot be determined, otherwise an empty string",
+			},
+			"confidence": map[string]any{
+				"type":        "number",
+				"description": "How confident you are that the point is on the described element, between 0 and 1",
+			},
+		},
+		"required":             []string{"element_point", "error", "confidence"},
+		"additionalProperties": false,
+	},
+}
+
 type VisualAnalysisMode struct {
 	// Resolution to use for viewport size, defaults to 1280x800
 	Resolution *types.Resolution `json:"resolution"`
 	// Maximum number of relevant screenshots to use for analysis. Defaults to constants.DEFAULT_TOP_N
 	MaxAttempts int `json:"max_attempts"`
+	// Preprocessing of the screenshots before they are sent to the LLM.
+	// Defaults to JPEG images scaled down to the optimal size for the provider of the LLM.
+	Image *types.ImageOptions `json:"image"`
+	// How a failure of the reranker is handled. Defaults to types.RerankerFailureFallback, ranking the chunks
+	// with a local heuristic
+	RerankerFailure types.RerankerFailurePolicy `json:"reranker_failure"`
 }
 
 const deviceScaleFactorWarning = "Device scale factor != 1.0 may affect viewport sizing and element location. Use '--force-device-scale-factor=1' when creating driver."
@@ -72,6 +111,11 @@ func (m *VisualAnalysisMode) ProcessRequest(
 
 	m.applyDefaults()
 
+	model := llmClient.GetModel()
+	if capabilities, ok := types.LookupClientCapabilities(llmClient); ok && !capabilities.Vision {
+		logger.Warn("model doesn't support images, visual analysis will likely fail", "model", model)
+	}
+
 	dom, err := plugin.GetMinifiedDOM(ctx)
 	if err != nil {
 		return err
@@ -80,11 +124,8 @@ func (m *VisualAnalysisMode) ProcessRequest(
 		dom.RootElement.Repr(), constants.HTML_SEPARATORS, constants.DEFAULT_CHUNK_SIZE,
 	)
 
-	results, err := rerankerClient.Rerank(
-		ctx,
-		&types.RerankRequest{
-			Query: request, Documents: domChunks, TopN: m.MaxAttempts,
-		},
+	results, err := rankChunks(
+		ctx, rerankerClient, m.RerankerFailure, request, domChunks, m.MaxAttempts, logger, completion,
 	)
 	if err != nil {
 		return err
@@ -126,16 +167,43 @@ func (m *VisualAnalysisMode) ProcessRequest(
 			continue
 		}
 
+		screenshot, err := imageproc.Process(screenshotBytes, types.ClientProviders(llmClient), m.Image)
+		if err != nil {
+			logger.Error("couldn't process screenshot", "error", err)
+			continue
+		}
+		// The model is asked for coordinates in the processed screenshot, which are remapped to the viewport
+		screenshotResolution := m.Resolution
+		if screenshot.Target != nil {
+			screenshotResolution = screenshot.Target
+		}
+		logger.Debug(
+			"Processed screenshot",
+			"media_type", screenshot.MediaType,
+			"resolution", fmt.Sprintf("%dx%d", screenshotResolution.Width, screenshotResolution.Height),
+			"bytes", len(screenshot.Image),
+		)
+
 		prompt := fmt.Sprintf(
 			VISUAL_ANALYSIS_PROMPT_TEMPLATE,
-			m.Resolution.Width,
-			m.Resolution.Height,
+			screenshotResolution.Width,
+			screenshotResolution.Height,
 			request,
 		)
 
-		jsonCompletion, err := llmClient.GetJSONCompletion(ctx, prompt, screenshotBytes)
-		completion.InputTokens += jsonCompletion.InputTokens
-		completion.OutputTokens += jsonCompletion.OutputTokens
+		instructions := fmt.Sprintf(
+			visualAnalysisInstructionsTemplate, screenshotResolution.Width, screenshotResolution.Height,
+		)
+		requestCtx := types.WithPromptCachePrefixes(ctx, instructions)
+		if m.Image != nil && m.Image.Detail != "" {
+			requestCtx = types.WithImageDetail(requestCtx, m.Image.Detail)
+		}
+		jsonCompletion, err := llmClient.GetStructuredCompletion(
+			requestCtx, prompt, screenshot.Image, visualAnalysisOutputSchema,
+		)
+		if jsonCompletion != nil {
+			completion.Accumulate(jsonCompletion.LLMCompletionMeta)
+		}
 		if err != nil {
 			logger.Error("couldn't get JSON completion", "error", err)
 			continue
@@ -173,11 +241,15 @@ func (m *VisualAnalysisMode) ProcessRequest(
 			continue
 		}
 
-		elementPoint := types.Point{X: xCoord, Y: yCoord}
+		elementPoint := screenshot.RemapPoint(&types.Point{X: xCoord, Y: yCoord}, m.Resolution)
+		if elementPoint == nil {
+			logger.Error("point is outside of the screenshot", "x", xCoord, "y", yCoord)
+			continue
+		}
 		locators, err := plugin.GetElementLocators(
 			ctx,
 			&types.Location{
-				Point:          elementPoint,
+				Point:          *elementPoint,
 				ScrollPosition: chunkLocation.ScrollPosition,
 			},
 		)
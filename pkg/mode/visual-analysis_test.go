@@ -52,7 +52,7 @@ func TestVisualAnalysisMode_ProcessRequest(t *testing.T) {
 				}, nil)
 
 				// Mock reranker response
-				mr.On("Rerank", ctx, mock.Anything).Return([]types.RerankResult{
+				mr.On("Rerank", mock.Anything, mock.Anything).Return([]types.RerankResult{
 					{Index: 0, Score: 0.9},
 				}, nil)
 
@@ -67,6 +67,7 @@ func TestVisualAnalysisMode_ProcessRequest(t *testing.T) {
 
 				// Mock screenshot
 				mp.On("TakeScreenshot", ctx).Return([]byte("mock-screenshot"), nil)
+				ml.On("GetProvider").Return("anthropic")
 
 				// Mock LLM response
 				jsonResponse := map[string]string{
@@ -74,7 +75,7 @@ func TestVisualAnalysisMode_ProcessRequest(t *testing.T) {
 					"error":         "",
 				}
 				jsonBytes, _ := json.Marshal(jsonResponse)
-				ml.On("GetJSONCompletion", ctx, mock.Anything, mock.Anything).Return(&types.JSONCompletion{
+				ml.On("GetStructuredCompletion", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(&types.JSONCompletion{
 					JSON: string(jsonBytes),
 					LLMCompletionMeta: types.LLMCompletionMeta{
 						InputTokens:  100,
@@ -116,7 +117,7 @@ func TestVisualAnalysisMode_ProcessRequest(t *testing.T) {
 				}, nil)
 
 				// Mock reranker
-				mr.On("Rerank", ctx, mock.Anything).Return([]types.RerankResult{
+				mr.On("Rerank", mock.Anything, mock.Anything).Return([]types.RerankResult{
 					{Index: 0, Score: 0.8},
 				}, nil)
 
@@ -126,13 +127,14 @@ func TestVisualAnalysisMode_ProcessRequest(t *testing.T) {
 					ScrollPosition: types.Point{X: 0, Y: 0},
 				}, nil)
 				mp.On("TakeScreenshot", ctx).Return([]byte("mock-screenshot"), nil)
+				ml.On("GetProvider").Return("anthropic")
 
 				jsonResponse := map[string]string{
 					"element_point": "invalid,coords",
 					"error":         "",
 				}
 				jsonBytes, _ := json.Marshal(jsonResponse)
-				ml.On("GetJSONCompletion", ctx, mock.Anything, mock.Anything).Return(&types.JSONCompletion{
+				ml.On("GetStructuredCompletion", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(&types.JSONCompletion{
 					JSON: string(jsonBytes),
 				}, nil)
 			},
@@ -146,6 +148,7 @@ func TestVisualAnalysisMode_ProcessRequest(t *testing.T) {
 			mockPlugin := new(MockPlugin)
 			mockLLM := new(MockLLMClient)
 			mockReranker := new(MockRerankerClient)
+			mockLLM.On("GetModel").Return("claude-3-5-sonnet-latest").Maybe()
 
 			// Setup mocks with context
 			tt.mockSetup(ctx, mockPlugin, mockLLM, mockReranker)
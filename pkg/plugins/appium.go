@@ -171,6 +171,28 @@ func (plugin *appiumPlugin) GetMinifiedDOM(ctx context.Context) (*types.DOM, err
 	return plugin.minifyXML(ctx)
 }
 
+// GetDOMVersion returns a version of the current DOM that changes whenever the DOM changes.
+// Web views are tracked by a MutationObserver, native views by a hash of the page source.
+func (plugin *appiumPlugin) GetDOMVersion(ctx context.Context) (string, error) {
+	if plugin.client.IsWebView(ctx) {
+		result, err := plugin.evaluateJSExpression(ctx, "getDOMVersion()")
+		if err != nil {
+			return "", fmt.Errorf("couldn't get DOM version: %v", err)
+		}
+		version, ok := result.(string)
+		if !ok {
+			return "", fmt.Errorf("unexpected type for DOM version result: %T", result)
+		}
+		return version, nil
+	}
+
+	pageSource, err := plugin.client.GetPageSource(ctx)
+	if err != nil {
+		return "", err
+	}
+	return utils.GenerateUniqueId(pageSource), nil
+}
+
 // ExtractFirstUniqueID extracts the first unique ID from the given fragment.
 func (plugin *appiumPlugin) ExtractFirstUniqueID(ctx context.Context, fragment string) (string, error) {
 	if plugin.client.IsWebView(ctx) {
@@ -0,0 +1,167 @@
+package plugins
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/vertexcover-io/locatr/pkg/internal/constants"
+	"github.com/vertexcover-io/locatr/pkg/types"
+)
+
+// cachedPluginConfig configures the snapshot cache of a cachedPlugin.
+type cachedPluginConfig struct {
+	ttl time.Duration
+}
+
+// CachedPluginOption is a function that configures the cachedPluginConfig.
+type CachedPluginOption func(*cachedPluginConfig)
+
+// WithSnapshotTTL sets the maximum age of a DOM snapshot before it is captured again,
+// even if no change was detected. Defaults to constants.DEFAULT_SNAPSHOT_TTL.
+func WithSnapshotTTL(ttl time.Duration) CachedPluginOption {
+	return func(c *cachedPluginConfig) {
+		c.ttl = ttl
+	}
+}
+
+// domSnapshot holds a minified DOM along with the state of the page it was captured from.
+type domSnapshot struct {
+	context    string
+	version    string
+	dom        *types.DOM
+	capturedAt time.Time
+}
+
+// cachedPlugin wraps a plugin and caches the minified DOM between calls.
+//
+// A snapshot is invalidated when:
+//   - the current context (URL or activity) changes
+//   - the DOM version changes, if the wrapped plugin implements types.DOMVersionProvider
+//   - the snapshot is older than the configured TTL
+//   - the viewport is resized
+type cachedPlugin struct {
+	plugin   types.PluginInterface
+	config   *cachedPluginConfig
+	mu       sync.Mutex
+	snapshot *domSnapshot
+}
+
+// NewCachedPlugin wraps the given plugin with a DOM snapshot cache.
+//
+// Parameters:
+//   - plugin: The plugin to wrap
+//   - opts: Configuration options for the snapshot cache
+//
+// Returns the wrapped plugin.
+func NewCachedPlugin(plugin types.PluginInterface, opts ...CachedPluginOption) *cachedPlugin {
+	cfg := &cachedPluginConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if cfg.ttl <= 0 {
+		cfg.ttl = constants.DEFAULT_SNAPSHOT_TTL
+	}
+	return &cachedPlugin{plugin: plugin, config: cfg}
+}
+
+// Invalidate discards the cached DOM snapshot.
+func (p *cachedPlugin) Invalidate() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.snapshot = nil
+}
+
+// currentState returns the current context and DOM version of the wrapped plugin.
+// The returned bool is false if the state couldn't be fully determined, in which case
+// a cached snapshot must not be served.
+func (p *cachedPlugin) currentState(ctx context.Context) (string, string, bool) {
+	currentContext, err := p.plugin.GetCurrentContext(ctx)
+	if err != nil || currentContext == nil {
+		return "", "", false
+	}
+	version := ""
+	if provider, ok := p.plugin.(types.DOMVersionProvider); ok {
+		if version, err = provider.GetDOMVersion(ctx); err != nil {
+			return *currentContext, "", false
+		}
+	}
+	return *currentContext, version, true
+}
+
+// isFresh reports whether the snapshot can still be served for the given page state.
+// Without a DOM version, only the context and the TTL are checked.
+func (s *domSnapshot) isFresh(currentContext, version string, ttl time.Duration) bool {
+	if s == nil || time.Since(s.capturedAt) > ttl {
+		return false
+	}
+	return s.context == currentContext && s.version == version
+}
+
+// GetCurrentContext retrieves the current context of the wrapped plugin.
+func (p *cachedPlugin) GetCurrentContext(ctx context.Context) (*string, error) {
+	return p.plugin.GetCurrentContext(ctx)
+}
+
+// GetDOMVersion returns the DOM version of the wrapped plugin, if it provides one.
+func (p *cachedPlugin) GetDOMVersion(ctx context.Context) (string, error) {
+	provider, ok := p.plugin.(types.DOMVersionProvider)
+	if !ok {
+		return "", errors.New("wrapped plugin doesn't provide DOM versions")
+	}
+	return provider.GetDOMVersion(ctx)
+}
+
+// GetMinifiedDOM returns the cached DOM snapshot if it's still fresh, otherwise captures a new one.
+func (p *cachedPlugin) GetMinifiedDOM(ctx context.Context) (*types.DOM, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	currentContext, version, ok := p.currentState(ctx)
+	if ok && p.snapshot.isFresh(currentContext, version, p.config.ttl) {
+		return p.snapshot.dom, nil
+	}
+
+	dom, err := p.plugin.GetMinifiedDOM(ctx)
+	if err != nil {
+		p.snapshot = nil
+		return nil, err
+	}
+	p.snapshot = &domSnapshot{
+		context: currentContext, version: version, dom: dom, capturedAt: time.Now(),
+	}
+	return dom, nil
+}
+
+// ExtractFirstUniqueID extracts the first unique ID from the given fragment.
+func (p *cachedPlugin) ExtractFirstUniqueID(ctx context.Context, fragment string) (string, error) {
+	return p.plugin.ExtractFirstUniqueID(ctx, fragment)
+}
+
+// IsLocatorValid verifies if the given locator is valid.
+func (p *cachedPlugin) IsLocatorValid(ctx context.Context, locator string) (bool, error) {
+	return p.plugin.IsLocatorValid(ctx, locator)
+}
+
+// SetViewportSize sets the size of the viewport and invalidates the snapshot,
+// since resizing can change which elements are visible.
+func (p *cachedPlugin) SetViewportSize(ctx context.Context, width, height int) error {
+	p.Invalidate()
+	return p.plugin.SetViewportSize(ctx, width, height)
+}
+
+// TakeScreenshot captures a screenshot of the current viewport.
+func (p *cachedPlugin) TakeScreenshot(ctx context.Context) ([]byte, error) {
+	return p.plugin.TakeScreenshot(ctx)
+}
+
+// GetElementLocators retrieves locators from a given point and scroll position on the page.
+func (p *cachedPlugin) GetElementLocators(ctx context.Context, location *types.Location) ([]string, error) {
+	return p.plugin.GetElementLocators(ctx, location)
+}
+
+// GetElementLocation retrieves the point and scroll position of the element identified by the given locator.
+func (p *cachedPlugin) GetElementLocation(ctx context.Context, locator string) (*types.Location, error) {
+	return p.plugin.GetElementLocation(ctx, locator)
+}
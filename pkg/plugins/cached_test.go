@@ -0,0 +1,90 @@
+package plugins
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vertexcover-io/locatr/pkg/types"
+)
+
+// fakePlugin is a minimal plugin whose context and DOM version can be changed by the tests.
+type fakePlugin struct {
+	types.PluginInterface
+	url          string
+	version      string
+	domCalls     int
+	withVersions bool
+}
+
+func (p *fakePlugin) GetCurrentContext(ctx context.Context) (*string, error) {
+	return &p.url, nil
+}
+
+func (p *fakePlugin) GetMinifiedDOM(ctx context.Context) (*types.DOM, error) {
+	p.domCalls++
+	return &types.DOM{RootElement: &types.ElementSpec{Id: p.url + p.version}}, nil
+}
+
+func (p *fakePlugin) SetViewportSize(ctx context.Context, width, height int) error {
+	return nil
+}
+
+// fakeVersionedPlugin additionally reports DOM versions.
+type fakeVersionedPlugin struct {
+	*fakePlugin
+}
+
+func (p *fakeVersionedPlugin) GetDOMVersion(ctx context.Context) (string, error) {
+	return p.version, nil
+}
+
+func TestCachedPlugin_GetMinifiedDOM(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("serves snapshot until the DOM version changes", func(t *testing.T) {
+		inner := &fakePlugin{url: "https://example.com", version: "a:1"}
+		plugin := NewCachedPlugin(&fakeVersionedPlugin{inner}, WithSnapshotTTL(time.Hour))
+
+		_, _ = plugin.GetMinifiedDOM(ctx)
+		_, _ = plugin.GetMinifiedDOM(ctx)
+		assert.Equal(t, 1, inner.domCalls)
+
+		inner.version = "a:2"
+		dom, err := plugin.GetMinifiedDOM(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, "https://example.coma:2", dom.RootElement.Id)
+		assert.Equal(t, 2, inner.domCalls)
+	})
+
+	t.Run("invalidates on context change", func(t *testing.T) {
+		inner := &fakePlugin{url: "https://example.com"}
+		plugin := NewCachedPlugin(inner, WithSnapshotTTL(time.Hour))
+
+		_, _ = plugin.GetMinifiedDOM(ctx)
+		inner.url = "https://example.com/next"
+		_, _ = plugin.GetMinifiedDOM(ctx)
+		assert.Equal(t, 2, inner.domCalls)
+	})
+
+	t.Run("invalidates after TTL", func(t *testing.T) {
+		inner := &fakePlugin{url: "https://example.com"}
+		plugin := NewCachedPlugin(inner, WithSnapshotTTL(time.Millisecond))
+
+		_, _ = plugin.GetMinifiedDOM(ctx)
+		time.Sleep(5 * time.Millisecond)
+		_, _ = plugin.GetMinifiedDOM(ctx)
+		assert.Equal(t, 2, inner.domCalls)
+	})
+
+	t.Run("invalidates on viewport resize", func(t *testing.T) {
+		inner := &fakePlugin{url: "https://example.com"}
+		plugin := NewCachedPlugin(inner, WithSnapshotTTL(time.Hour))
+
+		_, _ = plugin.GetMinifiedDOM(ctx)
+		assert.NoError(t, plugin.SetViewportSize(ctx, 1280, 800))
+		_, _ = plugin.GetMinifiedDOM(ctx)
+		assert.Equal(t, 2, inner.domCalls)
+	})
+}
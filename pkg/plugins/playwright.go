@@ -101,6 +101,20 @@ func (plugin *playwrightPlugin) GetMinifiedDOM(ctx context.Context) (*types.DOM,
 	return dom, nil
 }
 
+// GetDOMVersion returns a version of the current page's DOM that changes whenever the DOM is mutated
+// (tracked by a MutationObserver) or the page is reloaded.
+func (plugin *playwrightPlugin) GetDOMVersion(ctx context.Context) (string, error) {
+	result, err := plugin.evaluateExpression("getDOMVersion()")
+	if err != nil {
+		return "", fmt.Errorf("couldn't get DOM version: %v", err)
+	}
+	version, ok := result.(string)
+	if !ok {
+		return "", fmt.Errorf("unexpected type for DOM version result: %T", result)
+	}
+	return version, nil
+}
+
 // ExtractFirstUniqueID extracts the first unique ID from the given fragment.
 func (plugin *playwrightPlugin) ExtractFirstUniqueID(ctx context.Context, fragment string) (string, error) {
 	return utils.ExtractFirstUniqueHTMLID(fragment)
@@ -0,0 +1,127 @@
+package plugins
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/vertexcover-io/locatr/pkg/redact"
+	"github.com/vertexcover-io/locatr/pkg/types"
+)
+
+// redactedPlugin wraps a plugin and redacts the sensitive data of its minified DOM,
+// so that it doesn't reach the LLM and reranker providers.
+type redactedPlugin struct {
+	plugin    types.PluginInterface
+	redactor  *redact.Redactor
+	mu        sync.Mutex
+	originals redact.Originals // Values redacted from the last DOM fetched without a redaction scope
+}
+
+// redactionScopeKey is the context key of the redaction scope.
+type redactionScopeKey struct{}
+
+// redactionScope holds the values redacted from the last DOM fetched in a call.
+type redactionScope struct {
+	mu        sync.Mutex
+	originals redact.Originals
+}
+
+// WithRedactionScope returns a copy of the context keeping the values redacted from the DOMs fetched with it
+// apart, so that concurrent calls sharing a redacted plugin restore their own values (see NewRedactedPlugin).
+// Without a scope, the values of the last DOM fetched by the plugin are restored.
+//
+// Parameters:
+//   - ctx: The context of the call, e.g. of a Locate call
+//
+// Returns the context with a new redaction scope.
+func WithRedactionScope(ctx context.Context) context.Context {
+	return context.WithValue(ctx, redactionScopeKey{}, &redactionScope{})
+}
+
+// NewRedactedPlugin wraps the given plugin with a redaction stage applied to the minified DOM.
+// The element IDs and locators are kept, so that the located elements still resolve.
+//
+// Parameters:
+//   - plugin: The plugin to wrap
+//   - redactor: The redactor applied to the element tree
+//
+// Returns the wrapped plugin.
+func NewRedactedPlugin(plugin types.PluginInterface, redactor *redact.Redactor) *redactedPlugin {
+	return &redactedPlugin{plugin: plugin, redactor: redactor}
+}
+
+// GetCurrentContext retrieves the current context of the wrapped plugin.
+func (p *redactedPlugin) GetCurrentContext(ctx context.Context) (*string, error) {
+	return p.plugin.GetCurrentContext(ctx)
+}
+
+// GetDOMVersion returns the DOM version of the wrapped plugin, if it provides one.
+func (p *redactedPlugin) GetDOMVersion(ctx context.Context) (string, error) {
+	provider, ok := p.plugin.(types.DOMVersionProvider)
+	if !ok {
+		return "", errors.New("wrapped plugin doesn't provide DOM versions")
+	}
+	return provider.GetDOMVersion(ctx)
+}
+
+// GetMinifiedDOM returns a copy of the minified DOM of the wrapped plugin with a redacted element tree.
+// The redacted values are kept in the redaction scope of the context, if any, to restore them.
+func (p *redactedPlugin) GetMinifiedDOM(ctx context.Context) (*types.DOM, error) {
+	dom, err := p.plugin.GetMinifiedDOM(ctx)
+	if err != nil {
+		return nil, err
+	}
+	redacted, originals := p.redactor.RedactDOM(dom)
+	if scope, ok := ctx.Value(redactionScopeKey{}).(*redactionScope); ok {
+		scope.mu.Lock()
+		scope.originals = originals
+		scope.mu.Unlock()
+	} else {
+		p.mu.Lock()
+		p.originals = originals
+		p.mu.Unlock()
+	}
+	return redacted, nil
+}
+
+// ExtractFirstUniqueID extracts the first unique ID from the given fragment, after restoring the values
+// redacted from the last DOM fetched in the redaction scope of the context, if any.
+func (p *redactedPlugin) ExtractFirstUniqueID(ctx context.Context, fragment string) (string, error) {
+	var originals redact.Originals
+	if scope, ok := ctx.Value(redactionScopeKey{}).(*redactionScope); ok {
+		scope.mu.Lock()
+		originals = scope.originals
+		scope.mu.Unlock()
+	} else {
+		p.mu.Lock()
+		originals = p.originals
+		p.mu.Unlock()
+	}
+	return p.plugin.ExtractFirstUniqueID(ctx, originals.Restore(fragment))
+}
+
+// IsLocatorValid verifies if the given locator is valid.
+func (p *redactedPlugin) IsLocatorValid(ctx context.Context, locator string) (bool, error) {
+	return p.plugin.IsLocatorValid(ctx, locator)
+}
+
+// SetViewportSize sets the size of the viewport.
+func (p *redactedPlugin) SetViewportSize(ctx context.Context, width, height int) error {
+	return p.plugin.SetViewportSize(ctx, width, height)
+}
+
+// TakeScreenshot captures a screenshot of the current viewport. Screenshots aren't redacted.
+func (p *redactedPlugin) TakeScreenshot(ctx context.Context) ([]byte, error) {
+	return p.plugin.TakeScreenshot(ctx)
+}
+
+// GetElementLocators retrieves locators from a given point and scroll position on the page.
+func (p *redactedPlugin) GetElementLocators(ctx context.Context, location *types.Location) ([]string, error) {
+	return p.plugin.GetElementLocators(ctx, location)
+}
+
+// GetElementLocation retrieves the point and scroll position of the element identified by the given locator.
+func (p *redactedPlugin) GetElementLocation(ctx context.Context, locator string) (*types.Location, error) {
+	return p.plugin.GetElementLocation(ctx, locator)
+}
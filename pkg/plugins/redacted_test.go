@@ -0,0 +1,85 @@
+package plugins
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vertexcover-io/locatr/pkg/internal/utils"
+	"github.com/vertexcover-io/locatr/pkg/redact"
+	"github.com/vertexcover-io/locatr/pkg/types"
+)
+
+// fakeFormPlugin returns a form with personal data and extracts IDs from fragments like the browser plugins.
+// The email of the form is taken from the context if set, to tell pages apart.
+type fakeFormPlugin struct {
+	types.PluginInterface
+	mu        sync.Mutex
+	fragments []string
+}
+
+// emailKey is the context key of the email of the form.
+type emailKey struct{}
+
+func (p *fakeFormPlugin) GetMinifiedDOM(ctx context.Context) (*types.DOM, error) {
+	email, ok := ctx.Value(emailKey{}).(string)
+	if !ok {
+		email = "jane@example.com"
+	}
+	return &types.DOM{
+		RootElement: &types.ElementSpec{
+			Id: "1", TagName: "div",
+			Children: []types.ElementSpec{{Id: "2", TagName: "span", Text: email}},
+		},
+		Metadata: &types.DOMMetadata{LocatorMap: map[string][]string{"2": {"#email"}}},
+	}, nil
+}
+
+func (p *fakeFormPlugin) ExtractFirstUniqueID(ctx context.Context, fragment string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.fragments = append(p.fragments, fragment)
+	return utils.ExtractFirstUniqueHTMLID(fragment)
+}
+
+func TestRedactedPlugin(t *testing.T) {
+	ctx := context.Background()
+	redactor, err := redact.NewRedactor()
+	assert.NoError(t, err)
+	inner := &fakeFormPlugin{}
+	plugin := NewRedactedPlugin(inner, redactor)
+
+	dom, err := plugin.GetMinifiedDOM(ctx)
+	assert.NoError(t, err)
+	repr := dom.RootElement.Repr()
+	assert.NotContains(t, repr, "jane@example.com")
+	assert.Equal(t, []string{"#email"}, dom.Metadata.LocatorMap["2"])
+
+	id, err := plugin.ExtractFirstUniqueID(ctx, repr)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", id)
+	assert.Contains(t, inner.fragments[0], "jane@example.com")
+}
+
+func TestRedactedPlugin_RedactionScope(t *testing.T) {
+	redactor, err := redact.NewRedactor()
+	assert.NoError(t, err)
+	inner := &fakeFormPlugin{}
+	plugin := NewRedactedPlugin(inner, redactor)
+
+	// Two calls fetch their DOM before either extracts an ID, like concurrent Locate calls
+	first := WithRedactionScope(context.WithValue(context.Background(), emailKey{}, "jane@example.com"))
+	second := WithRedactionScope(context.WithValue(context.Background(), emailKey{}, "john@example.com"))
+	firstDOM, err := plugin.GetMinifiedDOM(first)
+	assert.NoError(t, err)
+	secondDOM, err := plugin.GetMinifiedDOM(second)
+	assert.NoError(t, err)
+
+	_, err = plugin.ExtractFirstUniqueID(first, firstDOM.RootElement.Repr())
+	assert.NoError(t, err)
+	_, err = plugin.ExtractFirstUniqueID(second, secondDOM.RootElement.Repr())
+	assert.NoError(t, err)
+	assert.Contains(t, inner.fragments[0], "jane@example.com")
+	assert.Contains(t, inner.fragments[1], "john@example.com")
+}
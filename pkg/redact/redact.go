@@ -0,0 +1,324 @@
+// Package redact replaces personal data and secrets in the minified DOM with placeholders,
+// before it is sent to the LLM and reranker providers.
+package redact
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/vertexcover-io/locatr/pkg/types"
+)
+
+// Detector finds a kind of sensitive data in text.
+type Detector struct {
+	// Name of the detected data, used in the placeholders, e.g. EMAIL
+	Name string
+	// Pattern matching the data
+	Pattern *regexp.Regexp
+	// Validate filters the matches of the pattern, e.g. with a checksum. Optional
+	Validate func(match string) bool
+}
+
+// Built-in detectors.
+var (
+	// Email detects email addresses.
+	Email = Detector{
+		Name:    "EMAIL",
+		Pattern: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`),
+	}
+	// Phone detects international phone numbers starting with a + and North American phone numbers.
+	Phone = Detector{
+		Name: "PHONE",
+		Pattern: regexp.MustCompile(
+			`\+\d{1,3}[ .-]?\(?\d{1,4}\)?(?:[ .-]?\d{2,4}){2,4}|\(\d{3}\)[ .-]?\d{3}[ .-]?\d{4}|\b\d{3}[.-]\d{3}[.-]\d{4}\b`,
+		),
+		Validate: func(match string) bool { n := countDigits(match); return n >= 7 && n <= 15 },
+	}
+	// CreditCard detects payment card numbers passing the Luhn checksum.
+	CreditCard = Detector{
+		Name:     "CARD",
+		Pattern:  regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`),
+		Validate: luhnValid,
+	}
+	// JWT detects JSON web tokens.
+	JWT = Detector{
+		Name:    "JWT",
+		Pattern: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`),
+	}
+)
+
+// DefaultDetectors returns the built-in detectors, in the order they are applied.
+// Card numbers are detected before phone numbers, which would match their digit groups.
+func DefaultDetectors() []Detector {
+	return []Detector{JWT, Email, CreditCard, Phone}
+}
+
+// placeholderPattern matches the placeholders of the redacted values.
+var placeholderPattern = regexp.MustCompile(`\[[A-Z0-9_]+_[0-9a-f]{12}\]`)
+
+type config struct {
+	detectors  []Detector
+	attributes map[string]bool
+	key        []byte
+	// randomKey is set when no key is given, so that placeholders change with every redactor
+	randomKey bool
+}
+
+type Option func(*config)
+
+// WithDetectors replaces the built-in detectors with the given ones.
+func WithDetectors(detectors ...Detector) Option {
+	return func(c *config) {
+		c.detectors = slices.Clone(detectors)
+	}
+}
+
+// WithPattern adds a detector of the data matching the given pattern, e.g. order numbers or API keys.
+//
+// Parameters:
+//   - name: Name of the data, used in the placeholders
+//   - pattern: Pattern matching the data
+func WithPattern(name string, pattern *regexp.Regexp) Option {
+	return func(c *config) {
+		c.detectors = append(c.detectors, Detector{Name: name, Pattern: pattern})
+	}
+}
+
+// WithAttributes redacts the whole value of the given attributes, e.g. "value" to hide what was typed in inputs.
+func WithAttributes(names ...string) Option {
+	return func(c *config) {
+		for _, name := range names {
+			c.attributes[name] = true
+		}
+	}
+}
+
+// WithKey sets the key the placeholders are derived from. Redactors with the same key and rules
+// replace a value with the same placeholder, e.g. to keep cached fingerprints stable across processes.
+// Defaults to a random key, which changes the placeholders of every redactor.
+func WithKey(key []byte) Option {
+	return func(c *config) {
+		c.key = key
+	}
+}
+
+// Redactor replaces sensitive data with placeholders and restores them.
+// A value is always replaced with the same placeholder, e.g. [EMAIL_3f9a0c1b2d4e], derived from a keyed hash
+// so that the value can't be guessed from it. Element IDs are never redacted, so that locators still resolve.
+//
+// The values redacted from a DOM are returned along with it instead of being kept by the redactor, so that
+// the secrets of earlier pages aren't retained and concurrent calls don't restore each other's values.
+type Redactor struct {
+	config    *config
+	mu        sync.RWMutex
+	originals Originals // Original values of the texts redacted with Redact
+}
+
+// Originals are the values replaced with placeholders, by placeholder.
+type Originals map[string]string
+
+// Restore replaces the placeholders in the text with the values they redacted.
+// Unknown placeholders, e.g. of other redactors or DOMs, are kept as is.
+func (o Originals) Restore(text string) string {
+	return placeholderPattern.ReplaceAllStringFunc(text, func(placeholder string) string {
+		if original, ok := o[placeholder]; ok {
+			return original
+		}
+		return placeholder
+	})
+}
+
+// NewRedactor creates a new redactor with the built-in detectors and the given rules.
+//
+// Parameters:
+//   - opts: Configuration options for the redactor
+//
+// Returns:
+//   - *Redactor: Configured redactor
+//   - error: If a random key can't be generated
+func NewRedactor(opts ...Option) (*Redactor, error) {
+	cfg := &config{detectors: DefaultDetectors(), attributes: map[string]bool{}}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if len(cfg.key) == 0 {
+		cfg.randomKey = true
+		cfg.key = make([]byte, 32)
+		if _, err := rand.Read(cfg.key); err != nil {
+			return nil, fmt.Errorf("couldn't generate redaction key: %w", err)
+		}
+	}
+	for i, detector := range cfg.detectors {
+		if detector.Pattern == nil {
+			return nil, fmt.Errorf("pattern of detector %q is required", detector.Name)
+		}
+		cfg.detectors[i].Name = placeholderName(detector.Name)
+	}
+	return &Redactor{config: cfg, originals: Originals{}}, nil
+}
+
+// HasRandomKey reports whether the placeholders are derived from a random key, because no key was set
+// with WithKey. Such placeholders change with every redactor, e.g. on every restart.
+func (r *Redactor) HasRandomKey() bool {
+	return r.config.randomKey
+}
+
+// Redact replaces the sensitive data found by the detectors in the text with placeholders.
+// The values are kept by the redactor to restore them with Restore.
+func (r *Redactor) Redact(text string) string {
+	originals := Originals{}
+	text = r.redact(text, originals)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	maps.Copy(r.originals, originals)
+	return text
+}
+
+// Restore replaces the placeholders of the texts redacted with Redact with the values they redacted.
+// Placeholders of other redactors, or of the redacted DOMs (see RedactDOM), are kept as is.
+func (r *Redactor) Restore(text string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.originals.Restore(text)
+}
+
+// RedactElement returns a redacted copy of the element and its children.
+// The text and attribute values are redacted, the IDs and tag names are kept.
+//
+// Parameters:
+//   - element: The element to redact
+//
+// Returns:
+//   - *types.ElementSpec: The redacted copy of the element
+//   - Originals: The values redacted from the element, to restore them
+func (r *Redactor) RedactElement(element *types.ElementSpec) (*types.ElementSpec, Originals) {
+	originals := Originals{}
+	return r.redactElement(element, originals), originals
+}
+
+// RedactDOM returns a copy of the DOM with a redacted element tree. The metadata is shared with the original DOM.
+//
+// Parameters:
+//   - dom: The DOM to redact
+//
+// Returns:
+//   - *types.DOM: The redacted copy of the DOM
+//   - Originals: The values redacted from the DOM, to restore them
+func (r *Redactor) RedactDOM(dom *types.DOM) (*types.DOM, Originals) {
+	redacted := &types.DOM{Metadata: dom.Metadata}
+	if dom.RootElement == nil {
+		return redacted, Originals{}
+	}
+	root, originals := r.RedactElement(dom.RootElement)
+	redacted.RootElement = root
+	return redacted, originals
+}
+
+// redact replaces the sensitive data found by the detectors in the text with placeholders,
+// adding the values to the originals.
+func (r *Redactor) redact(text string, originals Originals) string {
+	for _, detector := range r.config.detectors {
+		text = detector.Pattern.ReplaceAllStringFunc(text, func(match string) string {
+			if placeholderPattern.MatchString(match) || (detector.Validate != nil && !detector.Validate(match)) {
+				return match
+			}
+			return r.placeholder(detector.Name, match, originals)
+		})
+	}
+	return text
+}
+
+// redactElement returns a redacted copy of the element and its children, adding the values to the originals.
+func (r *Redactor) redactElement(element *types.ElementSpec, originals Originals) *types.ElementSpec {
+	redacted := &types.ElementSpec{
+		Id:         element.Id,
+		TagName:    element.TagName,
+		Text:       r.redact(element.Text, originals),
+		Attributes: maps.Clone(element.Attributes),
+	}
+	for name, value := range redacted.Attributes {
+		if r.config.attributes[name] && value != "" {
+			redacted.Attributes[name] = r.placeholder("ATTRIBUTE", value, originals)
+		} else {
+			redacted.Attributes[name] = r.redact(value, originals)
+		}
+	}
+	if element.Children != nil {
+		redacted.Children = make([]types.ElementSpec, len(element.Children))
+		for i := range element.Children {
+			redacted.Children[i] = *r.redactElement(&element.Children[i], originals)
+		}
+	}
+	return redacted
+}
+
+// placeholder returns the placeholder of the value and adds the value to the originals to restore it.
+func (r *Redactor) placeholder(name, value string, originals Originals) string {
+	mac := hmac.New(sha256.New, r.config.key)
+	mac.Write([]byte(name))
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+	placeholder := fmt.Sprintf("[%s_%s]", name, hex.EncodeToString(mac.Sum(nil))[:12])
+	originals[placeholder] = value
+	return placeholder
+}
+
+// placeholderName turns the name of a detector into the name used in its placeholders, e.g. "api key" into API_KEY.
+func placeholderName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return unicode.ToUpper(r)
+		case (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9'):
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+	if name == "" {
+		return "REDACTED"
+	}
+	return name
+}
+
+// countDigits returns the number of digits in the text.
+func countDigits(text string) int {
+	count := 0
+	for _, r := range text {
+		if r >= '0' && r <= '9' {
+			count++
+		}
+	}
+	return count
+}
+
+// luhnValid reports whether the digits of the text pass the Luhn checksum of payment card numbers.
+func luhnValid(text string) bool {
+	sum, double := 0, false
+	for i := len(text) - 1; i >= 0; i-- {
+		c := text[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		digit := int(c - '0')
+		if double {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		double = !double
+	}
+	return sum%10 == 0
+}
@@ -0,0 +1,264 @@
+// Package replay provides LLM and reranker clients that record their interactions to a cassette file
+// and replay them later, for deterministic tests that don't call paid APIs.
+package replay
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/vertexcover-io/locatr/pkg/logging"
+)
+
+// Mode defines whether a cassette records or replays interactions.
+type Mode string
+
+const (
+	// ModeRecord forwards requests to the wrapped clients and records the responses, replacing the cassette.
+	ModeRecord Mode = "record"
+	// ModeReplay serves the recorded responses and fails on requests that weren't recorded.
+	ModeReplay Mode = "replay"
+)
+
+// cassetteVersion is the version of the cassette file format.
+const cassetteVersion = 1
+
+// ErrInteractionNotFound is returned in replay mode for a request that isn't in the cassette.
+var ErrInteractionNotFound = errors.New("interaction not found in cassette")
+
+// Redactor rewrites a recorded text, e.g. to mask secrets or personal data.
+// It must be deterministic, since request keys are computed from the redacted text.
+type Redactor func(text string) string
+
+// RedactRegexp returns a redactor replacing all the matches of the given expression with the replacement.
+//
+// Parameters:
+//   - re: The expression to match
+//   - replacement: The replacement, which can reference submatches like regexp.ReplaceAllString
+//
+// Returns the redactor.
+func RedactRegexp(re *regexp.Regexp, replacement string) Redactor {
+	return func(text string) string {
+		return re.ReplaceAllString(text, replacement)
+	}
+}
+
+// Option configures a cassette.
+type Option func(*Cassette)
+
+// WithRedactors sets the redactors applied, in order, to everything written to the cassette:
+// prompts, rerank queries and documents, and responses.
+func WithRedactors(redactors ...Redactor) Option {
+	return func(c *Cassette) {
+		c.redactors = append(c.redactors, redactors...)
+	}
+}
+
+// WithLogger sets the logger used to report requests missing from the cassette.
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *Cassette) {
+		c.logger = logger
+	}
+}
+
+// llmRequest is the recorded request of a completion.
+type llmRequest struct {
+	Prompt      []string `json:"prompt"`                 // Lines of the redacted prompt
+	ImageSHA256 string   `json:"image_sha256,omitempty"` // Hash of the image, if any
+	Schema      string   `json:"schema,omitempty"`       // Name of the schema of a structured completion
+}
+
+// llmResponse is the recorded response of a completion.
+type llmResponse struct {
+	JSON         json.RawMessage `json:"json"`
+	Provider     string          `json:"provider"`
+	Model        string          `json:"model"`
+	InputTokens  int             `json:"input_tokens"`
+	OutputTokens int             `json:"output_tokens"`
+}
+
+// rerankRequest is the recorded request of a rerank.
+type rerankRequest struct {
+	Query     string   `json:"query"`
+	Documents []string `json:"documents"`
+	TopN      int      `json:"top_n"`
+}
+
+// rerankResult is a recorded result of a rerank.
+type rerankResult struct {
+	Index int     `json:"index"`
+	Score float64 `json:"score"`
+}
+
+// interaction is a recorded request and its response.
+type interaction struct {
+	Key            string         `json:"key"`
+	LLMRequest     *llmRequest    `json:"llm_request,omitempty"`
+	LLMResponse    *llmResponse   `json:"llm_response,omitempty"`
+	RerankRequest  *rerankRequest `json:"rerank_request,omitempty"`
+	RerankResponse []rerankResult `json:"rerank_response,omitempty"`
+}
+
+// cassetteFile is the content of a cassette file.
+type cassetteFile struct {
+	Version      int            `json:"version"`
+	Interactions []*interaction `json:"interactions"`
+}
+
+// Cassette stores the interactions of the LLM and reranker clients wrapped with NewLLMClient and NewRerankerClient.
+// A single cassette can be shared by both clients.
+//
+// The cassette file is indented JSON with the interactions sorted by key and the prompts split into lines,
+// so that re-recording a cassette produces a minimal diff.
+type Cassette struct {
+	path         string
+	mode         Mode
+	redactors    []Redactor
+	logger       *slog.Logger
+	mu           sync.Mutex
+	interactions map[string]*interaction
+}
+
+// NewCassette creates a cassette backed by the given file.
+// In replay mode the file must exist, in record mode it is replaced as interactions are recorded.
+//
+// Parameters:
+//   - path: Path of the cassette file
+//   - mode: Whether to record or replay interactions
+//   - opts: Configuration options for the cassette
+//
+// Returns:
+//   - *Cassette: The cassette
+//   - error: If the cassette file can't be read in replay mode
+func NewCassette(path string, mode Mode, opts ...Option) (*Cassette, error) {
+	cassette := &Cassette{path: path, mode: mode, interactions: map[string]*interaction{}}
+	for _, opt := range opts {
+		opt(cassette)
+	}
+	if cassette.logger == nil {
+		cassette.logger = logging.DefaultLogger
+	}
+
+	switch mode {
+	case ModeRecord:
+		return cassette, nil
+	case ModeReplay:
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read cassette: %w", err)
+		}
+		var file cassetteFile
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("couldn't decode cassette %v: %w", path, err)
+		}
+		if file.Version != cassetteVersion {
+			return nil, fmt.Errorf("unsupported cassette version: %v", file.Version)
+		}
+		for _, recorded := range file.Interactions {
+			cassette.interactions[recorded.Key] = recorded
+		}
+		return cassette, nil
+	default:
+		return nil, fmt.Errorf("invalid cassette mode: %q", mode)
+	}
+}
+
+// Mode returns the mode of the cassette.
+func (c *Cassette) Mode() Mode {
+	return c.mode
+}
+
+// redact applies the redactors to the given text.
+func (c *Cassette) redact(text string) string {
+	for _, redactor := range c.redactors {
+		text = redactor(text)
+	}
+	return text
+}
+
+// lookup returns the recorded interaction with the given key.
+func (c *Cassette) lookup(key string, description string) (*interaction, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	recorded, ok := c.interactions[key]
+	if !ok {
+		c.logger.Error("request not found in cassette", "cassette", c.path, "request", description, "key", key)
+		return nil, fmt.Errorf(
+			"%w: %v (key %v), re-record the cassette %v", ErrInteractionNotFound, description, key, c.path,
+		)
+	}
+	return recorded, nil
+}
+
+// record stores the interaction and writes the cassette file.
+func (c *Cassette) record(recorded *interaction) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.interactions[recorded.Key] = recorded
+	return c.save()
+}
+
+// save writes the cassette file atomically. The caller must hold the lock.
+func (c *Cassette) save() error {
+	file := cassetteFile{Version: cassetteVersion, Interactions: []*interaction{}}
+	for _, recorded := range c.interactions {
+		file.Interactions = append(file.Interactions, recorded)
+	}
+	sort.Slice(file.Interactions, func(i, j int) bool {
+		return file.Interactions[i].Key < file.Interactions[j].Key
+	})
+
+	buf := new(bytes.Buffer)
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(file); err != nil {
+		return fmt.Errorf("couldn't encode cassette: %w", err)
+	}
+
+	dir := filepath.Dir(c.path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("couldn't create cassette directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(c.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("couldn't write cassette: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("couldn't write cassette: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("couldn't write cassette: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), c.path); err != nil {
+		return fmt.Errorf("couldn't write cassette: %w", err)
+	}
+	return nil
+}
+
+// hashKey returns the hex encoded SHA-256 of the given parts.
+func hashKey(parts ...string) string {
+	hash := sha256.New()
+	for _, part := range parts {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// splitLines splits a text into lines, so that it diffs line by line in the cassette.
+func splitLines(text string) []string {
+	return strings.Split(text, "\n")
+}
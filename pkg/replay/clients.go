@@ -0,0 +1,238 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/vertexcover-io/locatr/pkg/types"
+)
+
+// llmClient records or replays the completions of a wrapped LLM client.
+type llmClient struct {
+	inner    types.LLMClientInterface
+	cassette *Cassette
+}
+
+// NewLLMClient wraps an LLM client so that its completions are recorded to or replayed from the cassette.
+// In replay mode the wrapped client is never called, it only provides the provider and model.
+//
+// Parameters:
+//   - inner: The LLM client to record
+//   - cassette: The cassette storing the completions
+//
+// Returns:
+//   - types.LLMClientInterface: The recording or replaying LLM client
+//   - error: If the client or the cassette is missing
+func NewLLMClient(inner types.LLMClientInterface, cassette *Cassette) (types.LLMClientInterface, error) {
+	if inner == nil {
+		return nil, errors.New("llm client is required")
+	}
+	if cassette == nil {
+		return nil, errors.New("cassette is required")
+	}
+	return &llmClient{inner: inner, cassette: cassette}, nil
+}
+
+// GetProvider returns the provider of the wrapped client.
+func (c *llmClient) GetProvider() types.LLMProvider {
+	return c.inner.GetProvider()
+}
+
+// GetModel returns the model of the wrapped client.
+func (c *llmClient) GetModel() string {
+	return c.inner.GetModel()
+}
+
+// GetJSONCompletion records or replays the JSON completion for the given prompt.
+func (c *llmClient) GetJSONCompletion(ctx context.Context, prompt string, image []byte) (*types.JSONCompletion, error) {
+	return c.complete(ctx, prompt, image, nil)
+}
+
+// GetStructuredCompletion records or replays the structured completion for the given prompt.
+func (c *llmClient) GetStructuredCompletion(
+	ctx context.Context, prompt string, image []byte, schema *types.JSONSchema,
+) (*types.JSONCompletion, error) {
+	if schema == nil {
+		return nil, errors.New("schema is required for structured completion")
+	}
+	return c.complete(ctx, prompt, image, schema)
+}
+
+// complete looks up the completion in the cassette, or requests and records it in record mode.
+func (c *llmClient) complete(
+	ctx context.Context, prompt string, image []byte, schema *types.JSONSchema,
+) (*types.JSONCompletion, error) {
+	request := &llmRequest{Prompt: splitLines(c.cassette.redact(prompt))}
+	if image != nil {
+		sum := sha256.Sum256(image)
+		request.ImageSHA256 = hex.EncodeToString(sum[:])
+	}
+	if schema != nil {
+		request.Schema = schema.Name
+	}
+	key := hashKey("llm", strings.Join(request.Prompt, "\n"), request.ImageSHA256, request.Schema)
+
+	if c.cassette.mode == ModeReplay {
+		recorded, err := c.cassette.lookup(key, fmt.Sprintf("completion for prompt %q", truncate(request.Prompt[0])))
+		if err != nil {
+			return nil, err
+		}
+		if recorded.LLMResponse == nil {
+			return nil, fmt.Errorf("interaction %v is not a completion", key)
+		}
+		return recorded.LLMResponse.completion()
+	}
+
+	var completion *types.JSONCompletion
+	var err error
+	if schema != nil {
+		completion, err = c.inner.GetStructuredCompletion(ctx, prompt, image, schema)
+	} else {
+		completion, err = c.inner.GetJSONCompletion(ctx, prompt, image)
+	}
+	if err != nil {
+		// Errors are not recorded, so that a transient failure doesn't end up in the cassette
+		return completion, err
+	}
+
+	response := &llmResponse{
+		JSON:         encodeJSON(c.cassette.redact(completion.JSON)),
+		Provider:     string(completion.Provider),
+		Model:        completion.Model,
+		InputTokens:  completion.InputTokens,
+		OutputTokens: completion.OutputTokens,
+	}
+	if err := c.cassette.record(&interaction{Key: key, LLMRequest: request, LLMResponse: response}); err != nil {
+		return completion, err
+	}
+	return completion, nil
+}
+
+// completion returns the recorded completion.
+func (r *llmResponse) completion() (*types.JSONCompletion, error) {
+	jsonStr, err := decodeJSON(r.JSON)
+	if err != nil {
+		return nil, err
+	}
+	return &types.JSONCompletion{
+		JSON: jsonStr,
+		LLMCompletionMeta: types.LLMCompletionMeta{
+			InputTokens:  r.InputTokens,
+			OutputTokens: r.OutputTokens,
+			Provider:     types.LLMProvider(r.Provider),
+			Model:        r.Model,
+		},
+	}, nil
+}
+
+// encodeJSON returns the JSON of a completion as is, so that it is indented with the cassette,
+// or as a string if a redactor made it invalid.
+func encodeJSON(jsonStr string) json.RawMessage {
+	if json.Valid([]byte(jsonStr)) && !strings.HasPrefix(strings.TrimSpace(jsonStr), `"`) {
+		return json.RawMessage(jsonStr)
+	}
+	encoded, _ := json.Marshal(jsonStr)
+	return encoded
+}
+
+// decodeJSON reverses encodeJSON.
+func decodeJSON(raw json.RawMessage) (string, error) {
+	if bytes.HasPrefix(raw, []byte(`"`)) {
+		var jsonStr string
+		if err := json.Unmarshal(raw, &jsonStr); err != nil {
+			return "", fmt.Errorf("couldn't decode recorded completion: %w", err)
+		}
+		return jsonStr, nil
+	}
+	compacted := new(bytes.Buffer)
+	if err := json.Compact(compacted, raw); err != nil {
+		return "", fmt.Errorf("couldn't decode recorded completion: %w", err)
+	}
+	return compacted.String(), nil
+}
+
+// rerankerClient records or replays the results of a wrapped reranker client.
+type rerankerClient struct {
+	inner    types.RerankerClientInterface
+	cassette *Cassette
+}
+
+// NewRerankerClient wraps a reranker client so that its results are recorded to or replayed from the cassette.
+// In replay mode the wrapped client is never called.
+//
+// Parameters:
+//   - inner: The reranker client to record
+//   - cassette: The cassette storing the results
+//
+// Returns:
+//   - types.RerankerClientInterface: The recording or replaying reranker client
+//   - error: If the client or the cassette is missing
+func NewRerankerClient(inner types.RerankerClientInterface, cassette *Cassette) (types.RerankerClientInterface, error) {
+	if inner == nil {
+		return nil, errors.New("reranker client is required")
+	}
+	if cassette == nil {
+		return nil, errors.New("cassette is required")
+	}
+	return &rerankerClient{inner: inner, cassette: cassette}, nil
+}
+
+// Rerank looks up the results in the cassette, or requests and records them in record mode.
+func (c *rerankerClient) Rerank(ctx context.Context, request *types.RerankRequest) ([]types.RerankResult, error) {
+	recordedRequest := &rerankRequest{
+		Query:     c.cassette.redact(request.Query),
+		Documents: make([]string, len(request.Documents)),
+		TopN:      request.TopN,
+	}
+	for i, document := range request.Documents {
+		recordedRequest.Documents[i] = c.cassette.redact(document)
+	}
+	key := hashKey(append(
+		[]string{"rerank", recordedRequest.Query, strconv.Itoa(recordedRequest.TopN)}, recordedRequest.Documents...,
+	)...)
+
+	if c.cassette.mode == ModeReplay {
+		recorded, err := c.cassette.lookup(key, fmt.Sprintf("rerank for query %q", truncate(recordedRequest.Query)))
+		if err != nil {
+			return nil, err
+		}
+		if recorded.RerankRequest == nil {
+			return nil, fmt.Errorf("interaction %v is not a rerank", key)
+		}
+		results := make([]types.RerankResult, len(recorded.RerankResponse))
+		for i, result := range recorded.RerankResponse {
+			results[i] = types.RerankResult{Index: result.Index, Score: result.Score}
+		}
+		return results, nil
+	}
+
+	results, err := c.inner.Rerank(ctx, request)
+	if err != nil {
+		return results, err
+	}
+	response := make([]rerankResult, len(results))
+	for i, result := range results {
+		response[i] = rerankResult{Index: result.Index, Score: result.Score}
+	}
+	recorded := &interaction{Key: key, RerankRequest: recordedRequest, RerankResponse: response}
+	if err := c.cassette.record(recorded); err != nil {
+		return results, err
+	}
+	return results, nil
+}
+
+// truncate shortens a text for error messages.
+func truncate(text string) string {
+	const maxLength = 80
+	if len(text) <= maxLength {
+		return text
+	}
+	return text[:maxLength] + "..."
+}
@@ -0,0 +1,185 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vertexcover-io/locatr/pkg/types"
+)
+
+// stubLLMClient answers every prompt with a fixed completion, or fails if it's not expected to be called.
+type stubLLMClient struct {
+	t      *testing.T
+	json   string
+	forbid bool
+}
+
+func (c *stubLLMClient) GetProvider() types.LLMProvider { return "openai" }
+
+func (c *stubLLMClient) GetModel() string { return "gpt-4o" }
+
+func (c *stubLLMClient) GetJSONCompletion(ctx context.Context, prompt string, image []byte) (*types.JSONCompletion, error) {
+	return c.GetStructuredCompletion(ctx, prompt, image, nil)
+}
+
+func (c *stubLLMClient) GetStructuredCompletion(
+	ctx context.Context, prompt string, image []byte, schema *types.JSONSchema,
+) (*types.JSONCompletion, error) {
+	if c.forbid {
+		c.t.Fatalf("unexpected completion request for %q", prompt)
+	}
+	return &types.JSONCompletion{
+		JSON: c.json,
+		LLMCompletionMeta: types.LLMCompletionMeta{
+			InputTokens: 100, OutputTokens: 10, Provider: "openai", Model: "gpt-4o",
+		},
+	}, nil
+}
+
+// stubRerankerClient returns the documents in reverse order.
+type stubRerankerClient struct {
+	forbid bool
+	t      *testing.T
+}
+
+func (c *stubRerankerClient) Rerank(ctx context.Context, request *types.RerankRequest) ([]types.RerankResult, error) {
+	if c.forbid {
+		c.t.Fatalf("unexpected rerank request for %q", request.Query)
+	}
+	results := []types.RerankResult{}
+	for i := len(request.Documents) - 1; i >= 0; i-- {
+		results = append(results, types.RerankResult{Index: i, Score: float64(i) / 10})
+	}
+	return results, nil
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cassettes", "login.json")
+	schema := &types.JSONSchema{Name: "dom_analysis", Schema: map[string]any{"type": "object"}}
+	rerankRequest := &types.RerankRequest{Query: "login button", Documents: []string{"a", "b", "c"}, TopN: 2}
+
+	cassette, err := NewCassette(path, ModeRecord)
+	assert.NoError(t, err)
+	llmClient, err := NewLLMClient(&stubLLMClient{t: t, json: `{"element_id": "1.2", "error": ""}`}, cassette)
+	assert.NoError(t, err)
+	rerankerClient, err := NewRerankerClient(&stubRerankerClient{t: t}, cassette)
+	assert.NoError(t, err)
+
+	recorded, err := llmClient.GetStructuredCompletion(ctx, "find the login button\n<html/>", []byte("image"), schema)
+	assert.NoError(t, err)
+	recordedResults, err := rerankerClient.Rerank(ctx, rerankRequest)
+	assert.NoError(t, err)
+
+	cassette, err = NewCassette(path, ModeReplay)
+	assert.NoError(t, err)
+	llmClient, err = NewLLMClient(&stubLLMClient{t: t, forbid: true}, cassette)
+	assert.NoError(t, err)
+	rerankerClient, err = NewRerankerClient(&stubRerankerClient{t: t, forbid: true}, cassette)
+	assert.NoError(t, err)
+
+	replayed, err := llmClient.GetStructuredCompletion(ctx, "find the login button\n<html/>", []byte("image"), schema)
+	assert.NoError(t, err)
+	assert.JSONEq(t, recorded.JSON, replayed.JSON)
+	assert.Equal(t, recorded.LLMCompletionMeta, replayed.LLMCompletionMeta)
+	replayedResults, err := rerankerClient.Rerank(ctx, rerankRequest)
+	assert.NoError(t, err)
+	assert.Equal(t, recordedResults, replayedResults)
+
+	t.Run("fails on unknown requests", func(t *testing.T) {
+		_, err := llmClient.GetStructuredCompletion(ctx, "find the signup button", nil, schema)
+		assert.ErrorIs(t, err, ErrInteractionNotFound)
+		assert.ErrorContains(t, err, "find the signup button")
+
+		_, err = llmClient.GetStructuredCompletion(ctx, "find the login button\n<html/>", []byte("other image"), schema)
+		assert.ErrorIs(t, err, ErrInteractionNotFound)
+
+		_, err = rerankerClient.Rerank(ctx, &types.RerankRequest{Query: "login button", Documents: []string{"a"}, TopN: 2})
+		assert.ErrorIs(t, err, ErrInteractionNotFound)
+	})
+}
+
+func TestCassetteErrors(t *testing.T) {
+	_, err := NewCassette(filepath.Join(t.TempDir(), "missing.json"), ModeReplay)
+	assert.ErrorContains(t, err, "couldn't read cassette")
+
+	_, err = NewCassette("cassette.json", "rewind")
+	assert.EqualError(t, err, `invalid cassette mode: "rewind"`)
+}
+
+func TestRedaction(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	redactor := RedactRegexp(regexp.MustCompile(`sk-[a-z0-9]+`), "<api-key>")
+
+	cassette, err := NewCassette(path, ModeRecord, WithRedactors(redactor))
+	assert.NoError(t, err)
+	llmClient, err := NewLLMClient(&stubLLMClient{t: t, json: `{"value": "sk-secret1"}`}, cassette)
+	assert.NoError(t, err)
+	rerankerClient, err := NewRerankerClient(&stubRerankerClient{t: t}, cassette)
+	assert.NoError(t, err)
+
+	_, err = llmClient.GetJSONCompletion(ctx, "token sk-secret1", nil)
+	assert.NoError(t, err)
+	_, err = rerankerClient.Rerank(ctx, &types.RerankRequest{Query: "sk-secret1", Documents: []string{"sk-secret2"}})
+	assert.NoError(t, err)
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "sk-secret")
+	assert.Contains(t, string(data), "<api-key>")
+
+	// The keys are computed from the redacted requests, so that they replay with other secrets
+	cassette, err = NewCassette(path, ModeReplay, WithRedactors(redactor))
+	assert.NoError(t, err)
+	llmClient, err = NewLLMClient(&stubLLMClient{t: t, forbid: true}, cassette)
+	assert.NoError(t, err)
+	completion, err := llmClient.GetJSONCompletion(ctx, "token sk-other", nil)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"value": "<api-key>"}`, completion.JSON)
+}
+
+func TestCassetteIsDeterministic(t *testing.T) {
+	ctx := context.Background()
+	prompts := []string{"first", "second", "third"}
+
+	record := func(order []int) []byte {
+		path := filepath.Join(t.TempDir(), "cassette.json")
+		cassette, err := NewCassette(path, ModeRecord)
+		assert.NoError(t, err)
+		llmClient, err := NewLLMClient(&stubLLMClient{t: t, json: `{"b": 1, "a": [1, 2]}`}, cassette)
+		assert.NoError(t, err)
+		for _, i := range order {
+			_, err := llmClient.GetJSONCompletion(ctx, prompts[i], nil)
+			assert.NoError(t, err)
+		}
+		data, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		return data
+	}
+
+	assert.Equal(t, string(record([]int{0, 1, 2})), string(record([]int{2, 0, 1})))
+}
+
+func TestRecordDoesNotStoreErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	cassette, err := NewCassette(path, ModeRecord)
+	assert.NoError(t, err)
+	rerankerClient, err := NewRerankerClient(failingRerankerClient{}, cassette)
+	assert.NoError(t, err)
+
+	_, err = rerankerClient.Rerank(context.Background(), &types.RerankRequest{Query: "query"})
+	assert.EqualError(t, err, "rate limited")
+	assert.NoFileExists(t, path)
+}
+
+type failingRerankerClient struct{}
+
+func (failingRerankerClient) Rerank(ctx context.Context, request *types.RerankRequest) ([]types.RerankResult, error) {
+	return nil, errors.New("rate limited")
+}
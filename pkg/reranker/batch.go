@@ -0,0 +1,175 @@
+package reranker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/vertexcover-io/locatr/pkg/internal/constants"
+	"github.com/vertexcover-io/locatr/pkg/internal/splitters"
+	"github.com/vertexcover-io/locatr/pkg/internal/utils"
+	"github.com/vertexcover-io/locatr/pkg/types"
+)
+
+// documentLimits are the limits of a rerank API on the documents of a single request.
+type documentLimits struct {
+	// maxDocuments is the maximum number of documents of a request
+	maxDocuments int
+	// maxDocumentTokens is the maximum number of tokens of a document, beyond which it is truncated or rejected
+	maxDocumentTokens int
+}
+
+// providerLimits are the documented limits of the hosted rerank APIs.
+// Local providers and providers batching requests themselves have none.
+var providerLimits = map[types.RerankerProvider]documentLimits{
+	Cohere: {maxDocuments: 1000, maxDocumentTokens: 4096},
+	Voyage: {maxDocuments: 1000, maxDocumentTokens: 8000},
+	Jina:   {maxDocumentTokens: 1024},
+}
+
+// splitDocument splits a document exceeding maxTokens into parts that don't, at HTML block tags when possible.
+//
+// Parameters:
+//   - document: The document to split
+//   - maxTokens: The maximum number of tokens of a part
+//   - tokenizer: The tokenizer estimating the number of tokens
+//
+// Returns the parts of the document, the document itself if it doesn't exceed maxTokens.
+func splitDocument(document string, maxTokens int, tokenizer types.Tokenizer) []string {
+	tokens := tokenizer.CountTokens(document)
+	if tokens <= maxTokens {
+		return []string{document}
+	}
+	chunkSize := max(1, len(document)*maxTokens/tokens)
+
+	parts := []string{}
+	for _, part := range splitters.SplitHtml(document, constants.HTML_SEPARATORS, chunkSize) {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		// Parts without any separator are cut, shrinking until they fit
+		for tokenizer.CountTokens(part) > maxTokens {
+			runes := []rune(part)
+			cut := max(1, len(runes)*maxTokens/tokenizer.CountTokens(part))
+			for cut > 1 && tokenizer.CountTokens(string(runes[:cut])) > maxTokens {
+				cut = cut * 9 / 10
+			}
+			parts = append(parts, string(runes[:cut]))
+			part = string(runes[cut:])
+		}
+		if strings.TrimSpace(part) != "" {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+// batchHandler wraps a rerank handler so that requests exceeding the limits of the provider are split into
+// batches ranked concurrently. Documents exceeding the token limit are split into parts, and are scored by
+// their best part. The results are merged into the indices of the documents of the original request.
+//
+// Parameters:
+//   - handler: The handler sending the requests to the provider
+//   - limits: The limits of the provider, zero or negative for no limit
+//   - concurrency: The maximum number of batches ranked at the same time
+//   - tokenizer: The tokenizer estimating the number of tokens of the documents
+//
+// Returns the batching handler, the handler itself if there are no limits.
+func batchHandler(handler Handler, limits documentLimits, concurrency int, tokenizer types.Tokenizer) Handler {
+	if limits.maxDocuments <= 0 && limits.maxDocumentTokens <= 0 {
+		return handler
+	}
+	return func(ctx context.Context, request *types.RerankRequest) ([]types.RerankResult, error) {
+		// parts are the documents sent to the provider, owners[i] is the index of the document of parts[i]
+		parts, owners := request.Documents, []int(nil)
+		if limits.maxDocumentTokens > 0 {
+			parts, owners = []string{}, []int{}
+			for i, document := range request.Documents {
+				for _, part := range splitDocument(document, limits.maxDocumentTokens, tokenizer) {
+					parts, owners = append(parts, part), append(owners, i)
+				}
+			}
+		}
+		split := len(parts) != len(request.Documents)
+		batchSize := len(parts)
+		if limits.maxDocuments > 0 {
+			batchSize = min(batchSize, limits.maxDocuments)
+		}
+		if !split && batchSize == len(parts) {
+			return handler(ctx, request)
+		}
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		batchCount := (len(parts) + batchSize - 1) / batchSize
+		rankings := make([][]types.RerankResult, batchCount)
+		errs := make([]error, batchCount)
+		semaphore := make(chan struct{}, max(concurrency, 1))
+		var wg sync.WaitGroup
+		for batch := range batchCount {
+			start := batch * batchSize
+			documents := parts[start:min(start+batchSize, len(parts))]
+			// The global TopN is within the TopN of each batch, unless a document has several parts in one
+			topN := len(documents)
+			if request.TopN > 0 && !split {
+				topN = min(request.TopN, topN)
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				semaphore <- struct{}{}
+				defer func() { <-semaphore }()
+				if ctx.Err() != nil {
+					errs[batch] = ctx.Err()
+					return
+				}
+				rankings[batch], errs[batch] = handler(
+					ctx, &types.RerankRequest{Query: request.Query, Documents: documents, TopN: topN},
+				)
+				if errs[batch] != nil {
+					cancel()
+				}
+			}()
+		}
+		wg.Wait()
+		// The first failed batch is reported, rather than the batches canceled because of it
+		for batch, err := range errs {
+			if err != nil && !errors.Is(err, context.Canceled) {
+				return nil, fmt.Errorf("rerank batch %d of %d failed: %w", batch+1, batchCount, err)
+			}
+		}
+		for batch, err := range errs {
+			if err != nil {
+				return nil, fmt.Errorf("rerank batch %d of %d failed: %w", batch+1, batchCount, err)
+			}
+		}
+
+		scored := make([]bool, len(request.Documents))
+		scores := make([]float64, len(request.Documents))
+		for batch, ranking := range rankings {
+			for _, result := range ranking {
+				part := batch*batchSize + result.Index
+				if result.Index < 0 || part >= min((batch+1)*batchSize, len(parts)) {
+					return nil, fmt.Errorf("rerank result index out of range: %d", result.Index)
+				}
+				index := part
+				if owners != nil {
+					index = owners[part]
+				}
+				if !scored[index] || result.Score > scores[index] {
+					scored[index], scores[index] = true, result.Score
+				}
+			}
+		}
+
+		results := []types.RerankResult{}
+		for index, ok := range scored {
+			if ok {
+				results = append(results, types.RerankResult{Index: index, Score: scores[index]})
+			}
+		}
+		return utils.TopRerankResults(results, request.TopN), nil
+	}
+}
@@ -0,0 +1,164 @@
+package reranker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vertexcover-io/locatr/pkg/types"
+)
+
+// rerankServer is a stub rerank endpoint scoring the documents by the presence of the query.
+// It records the documents of each request and the maximum number of requests in flight.
+type rerankServer struct {
+	mu        sync.Mutex
+	batches   [][]string
+	inFlight  atomic.Int32
+	maxFlight atomic.Int32
+	failOn    string
+}
+
+func (s *rerankServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flight := s.inFlight.Add(1)
+	defer s.inFlight.Add(-1)
+	for {
+		current := s.maxFlight.Load()
+		if flight <= current || s.maxFlight.CompareAndSwap(current, flight) {
+			break
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	var body struct {
+		Query     string   `json:"query"`
+		Documents []string `json:"documents"`
+	}
+	_ = json.NewDecoder(r.Body).Decode(&body)
+	s.mu.Lock()
+	s.batches = append(s.batches, body.Documents)
+	s.mu.Unlock()
+
+	results := []map[string]any{}
+	for i, document := range body.Documents {
+		if s.failOn != "" && strings.Contains(document, s.failOn) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		score := 0.1
+		if strings.Contains(document, body.Query) {
+			score = 0.9
+		}
+		results = append(results, map[string]any{"index": i, "score": score + float64(i)/1000})
+	}
+	_ = json.NewEncoder(w).Encode(results)
+}
+
+func newBatchedClient(t *testing.T, server *httptest.Server, opts ...Option) *rerankerClient {
+	client, err := NewRerankerClient(append([]Option{
+		WithProvider(HTTP),
+		WithBaseURL(server.URL),
+		WithHTTPMapping(HTTPMapping{QueryField: "query", DocumentsField: "documents", IndexField: "index", ScoreField: "score"}),
+	}, opts...)...)
+	assert.NoError(t, err)
+	return client
+}
+
+func TestRerankBatching(t *testing.T) {
+	documents := make([]string, 7)
+	for i := range documents {
+		documents[i] = fmt.Sprintf("<div>item %d</div>", i)
+	}
+	documents[5] = "<button>Checkout</button>"
+
+	stub := &rerankServer{}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+	client := newBatchedClient(t, server, WithMaxDocuments(2), WithConcurrency(2))
+
+	results, err := client.Rerank(
+		context.Background(), &types.RerankRequest{Query: "Checkout", Documents: documents, TopN: 3},
+	)
+	assert.NoError(t, err)
+	// Scores of the batches are merged into the indices of the request, ties keeping the order of the documents
+	assert.Equal(t, []int{5, 1, 3}, []int{results[0].Index, results[1].Index, results[2].Index})
+	assert.InDelta(t, 0.901, results[0].Score, 1e-9)
+	assert.Len(t, stub.batches, 4)
+	for _, batch := range stub.batches {
+		assert.LessOrEqual(t, len(batch), 2)
+	}
+	assert.Equal(t, int32(2), stub.maxFlight.Load())
+}
+
+func TestRerankBatching_OversizedDocuments(t *testing.T) {
+	long := "<div>" + strings.Repeat("<p>shipping details and terms</p>", 40) + "<p>Checkout</p></div>"
+	documents := []string{"<div>Cart</div>", long, "<div>Footer</div>"}
+
+	stub := &rerankServer{}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+	client := newBatchedClient(t, server, WithMaxDocumentTokens(50))
+
+	results, err := client.Rerank(
+		context.Background(), &types.RerankRequest{Query: "Checkout", Documents: documents, TopN: 2},
+	)
+	assert.NoError(t, err)
+	assert.Len(t, results, 2)
+	// The long document is scored by its part with the exact match
+	assert.Equal(t, 1, results[0].Index)
+	assert.Greater(t, results[0].Score, 0.9)
+
+	assert.Len(t, stub.batches, 1)
+	assert.Greater(t, len(stub.batches[0]), len(documents))
+	for _, part := range stub.batches[0] {
+		assert.LessOrEqual(t, types.HeuristicTokenizer{}.CountTokens(part), 50)
+	}
+}
+
+func TestRerankBatching_FailedBatch(t *testing.T) {
+	stub := &rerankServer{failOn: "too large"}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+	client := newBatchedClient(t, server, WithMaxDocuments(1), WithConcurrency(1))
+
+	_, err := client.Rerank(context.Background(), &types.RerankRequest{
+		Query: "a", Documents: []string{"<p>a</p>", "<p>too large</p>", "<p>b</p>"},
+	})
+	assert.ErrorContains(t, err, "rerank batch 2 of 3 failed: rerank request failed with status 413")
+}
+
+func TestSplitDocument(t *testing.T) {
+	tokenizer := types.HeuristicTokenizer{}
+	tests := []struct {
+		name      string
+		document  string
+		maxTokens int
+		split     bool
+	}{
+		{name: "small document", document: "<div>Login</div>", maxTokens: 50},
+		{name: "block tags", document: strings.Repeat("<div>some text here</div>", 10), maxTokens: 20, split: true},
+		{name: "no separator", document: strings.Repeat("word ", 100), maxTokens: 30, split: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts := splitDocument(tt.document, tt.maxTokens, tokenizer)
+			if !tt.split {
+				assert.Equal(t, []string{tt.document}, parts)
+				return
+			}
+			assert.Greater(t, len(parts), 1)
+			for _, part := range parts {
+				assert.LessOrEqual(t, tokenizer.CountTokens(part), tt.maxTokens)
+				assert.Contains(t, tt.document, strings.TrimSpace(part)[:5])
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package reranker
+
+import (
+	"math"
+	"regexp"
+	"strings"
+
+	"github.com/vertexcover-io/locatr/pkg/internal/utils"
+	"github.com/vertexcover-io/locatr/pkg/types"
+)
+
+const (
+	// defaultBM25K1 controls how quickly the score saturates with the frequency of a term.
+	defaultBM25K1 = 1.2
+	// defaultBM25B controls how much the score is normalized by the length of the document.
+	defaultBM25B = 0.75
+)
+
+var (
+	// htmlTagPattern matches the tags of an HTML chunk.
+	htmlTagPattern = regexp.MustCompile(`<[^>]*>`)
+	// htmlAttributePattern matches the attributes of an HTML tag along with their values.
+	htmlAttributePattern = regexp.MustCompile(`([\w:.-]+)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+)
+
+// ignoredAttributes are the attributes whose values are not indexed, since they are generated by locatr.
+var ignoredAttributes = map[string]bool{"id": true}
+
+// tokenizeDocument tokenizes the text and attribute values of an HTML chunk.
+// Tag and attribute names are left out, so that the markup doesn't outweigh the content.
+func tokenizeDocument(document string) []string {
+	tokens := []string{}
+	last := 0
+	for _, tag := range htmlTagPattern.FindAllStringIndex(document, -1) {
+		tokens = append(tokens, utils.Tokenize(document[last:tag[0]])...)
+		for _, attribute := range htmlAttributePattern.FindAllStringSubmatch(document[tag[0]:tag[1]], -1) {
+			if !ignoredAttributes[strings.ToLower(attribute[1])] {
+				tokens = append(tokens, utils.Tokenize(attribute[2]+" "+attribute[3])...)
+			}
+		}
+		last = tag[1]
+	}
+	return append(tokens, utils.Tokenize(document[last:])...)
+}
+
+// rankBM25 ranks the documents of the request by their Okapi BM25 score for the query.
+//
+// Parameters:
+//   - request: The query and documents to rank
+//   - k1: The term frequency saturation
+//   - b: The document length normalization
+//
+// Returns the TopN results sorted by decreasing score, all of them if TopN isn't set.
+func rankBM25(request *types.RerankRequest, k1, b float64) []types.RerankResult {
+	documents := make([]map[string]int, len(request.Documents))
+	lengths := make([]int, len(request.Documents))
+	documentFrequencies := map[string]int{}
+	totalLength := 0
+	for i, document := range request.Documents {
+		frequencies := map[string]int{}
+		tokens := tokenizeDocument(document)
+		for _, token := range tokens {
+			frequencies[token]++
+		}
+		for token := range frequencies {
+			documentFrequencies[token]++
+		}
+		documents[i], lengths[i] = frequencies, len(tokens)
+		totalLength += len(tokens)
+	}
+	averageLength := 1.0
+	if totalLength > 0 {
+		averageLength = float64(totalLength) / float64(len(request.Documents))
+	}
+
+	queryTerms := map[string]bool{}
+	for _, token := range utils.Tokenize(request.Query) {
+		queryTerms[token] = true
+	}
+
+	count := float64(len(request.Documents))
+	results := make([]types.RerankResult, len(request.Documents))
+	for i, frequencies := range documents {
+		score := 0.0
+		for term := range queryTerms {
+			frequency := float64(frequencies[term])
+			if frequency == 0 {
+				continue
+			}
+			n := float64(documentFrequencies[term])
+			idf := math.Log(1 + (count-n+0.5)/(n+0.5))
+			norm := k1 * (1 - b + b*float64(lengths[i])/averageLength)
+			score += idf * frequency * (k1 + 1) / (frequency + norm)
+		}
+		results[i] = types.RerankResult{Index: i, Score: score}
+	}
+
+	return utils.TopRerankResults(results, request.TopN)
+}
@@ -0,0 +1,75 @@
+package reranker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vertexcover-io/locatr/pkg/types"
+)
+
+func TestTokenizeDocument(t *testing.T) {
+	document := `<div id="123" class="login-form"><button id="456" data-testid="submitButton">Sign in to your account</button></div>`
+	assert.Equal(t,
+		[]string{"login", "form", "submit", "button", "sign", "your", "account"},
+		tokenizeDocument(document),
+	)
+}
+
+func TestBM25Reranker(t *testing.T) {
+	client, err := NewRerankerClient(WithProvider(BM25))
+	assert.NoError(t, err)
+
+	documents := []string{
+		`<nav id="1"><a id="2" href="/home">Home</a><a id="3" href="/pricing">Pricing</a></nav>`,
+		`<form id="4"><input id="5" placeholder="Email"><button id="6" aria-label="Log in">Log in</button></form>`,
+		`<footer id="7">Copyright, log of changes</footer>`,
+		`<div id="8"><button id="9">Sign up</button></div>`,
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		topN     int
+		expected []int
+	}{
+		{name: "ranks the matching chunk first", query: "click the log in button", topN: 2, expected: []int{1, 2}},
+		{name: "matches attribute values", query: "pricing link", topN: 1, expected: []int{0}},
+		{name: "returns every document without top n", query: "sign up", expected: []int{3, 0, 1, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := client.Rerank(
+				context.Background(), &types.RerankRequest{Query: tt.query, Documents: documents, TopN: tt.topN},
+			)
+			assert.NoError(t, err)
+			indexes := []int{}
+			for _, result := range results {
+				indexes = append(indexes, result.Index)
+			}
+			assert.Equal(t, tt.expected, indexes)
+			assert.Greater(t, results[0].Score, 0.0)
+		})
+	}
+
+	t.Run("handles empty requests", func(t *testing.T) {
+		results, err := client.Rerank(context.Background(), &types.RerankRequest{Query: "login", TopN: 3})
+		assert.NoError(t, err)
+		assert.Empty(t, results)
+	})
+}
+
+func TestNewRerankerClient_InvalidBM25Parameters(t *testing.T) {
+	_, err := NewRerankerClient(WithProvider(BM25), WithBM25Parameters(1.2, 1.5))
+	assert.EqualError(t, err, "invalid bm25 parameters: k1=1.2, b=1.5")
+}
+
+func TestDefaultRerankerClient_FallsBackToBM25(t *testing.T) {
+	t.Setenv("LOCATR_COHERE_API_KEY", "")
+	t.Setenv("COHERE_API_KEY", "")
+
+	client, err := DefaultRerankerClient(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, BM25, client.config.provider)
+}
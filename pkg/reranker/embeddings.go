@@ -0,0 +1,162 @@
+package reranker
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/openai/openai-go"
+	"github.com/vertexcover-io/locatr/pkg/internal/utils"
+	"github.com/vertexcover-io/locatr/pkg/types"
+)
+
+// maxEmbeddingInputs is the maximum number of texts embedded by a single request, as limited by OpenAI.
+const maxEmbeddingInputs = 2048
+
+// embeddingCache keeps the embeddings of texts in memory, keyed by the hash of the model and the text,
+// so that the unchanged chunks of repeated snapshots of a page are not embedded again.
+// The oldest embeddings are evicted when the cache is full.
+type embeddingCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	entries    map[[sha256.Size]byte][]float64
+	keys       [][sha256.Size]byte
+}
+
+// newEmbeddingCache creates an embedding cache holding up to maxEntries embeddings, zero for no limit.
+func newEmbeddingCache(maxEntries int) *embeddingCache {
+	return &embeddingCache{maxEntries: maxEntries, entries: map[[sha256.Size]byte][]float64{}}
+}
+
+// embeddingKey returns the cache key of the embedding of the text by the model.
+func embeddingKey(model, text string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(model + "\x00" + text))
+}
+
+func (c *embeddingCache) get(key [sha256.Size]byte) ([]float64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	embedding, ok := c.entries[key]
+	return embedding, ok
+}
+
+func (c *embeddingCache) put(key [sha256.Size]byte, embedding []float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok {
+		if c.maxEntries > 0 && len(c.keys) >= c.maxEntries {
+			delete(c.entries, c.keys[0])
+			c.keys = c.keys[1:]
+		}
+		c.keys = append(c.keys, key)
+	}
+	c.entries[key] = embedding
+}
+
+// embed returns the embeddings of the texts, requesting only the ones missing from the cache.
+// Blank texts can't be embedded and get a nil embedding.
+//
+// Parameters:
+//   - client: The client of the OpenAI-compatible API
+//   - model: The embedding model
+//   - cache: The embedding cache
+//   - texts: The texts to embed
+//
+// Returns:
+//   - [][]float64: The embeddings, in the order of the texts
+//   - error: If the embeddings request fails
+func embed(
+	ctx context.Context, client *openai.Client, model string, cache *embeddingCache, texts []string,
+) ([][]float64, error) {
+	embeddings := make([][]float64, len(texts))
+	missing := []string{}
+	missingIndexes := map[string][]int{}
+	for i, text := range texts {
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		if embedding, ok := cache.get(embeddingKey(model, text)); ok {
+			embeddings[i] = embedding
+			continue
+		}
+		if _, ok := missingIndexes[text]; !ok {
+			missing = append(missing, text)
+		}
+		missingIndexes[text] = append(missingIndexes[text], i)
+	}
+
+	for start := 0; start < len(missing); start += maxEmbeddingInputs {
+		batch := missing[start:min(start+maxEmbeddingInputs, len(missing))]
+		response, err := client.Embeddings.New(ctx, openai.EmbeddingNewParams{
+			Input:          openai.F[openai.EmbeddingNewParamsInputUnion](openai.EmbeddingNewParamsInputArrayOfStrings(batch)),
+			Model:          openai.F(model),
+			EncodingFormat: openai.F(openai.EmbeddingNewParamsEncodingFormatFloat),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(response.Data) != len(batch) {
+			return nil, fmt.Errorf("expected %d embeddings, got %d", len(batch), len(response.Data))
+		}
+		for _, data := range response.Data {
+			if data.Index < 0 || int(data.Index) >= len(batch) {
+				return nil, fmt.Errorf("embedding index out of range: %d", data.Index)
+			}
+			text := batch[data.Index]
+			cache.put(embeddingKey(model, text), data.Embedding)
+			for _, i := range missingIndexes[text] {
+				embeddings[i] = data.Embedding
+			}
+		}
+	}
+	return embeddings, nil
+}
+
+// cosineSimilarity returns the cosine of the angle between two vectors, zero if either is empty or null.
+func cosineSimilarity(a, b []float64) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+	dot, normA, normB := 0.0, 0.0, 0.0
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// requestEmbeddings ranks the documents by the cosine similarity of their embeddings with the embedding of the query.
+//
+// Parameters:
+//   - client: The client of the OpenAI-compatible API
+//   - model: The embedding model
+//   - cache: The embedding cache
+//   - request: Contains the query and documents to rerank
+//
+// Returns:
+//   - []RerankResult: The TopN documents sorted by decreasing similarity, all of them if TopN isn't set
+//   - error: If the embeddings request fails
+func requestEmbeddings(
+	ctx context.Context, client *openai.Client, model string, cache *embeddingCache, request *types.RerankRequest,
+) ([]types.RerankResult, error) {
+	if len(request.Documents) == 0 {
+		return []types.RerankResult{}, nil
+	}
+	embeddings, err := embed(ctx, client, model, cache, append([]string{request.Query}, request.Documents...))
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]types.RerankResult, len(request.Documents))
+	for i := range request.Documents {
+		results[i] = types.RerankResult{Index: i, Score: cosineSimilarity(embeddings[0], embeddings[i+1])}
+	}
+	return utils.TopRerankResults(results, request.TopN), nil
+}
@@ -0,0 +1,124 @@
+package reranker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vertexcover-io/locatr/pkg/types"
+)
+
+// embeddingsServer is a stub OpenAI-compatible /embeddings endpoint. Texts are embedded
+// by the presence of a few keywords, and the embedded texts are recorded.
+type embeddingsServer struct {
+	mu       sync.Mutex
+	inputs   [][]string
+	keywords []string
+}
+
+func (s *embeddingsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/embeddings" || r.Header.Get("Authorization") != "Bearer local-key" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	var body struct {
+		Input []string `json:"input"`
+		Model string   `json:"model"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Model != "nomic-embed-text" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	s.inputs = append(s.inputs, body.Input)
+	s.mu.Unlock()
+
+	data := []map[string]any{}
+	// Answer in reverse order, the index tells which input an embedding belongs to
+	for i := len(body.Input) - 1; i >= 0; i-- {
+		embedding := make([]float64, len(s.keywords))
+		for j, keyword := range s.keywords {
+			if strings.Contains(strings.ToLower(body.Input[i]), keyword) {
+				embedding[j] = 1
+			}
+		}
+		data = append(data, map[string]any{"object": "embedding", "index": i, "embedding": embedding})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"object": "list", "data": data, "model": body.Model,
+		"usage": map[string]any{"prompt_tokens": 1, "total_tokens": 1},
+	})
+}
+
+func TestEmbeddingsReranker(t *testing.T) {
+	stub := &embeddingsServer{keywords: []string{"login", "button", "footer", "search"}}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	client, err := NewRerankerClient(
+		WithProvider(OpenAIEmbeddings),
+		WithModel("nomic-embed-text"),
+		WithAPIKey("local-key"),
+		WithBaseURL(server.URL+"/v1"),
+	)
+	assert.NoError(t, err)
+
+	documents := []string{
+		`<footer id="1">Footer links</footer>`,
+		`<button id="2">Login</button>`,
+		`<input id="3" placeholder="Search">`,
+		``,
+	}
+	results, err := client.Rerank(
+		context.Background(), &types.RerankRequest{Query: "login button", Documents: documents, TopN: 2},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, results[0].Index)
+	assert.InDelta(t, 1.0, results[0].Score, 1e-9)
+	assert.Len(t, results, 2)
+	// Blank documents aren't sent
+	assert.Equal(t, [][]string{{"login button", documents[0], documents[1], documents[2]}}, stub.inputs)
+
+	t.Run("embeds only the changed chunks of a new snapshot", func(t *testing.T) {
+		documents := []string{documents[0], `<button id="2">Login now</button>`, documents[2], documents[0]}
+		results, err := client.Rerank(
+			context.Background(), &types.RerankRequest{Query: "login button", Documents: documents},
+		)
+		assert.NoError(t, err)
+		assert.Len(t, results, 4)
+		assert.Equal(t, 1, results[0].Index)
+		assert.Len(t, stub.inputs, 2)
+		assert.Equal(t, []string{`<button id="2">Login now</button>`}, stub.inputs[1])
+	})
+
+	t.Run("returns the errors of the endpoint", func(t *testing.T) {
+		client, err := NewRerankerClient(
+			WithProvider(OpenAIEmbeddings),
+			WithModel("nomic-embed-text"),
+			WithAPIKey("wrong-key"),
+			WithBaseURL(server.URL+"/v1"),
+		)
+		assert.NoError(t, err)
+		_, err = client.Rerank(context.Background(), &types.RerankRequest{Query: "login", Documents: documents})
+		assert.ErrorContains(t, err, "404")
+	})
+}
+
+func TestEmbeddingCache_Eviction(t *testing.T) {
+	cache := newEmbeddingCache(2)
+	for _, text := range []string{"a", "b", "c"} {
+		cache.put(embeddingKey("model", text), []float64{1})
+	}
+	_, ok := cache.get(embeddingKey("model", "a"))
+	assert.False(t, ok)
+	_, ok = cache.get(embeddingKey("model", "c"))
+	assert.True(t, ok)
+	_, ok = cache.get(embeddingKey("other-model", "c"))
+	assert.False(t, ok)
+}
@@ -0,0 +1,203 @@
+package reranker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/vertexcover-io/locatr/pkg/internal/utils"
+	"github.com/vertexcover-io/locatr/pkg/types"
+)
+
+// HTTPMapping describes the JSON request and response of a rerank endpoint.
+// Fields are dotted paths into the JSON objects, e.g. "output.results".
+type HTTPMapping struct {
+	// QueryField is the request field holding the query. Required.
+	QueryField string
+	// DocumentsField is the request field holding the list of documents. Required.
+	DocumentsField string
+	// ModelField is the request field holding the model, not sent if empty.
+	ModelField string
+	// TopNField is the request field holding the number of results to return, not sent if empty.
+	TopNField string
+	// ResultsField is the response field holding the list of results, the response itself if empty.
+	ResultsField string
+	// IndexField is the field of a result holding the index of its document.
+	// If empty, the results are in the order of the documents.
+	IndexField string
+	// ScoreField is the field of a result holding its score.
+	// If empty, the results are the scores themselves, e.g. [0.9, 0.1].
+	ScoreField string
+}
+
+// jinaMapping is the mapping of Jina's rerank API, https://jina.ai/reranker.
+var jinaMapping = HTTPMapping{
+	QueryField:     "query",
+	DocumentsField: "documents",
+	ModelField:     "model",
+	TopNField:      "top_n",
+	ResultsField:   "results",
+	IndexField:     "index",
+	ScoreField:     "relevance_score",
+}
+
+// voyageMapping is the mapping of Voyage's rerank API, https://docs.voyageai.com/reference/reranker-api.
+var voyageMapping = HTTPMapping{
+	QueryField:     "query",
+	DocumentsField: "documents",
+	ModelField:     "model",
+	TopNField:      "top_k",
+	ResultsField:   "data",
+	IndexField:     "index",
+	ScoreField:     "relevance_score",
+}
+
+// validate checks that the mapping has the fields required to build a request.
+func (m *HTTPMapping) validate() error {
+	if m.QueryField == "" || m.DocumentsField == "" {
+		return errors.New("query and documents fields of the http mapping are required")
+	}
+	return nil
+}
+
+// setPath sets the value at the dotted path of a JSON object, creating the intermediate objects.
+func setPath(object map[string]any, path string, value any) {
+	keys := strings.Split(path, ".")
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := object[key].(map[string]any)
+		if !ok {
+			next = map[string]any{}
+			object[key] = next
+		}
+		object = next
+	}
+	object[keys[len(keys)-1]] = value
+}
+
+// lookupPath returns the value at the dotted path of a JSON value, the value itself if the path is empty.
+func lookupPath(value any, path string) (any, bool) {
+	if path == "" {
+		return value, true
+	}
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[key]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// httpReranker sends rerank requests to an HTTP endpoint described by a mapping.
+type httpReranker struct {
+	client  *http.Client
+	url     string
+	apiKey  string
+	headers map[string]string
+	mapping HTTPMapping
+}
+
+// rerank sends the request to the endpoint and maps the scores of the response into results.
+//
+// Parameters:
+//   - model: Model identifier, not sent if empty
+//   - request: Contains the query and documents to rerank
+//
+// Returns:
+//   - []RerankResult: The TopN documents sorted by decreasing score, all of them if TopN isn't set
+//   - error: If the request fails or the response doesn't match the mapping
+func (r *httpReranker) rerank(ctx context.Context, model string, request *types.RerankRequest) ([]types.RerankResult, error) {
+	if len(request.Documents) == 0 {
+		return []types.RerankResult{}, nil
+	}
+
+	body := map[string]any{}
+	setPath(body, r.mapping.QueryField, request.Query)
+	setPath(body, r.mapping.DocumentsField, request.Documents)
+	if r.mapping.ModelField != "" && model != "" {
+		setPath(body, r.mapping.ModelField, model)
+	}
+	if r.mapping.TopNField != "" && request.TopN > 0 {
+		setPath(body, r.mapping.TopNField, min(request.TopN, len(request.Documents)))
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, r.url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+	httpRequest.Header.Set("Accept", "application/json")
+	if r.apiKey != "" {
+		httpRequest.Header.Set("Authorization", "Bearer "+r.apiKey)
+	}
+	for key, value := range r.headers {
+		httpRequest.Header.Set(key, value)
+	}
+
+	response, err := r.client.Do(httpRequest)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	content, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return nil, fmt.Errorf("rerank request failed with status %d: %s", response.StatusCode, strings.TrimSpace(string(content)))
+	}
+
+	var decoded any
+	if err := json.Unmarshal(content, &decoded); err != nil {
+		return nil, fmt.Errorf("couldn't decode rerank response: %w", err)
+	}
+	return r.parseResults(decoded, len(request.Documents), request.TopN)
+}
+
+// parseResults maps the decoded response into results, following the mapping.
+func (r *httpReranker) parseResults(decoded any, documentCount, topN int) ([]types.RerankResult, error) {
+	value, ok := lookupPath(decoded, r.mapping.ResultsField)
+	if !ok {
+		return nil, fmt.Errorf("rerank response has no %q field", r.mapping.ResultsField)
+	}
+	items, ok := value.([]any)
+	if !ok {
+		return nil, fmt.Errorf("rerank results are not a list: %T", value)
+	}
+
+	results := make([]types.RerankResult, 0, len(items))
+	for position, item := range items {
+		index := position
+		if r.mapping.IndexField != "" {
+			value, ok := lookupPath(item, r.mapping.IndexField)
+			number, isNumber := value.(float64)
+			if !ok || !isNumber {
+				return nil, fmt.Errorf("rerank result %d has no numeric %q field", position, r.mapping.IndexField)
+			}
+			index = int(number)
+		}
+		if index < 0 || index >= documentCount {
+			return nil, fmt.Errorf("rerank result index out of range: %d", index)
+		}
+		value, ok := lookupPath(item, r.mapping.ScoreField)
+		score, isNumber := value.(float64)
+		if !ok || !isNumber {
+			return nil, fmt.Errorf("rerank result %d has no numeric %q score", position, r.mapping.ScoreField)
+		}
+		results = append(results, types.RerankResult{Index: index, Score: score})
+	}
+	// Endpoints which ignore the requested number of results or don't sort them are handled alike
+	return utils.TopRerankResults(results, topN), nil
+}
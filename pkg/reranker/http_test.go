@@ -0,0 +1,175 @@
+package reranker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vertexcover-io/locatr/pkg/types"
+)
+
+func TestHTTPRerankers(t *testing.T) {
+	documents := []string{"<div>footer</div>", "<button>Log in</button>", "<input placeholder='Search'>"}
+
+	tests := []struct {
+		name         string
+		opts         []Option
+		response     string
+		status       int
+		expectedPath string
+		expectedBody map[string]any
+		expected     []types.RerankResult
+		expectedErr  string
+	}{
+		{
+			name:         "jina",
+			opts:         []Option{WithProvider(Jina), WithModel("jina-reranker-v2-base-multilingual"), WithAPIKey("key")},
+			response:     `{"results": [{"index": 1, "relevance_score": 0.9}, {"index": 2, "relevance_score": 0.2}]}`,
+			expectedPath: "/v1/rerank",
+			expectedBody: map[string]any{
+				"model": "jina-reranker-v2-base-multilingual", "query": "login button",
+				"documents": []any{documents[0], documents[1], documents[2]}, "top_n": float64(2),
+			},
+			expected: []types.RerankResult{{Index: 1, Score: 0.9}, {Index: 2, Score: 0.2}},
+		},
+		{
+			name:         "voyage",
+			opts:         []Option{WithProvider(Voyage), WithModel("rerank-2"), WithAPIKey("key")},
+			response:     `{"object": "list", "data": [{"index": 1, "relevance_score": 0.8}, {"index": 0, "relevance_score": 0.3}]}`,
+			expectedPath: "/v1/rerank",
+			expectedBody: map[string]any{
+				"model": "rerank-2", "query": "login button",
+				"documents": []any{documents[0], documents[1], documents[2]}, "top_k": float64(2),
+			},
+			expected: []types.RerankResult{{Index: 1, Score: 0.8}, {Index: 0, Score: 0.3}},
+		},
+		{
+			name: "generic with nested fields",
+			opts: []Option{
+				WithProvider(HTTP),
+				WithHTTPMapping(HTTPMapping{
+					QueryField: "inputs.query", DocumentsField: "inputs.texts",
+					ResultsField: "output.ranking", IndexField: "id", ScoreField: "score",
+				}),
+			},
+			// Unsorted and ignoring the number of results asked for
+			response:     `{"output": {"ranking": [{"id": 0, "score": 0.1}, {"id": 2, "score": 0.4}, {"id": 1, "score": 0.7}]}}`,
+			expectedPath: "/rerank",
+			expectedBody: map[string]any{
+				"inputs": map[string]any{"query": "login button", "texts": []any{documents[0], documents[1], documents[2]}},
+			},
+			expected: []types.RerankResult{{Index: 1, Score: 0.7}, {Index: 2, Score: 0.4}},
+		},
+		{
+			name: "generic with a list of scores",
+			opts: []Option{
+				WithProvider(HTTP),
+				WithHTTPMapping(HTTPMapping{QueryField: "query", DocumentsField: "passages", ResultsField: "scores"}),
+			},
+			response:     `{"scores": [0.2, 0.95, 0.5]}`,
+			expectedPath: "/rerank",
+			expectedBody: map[string]any{"query": "login button", "passages": []any{documents[0], documents[1], documents[2]}},
+			expected:     []types.RerankResult{{Index: 1, Score: 0.95}, {Index: 2, Score: 0.5}},
+		},
+		{
+			name: "generic with an index out of range",
+			opts: []Option{
+				WithProvider(HTTP),
+				WithHTTPMapping(HTTPMapping{QueryField: "query", DocumentsField: "documents", IndexField: "index", ScoreField: "score"}),
+			},
+			response:     `[{"index": 3, "score": 0.9}]`,
+			expectedPath: "/rerank",
+			expectedErr:  "rerank result index out of range: 3",
+		},
+		{
+			name:         "error status",
+			opts:         []Option{WithProvider(Jina), WithModel("jina-reranker-v2-base-multilingual"), WithAPIKey("key")},
+			response:     `{"detail": "invalid api key"}`,
+			status:       http.StatusUnauthorized,
+			expectedPath: "/v1/rerank",
+			expectedErr:  `rerank request failed with status 401: {"detail": "invalid api key"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var path, authorization, header string
+			var body map[string]any
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				path, authorization, header = r.URL.Path, r.Header.Get("Authorization"), r.Header.Get("X-Tenant")
+				_ = json.NewDecoder(r.Body).Decode(&body)
+				if tt.status != 0 {
+					w.WriteHeader(tt.status)
+				}
+				_, _ = w.Write([]byte(tt.response))
+			}))
+			defer server.Close()
+
+			baseURL := server.URL + "/v1"
+			if tt.expectedPath == "/rerank" {
+				baseURL = server.URL + "/rerank"
+			}
+			client, err := NewRerankerClient(append(tt.opts, WithBaseURL(baseURL), WithHeader("X-Tenant", "qa"))...)
+			assert.NoError(t, err)
+
+			results, err := client.Rerank(
+				context.Background(), &types.RerankRequest{Query: "login button", Documents: documents, TopN: 2},
+			)
+			assert.Equal(t, tt.expectedPath, path)
+			assert.Equal(t, "qa", header)
+			if tt.expectedErr != "" {
+				assert.EqualError(t, err, tt.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, results)
+			assert.Equal(t, tt.expectedBody, body)
+			if tt.name == "jina" || tt.name == "voyage" {
+				assert.Equal(t, "Bearer key", authorization)
+			} else {
+				assert.Empty(t, authorization)
+			}
+		})
+	}
+}
+
+func TestNewRerankerClient_HTTPValidation(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        []Option
+		expectedErr string
+	}{
+		{
+			name:        "missing base url",
+			opts:        []Option{WithProvider(HTTP), WithHTTPMapping(HTTPMapping{QueryField: "q", DocumentsField: "d"})},
+			expectedErr: "base url of the http reranker is required",
+		},
+		{
+			name:        "missing mapping",
+			opts:        []Option{WithProvider(HTTP), WithBaseURL("http://localhost:8080/rerank")},
+			expectedErr: "http mapping of the http reranker is required",
+		},
+		{
+			name: "incomplete mapping",
+			opts: []Option{
+				WithProvider(HTTP), WithBaseURL("http://localhost:8080/rerank"), WithHTTPMapping(HTTPMapping{QueryField: "q"}),
+			},
+			expectedErr: "query and documents fields of the http mapping are required",
+		},
+		{
+			name:        "jina without model",
+			opts:        []Option{WithProvider(Jina), WithAPIKey("key")},
+			expectedErr: "reranker model is required",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewRerankerClient(tt.opts...)
+			assert.EqualError(t, err, tt.expectedErr)
+		})
+	}
+}
@@ -0,0 +1,233 @@
+package reranker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/vertexcover-io/locatr/pkg/internal/utils"
+	"github.com/vertexcover-io/locatr/pkg/logging"
+	"github.com/vertexcover-io/locatr/pkg/types"
+)
+
+// FusionMethod is the way a hybrid reranker combines the rankings of its sources.
+type FusionMethod string
+
+const (
+	// ReciprocalRankFusion scores each document by the sum of weight / (k + rank) over the sources,
+	// where rank starts at 1. It ignores the scale of the scores of the sources.
+	ReciprocalRankFusion FusionMethod = "rrf"
+	// WeightedScoreFusion scores each document by the weighted sum of its scores, once min-max normalized
+	// per source so that scores of different scales can be combined.
+	WeightedScoreFusion FusionMethod = "weighted"
+)
+
+// defaultRRFConstant dampens the weight of the top ranks in reciprocal rank fusion, as in the original paper.
+const defaultRRFConstant = 60
+
+// Source is a reranker whose ranking is fused by a hybrid reranker.
+type Source struct {
+	// Name of the source, used in the logs. Defaults to "source-<position>"
+	Name string
+	// Client ranking the documents
+	Client types.RerankerClientInterface
+	// Weight of the ranking of the source in the fusion. Defaults to 1
+	Weight float64
+}
+
+type hybridConfig struct {
+	fusion      FusionMethod
+	rrfConstant float64
+	logger      *slog.Logger
+}
+
+type HybridOption func(*hybridConfig)
+
+// WithFusion sets the way the rankings of the sources are combined. Defaults to ReciprocalRankFusion.
+func WithFusion(fusion FusionMethod) HybridOption {
+	return func(c *hybridConfig) {
+		c.fusion = fusion
+	}
+}
+
+// WithRRFConstant sets the constant k of reciprocal rank fusion. Defaults to 60.
+func WithRRFConstant(k float64) HybridOption {
+	return func(c *hybridConfig) {
+		c.rrfConstant = k
+	}
+}
+
+// WithHybridLogger sets the logger for the hybrid reranker, which logs the rank of each result in every source
+// at info level, to tune the weights of the sources.
+func WithHybridLogger(logger *slog.Logger) HybridOption {
+	return func(c *hybridConfig) {
+		c.logger = logger
+	}
+}
+
+// hybridClient is a reranker running several rerankers, e.g. BM25 and Cohere, and fusing their rankings,
+// so that the exact text matches found by a lexical reranker aren't buried by a neural one.
+type hybridClient struct {
+	sources []Source
+	config  *hybridConfig
+}
+
+// NewHybridRerankerClient creates a new reranker fusing the rankings of the given sources.
+// The sources are queried concurrently. A failing source is left out of the fusion,
+// and the rerank request fails only if all of them fail.
+//
+// Parameters:
+//   - sources: The rerankers whose rankings are fused
+//   - opts: Configuration options for the hybrid reranker
+//
+// Returns:
+//   - *hybridClient: Configured client instance
+//   - error: If no source is given, a source has no client or a negative weight, or the fusion is invalid
+func NewHybridRerankerClient(sources []Source, opts ...HybridOption) (*hybridClient, error) {
+	if len(sources) == 0 {
+		return nil, errors.New("at least one source is required")
+	}
+	cfg := &hybridConfig{fusion: ReciprocalRankFusion, rrfConstant: defaultRRFConstant}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if cfg.fusion != ReciprocalRankFusion && cfg.fusion != WeightedScoreFusion {
+		return nil, fmt.Errorf("invalid fusion method: %q", cfg.fusion)
+	}
+	if cfg.rrfConstant < 0 {
+		return nil, fmt.Errorf("invalid rrf constant: %v", cfg.rrfConstant)
+	}
+	if cfg.logger == nil {
+		cfg.logger = logging.DefaultLogger
+	}
+
+	names := make(map[string]bool, len(sources))
+	fused := make([]Source, len(sources))
+	for i, source := range sources {
+		if source.Client == nil {
+			return nil, fmt.Errorf("client of source %d is required", i)
+		}
+		if source.Weight < 0 {
+			return nil, fmt.Errorf("invalid weight of source %d: %v", i, source.Weight)
+		}
+		if source.Weight == 0 {
+			source.Weight = 1
+		}
+		if source.Name == "" {
+			source.Name = fmt.Sprintf("source-%d", i)
+		}
+		if names[source.Name] {
+			return nil, fmt.Errorf("duplicate source name: %q", source.Name)
+		}
+		names[source.Name] = true
+		fused[i] = source
+	}
+	return &hybridClient{sources: fused, config: cfg}, nil
+}
+
+// Rerank ranks all the documents with every source and returns the TopN documents of the fused ranking.
+func (client *hybridClient) Rerank(ctx context.Context, request *types.RerankRequest) ([]types.RerankResult, error) {
+	topic := fmt.Sprintf("[Reranker] hybrid: %v sources, fusion: %v", len(client.sources), client.config.fusion)
+	defer logging.CreateTopic(topic, client.config.logger)()
+
+	if len(request.Documents) == 0 {
+		return []types.RerankResult{}, nil
+	}
+
+	// Every source ranks all the documents, so that a document missing from a ranking is a miss of the source
+	sourceRequest := &types.RerankRequest{
+		Query: request.Query, Documents: request.Documents, TopN: len(request.Documents),
+	}
+	rankings := make([][]types.RerankResult, len(client.sources))
+	errs := make([]error, len(client.sources))
+	var wg sync.WaitGroup
+	for i, source := range client.sources {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rankings[i], errs[i] = source.Client.Rerank(ctx, sourceRequest)
+		}()
+	}
+	wg.Wait()
+
+	failures := []error{}
+	for i, err := range errs {
+		if err != nil {
+			client.config.logger.Warn(
+				"source of the hybrid reranker failed", "source", client.sources[i].Name, "error", err,
+			)
+			failures = append(failures, fmt.Errorf("%s: %w", client.sources[i].Name, err))
+			rankings[i] = nil
+		}
+	}
+	if len(failures) == len(client.sources) {
+		return nil, fmt.Errorf("all sources of the hybrid reranker failed: %w", errors.Join(failures...))
+	}
+
+	// ranks[i][j] is the rank of document j in source i, starting at 1, zero if the source left it out
+	ranks := make([][]int, len(client.sources))
+	scores := make([]float64, len(request.Documents))
+	for i, ranking := range rankings {
+		ranks[i] = make([]int, len(request.Documents))
+		if ranking == nil {
+			continue
+		}
+		weight := client.sources[i].Weight
+		switch client.config.fusion {
+		case ReciprocalRankFusion:
+			for rank, result := range ranking {
+				if result.Index < 0 || result.Index >= len(request.Documents) || ranks[i][result.Index] != 0 {
+					continue
+				}
+				ranks[i][result.Index] = rank + 1
+				scores[result.Index] += weight / (client.config.rrfConstant + float64(rank+1))
+			}
+		case WeightedScoreFusion:
+			normalized := normalizeScores(ranking)
+			for rank, result := range ranking {
+				if result.Index < 0 || result.Index >= len(request.Documents) || ranks[i][result.Index] != 0 {
+					continue
+				}
+				ranks[i][result.Index] = rank + 1
+				scores[result.Index] += weight * normalized[rank]
+			}
+		}
+	}
+
+	results := make([]types.RerankResult, len(request.Documents))
+	for index, score := range scores {
+		results[index] = types.RerankResult{Index: index, Score: score}
+	}
+	results = utils.TopRerankResults(results, request.TopN)
+
+	for position, result := range results {
+		attrs := []any{"position", position + 1, "index", result.Index, "score", result.Score}
+		for i, source := range client.sources {
+			attrs = append(attrs, slog.Int(source.Name+"_rank", ranks[i][result.Index]))
+		}
+		client.config.logger.Info("hybrid rerank result", attrs...)
+	}
+	return results, nil
+}
+
+// normalizeScores scales the scores of a ranking into [0, 1]. All the scores are 1 if they are equal.
+func normalizeScores(ranking []types.RerankResult) []float64 {
+	normalized := make([]float64, len(ranking))
+	if len(ranking) == 0 {
+		return normalized
+	}
+	lowest, highest := ranking[0].Score, ranking[0].Score
+	for _, result := range ranking {
+		lowest, highest = min(lowest, result.Score), max(highest, result.Score)
+	}
+	for i, result := range ranking {
+		if highest == lowest {
+			normalized[i] = 1
+		} else {
+			normalized[i] = (result.Score - lowest) / (highest - lowest)
+		}
+	}
+	return normalized
+}
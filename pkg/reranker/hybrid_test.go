@@ -0,0 +1,188 @@
+package reranker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vertexcover-io/locatr/pkg/types"
+)
+
+// stubReranker returns a fixed ranking, or error, and records the requests.
+type stubReranker struct {
+	mu       sync.Mutex
+	ranking  []types.RerankResult
+	err      error
+	requests []*types.RerankRequest
+}
+
+func (s *stubReranker) Rerank(ctx context.Context, request *types.RerankRequest) ([]types.RerankResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, request)
+	return s.ranking, s.err
+}
+
+func TestHybridReranker(t *testing.T) {
+	documents := []string{`<div>Cart summary</div>`, `<a>Continue shopping</a>`, `<button>Checkout</button>`}
+	lexical := []types.RerankResult{{Index: 2, Score: 10}, {Index: 0, Score: 5}, {Index: 1, Score: 0}}
+	neural := []types.RerankResult{{Index: 0, Score: 0.9}, {Index: 1, Score: 0.5}, {Index: 2, Score: 0.1}}
+
+	tests := []struct {
+		name        string
+		sources     []Source
+		opts        []HybridOption
+		topN        int
+		expected    []types.RerankResult
+		expectedErr string
+	}{
+		{
+			name: "reciprocal rank fusion",
+			sources: []Source{
+				{Name: "bm25", Client: &stubReranker{ranking: lexical}},
+				{Name: "cohere", Client: &stubReranker{ranking: neural}},
+			},
+			topN: 2,
+			expected: []types.RerankResult{
+				{Index: 0, Score: 1.0/62 + 1.0/61}, {Index: 2, Score: 1.0/61 + 1.0/63},
+			},
+		},
+		{
+			name: "weighted reciprocal rank fusion",
+			sources: []Source{
+				{Name: "bm25", Client: &stubReranker{ranking: lexical}, Weight: 2},
+				{Name: "cohere", Client: &stubReranker{ranking: neural}},
+			},
+			opts: []HybridOption{WithRRFConstant(1)},
+			expected: []types.RerankResult{
+				{Index: 2, Score: 2.0/2 + 1.0/4}, {Index: 0, Score: 2.0/3 + 1.0/2}, {Index: 1, Score: 2.0/4 + 1.0/3},
+			},
+		},
+		{
+			name: "weighted score fusion",
+			sources: []Source{
+				{Name: "bm25", Client: &stubReranker{ranking: lexical}, Weight: 3},
+				{Name: "cohere", Client: &stubReranker{ranking: neural}},
+			},
+			opts:     []HybridOption{WithFusion(WeightedScoreFusion)},
+			expected: []types.RerankResult{{Index: 2, Score: 3}, {Index: 0, Score: 2.5}, {Index: 1, Score: 0.5}},
+		},
+		{
+			name: "failing source is left out",
+			sources: []Source{
+				{Name: "bm25", Client: &stubReranker{ranking: lexical}},
+				{Name: "cohere", Client: &stubReranker{err: errors.New("rate limited")}},
+			},
+			topN:     1,
+			expected: []types.RerankResult{{Index: 2, Score: 1.0 / 61}},
+		},
+		{
+			name: "all sources failing",
+			sources: []Source{
+				{Name: "bm25", Client: &stubReranker{err: errors.New("boom")}},
+				{Name: "cohere", Client: &stubReranker{err: errors.New("rate limited")}},
+			},
+			expectedErr: "all sources of the hybrid reranker failed: bm25: boom\ncohere: rate limited",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			logger := slog.New(slog.NewJSONHandler(&logs, nil))
+			client, err := NewHybridRerankerClient(tt.sources, append(tt.opts, WithHybridLogger(logger))...)
+			assert.NoError(t, err)
+
+			results, err := client.Rerank(
+				context.Background(), &types.RerankRequest{Query: "checkout", Documents: documents, TopN: tt.topN},
+			)
+			for _, source := range tt.sources {
+				stub := source.Client.(*stubReranker)
+				assert.Len(t, stub.requests, 1)
+				assert.Equal(t, len(documents), stub.requests[0].TopN)
+			}
+			if tt.expectedErr != "" {
+				assert.EqualError(t, err, tt.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Len(t, results, len(tt.expected))
+			for i, expected := range tt.expected {
+				assert.Equal(t, expected.Index, results[i].Index)
+				assert.InDelta(t, expected.Score, results[i].Score, 1e-9)
+			}
+			assert.Contains(t, logs.String(), `"bm25_rank":`)
+			assert.Contains(t, logs.String(), `"cohere_rank":`)
+		})
+	}
+}
+
+func TestHybridReranker_LogsSourceRanks(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, nil))
+	bm25, err := NewRerankerClient(WithProvider(BM25), WithLogger(logger))
+	assert.NoError(t, err)
+	neural := &stubReranker{ranking: []types.RerankResult{{Index: 0, Score: 0.9}, {Index: 1, Score: 0.2}}}
+	client, err := NewHybridRerankerClient(
+		[]Source{{Name: "bm25", Client: bm25, Weight: 2}, {Name: "cohere", Client: neural}}, WithHybridLogger(logger),
+	)
+	assert.NoError(t, err)
+
+	results, err := client.Rerank(context.Background(), &types.RerankRequest{
+		Query: "checkout", Documents: []string{`<div>Your cart</div>`, `<button>Checkout</button>`}, TopN: 1,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+
+	var line string
+	for _, l := range strings.Split(logs.String(), "\n") {
+		if strings.Contains(l, "hybrid rerank result") {
+			line = l
+		}
+	}
+	// The exact match buried by the neural reranker is brought back by the weight of BM25
+	assert.Contains(t, line, `"bm25_rank":1`)
+	assert.Contains(t, line, `"cohere_rank":2`)
+	assert.Contains(t, line, `"index":1`)
+}
+
+func TestNewHybridRerankerClient_Validation(t *testing.T) {
+	stub := &stubReranker{}
+	tests := []struct {
+		name        string
+		sources     []Source
+		opts        []HybridOption
+		expectedErr string
+	}{
+		{name: "no sources", expectedErr: "at least one source is required"},
+		{name: "missing client", sources: []Source{{Name: "bm25"}}, expectedErr: "client of source 0 is required"},
+		{
+			name:        "negative weight",
+			sources:     []Source{{Client: stub, Weight: -1}},
+			expectedErr: "invalid weight of source 0: -1",
+		},
+		{
+			name:        "duplicate names",
+			sources:     []Source{{Client: stub}, {Name: "source-0", Client: stub}},
+			expectedErr: `duplicate source name: "source-0"`,
+		},
+		{
+			name:        "invalid fusion",
+			sources:     []Source{{Client: stub}},
+			opts:        []HybridOption{WithFusion("borda")},
+			expectedErr: `invalid fusion method: "borda"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewHybridRerankerClient(tt.sources, tt.opts...)
+			assert.EqualError(t, err, tt.expectedErr)
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package reranker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/vertexcover-io/locatr/pkg/internal/utils"
+	"github.com/vertexcover-io/locatr/pkg/types"
+)
+
+// maxSummaryLength is the maximum number of characters of the summary of a chunk sent to the LLM.
+const maxSummaryLength = 300
+
+// LLM_RERANK_PROMPT_TEMPLATE defines the prompt scoring the summaries of a batch of chunks against the user request.
+const LLM_RERANK_PROMPT_TEMPLATE string = `Your task is to score how likely each chunk of a web page is to contain the element described by a user's request.
+
+Each chunk is summarized by its text and the values of its attributes, and is preceded by its index in square brackets.
+Score every chunk between 0 and 1, 1 meaning the chunk certainly contains the element and 0 that it certainly doesn't.
+
+Provide your response in valid JSON format with the following structure:
+{
+  "scores": [
+    {
+      "index": int,    // The index of the chunk.
+      "score": float   // The score of the chunk, between 0 and 1.
+    }
+  ]
+}
+
+Input:
+User request: %s
+
+Chunks:
+%s
+`
+
+// llmRerankOutputSchema is the JSON schema of the output of the LLM rerank prompt.
+var llmRerankOutputSchema = &types.JSONSchema{
+	Name:        "llm_rerank_output",
+	Description: "The score of each chunk for the user's request",
+	Schema: map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"scores": map[string]any{
+				"type": "array",
+				"items": map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"index": map[string]any{
+							"type":        "integer",
+							"description": "The index of the chunk",
+						},
+						"score": map[string]any{
+							"type":        "number",
+							"description": "How likely the chunk is to contain the element, between 0 and 1",
+						},
+					},
+					"required":             []string{"index", "score"},
+					"additionalProperties": false,
+				},
+			},
+		},
+		"required":             []string{"scores"},
+		"additionalProperties": false,
+	},
+}
+
+// summarizeChunk summarizes an HTML chunk by its text and attribute values, without the markup,
+// truncated to maxSummaryLength characters. The id attributes generated by locatr are left out.
+func summarizeChunk(document string) string {
+	parts := []string{}
+	last := 0
+	for _, tag := range htmlTagPattern.FindAllStringIndex(document, -1) {
+		parts = append(parts, document[last:tag[0]])
+		for _, attribute := range htmlAttributePattern.FindAllStringSubmatch(document[tag[0]:tag[1]], -1) {
+			if !ignoredAttributes[strings.ToLower(attribute[1])] {
+				parts = append(parts, attribute[2]+attribute[3])
+			}
+		}
+		last = tag[1]
+	}
+	parts = append(parts, document[last:])
+
+	summary := strings.Join(strings.Fields(strings.Join(parts, " ")), " ")
+	if runes := []rune(summary); len(runes) > maxSummaryLength {
+		summary = string(runes[:maxSummaryLength]) + "…"
+	}
+	return summary
+}
+
+// llmReranker scores the chunks with a language model, for providers without a rerank API.
+type llmReranker struct {
+	client    types.LLMClientInterface
+	batchSize int
+	logger    *slog.Logger
+}
+
+// rerank scores the summaries of the documents against the query, one completion per batch of documents.
+// Documents left out of the completions get a zero score.
+//
+// Parameters:
+//   - request: Contains the query and documents to rerank
+//
+// Returns:
+//   - []RerankResult: The TopN documents sorted by decreasing score, all of them if TopN isn't set
+//   - error: If a completion fails or isn't valid JSON
+func (r *llmReranker) rerank(ctx context.Context, request *types.RerankRequest) ([]types.RerankResult, error) {
+	results := make([]types.RerankResult, len(request.Documents))
+	for i := range results {
+		results[i] = types.RerankResult{Index: i}
+	}
+
+	for offset := 0; offset < len(request.Documents); offset += r.batchSize {
+		batch := request.Documents[offset:min(offset+r.batchSize, len(request.Documents))]
+		scores, err := r.scoreBatch(ctx, request.Query, batch)
+		if err != nil {
+			return nil, err
+		}
+		for i, score := range scores {
+			results[offset+i].Score = score
+		}
+	}
+	return utils.TopRerankResults(results, request.TopN), nil
+}
+
+// scoreBatch returns the scores of a batch of documents, in the order of the documents.
+func (r *llmReranker) scoreBatch(ctx context.Context, query string, documents []string) ([]float64, error) {
+	chunks := make([]string, len(documents))
+	for i, document := range documents {
+		chunks[i] = fmt.Sprintf("[%d] %s", i, summarizeChunk(document))
+	}
+	prompt := fmt.Sprintf(LLM_RERANK_PROMPT_TEMPLATE, query, strings.Join(chunks, "\n"))
+
+	completion, err := r.client.GetStructuredCompletion(ctx, prompt, nil, llmRerankOutputSchema)
+	if completion != nil {
+		types.AddRerankUsage(ctx, completion.LLMCompletionMeta)
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.logger.Debug(
+		"llm rerank completion", "documents", len(documents),
+		"input_tokens", completion.InputTokens, "output_tokens", completion.OutputTokens,
+	)
+
+	var output struct {
+		Scores []struct {
+			Index int     `json:"index"`
+			Score float64 `json:"score"`
+		} `json:"scores"`
+	}
+	if err := json.Unmarshal([]byte(completion.JSON), &output); err != nil {
+		return nil, fmt.Errorf("couldn't decode llm rerank response: %w", err)
+	}
+
+	scores := make([]float64, len(documents))
+	scored := make([]bool, len(documents))
+	for _, item := range output.Scores {
+		// Scores of unknown or already scored chunks are ignored, the first one wins
+		if item.Index < 0 || item.Index >= len(documents) || scored[item.Index] {
+			continue
+		}
+		scores[item.Index] = min(max(item.Score, 0), 1)
+		scored[item.Index] = true
+	}
+	return scores, nil
+}
@@ -0,0 +1,143 @@
+package reranker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vertexcover-io/locatr/pkg/types"
+)
+
+// stubLLMClient scores the chunks of the prompt by the presence of a keyword and records the prompts.
+// A fixed response is returned instead if set.
+type stubLLMClient struct {
+	keyword  string
+	response string
+	prompts  []string
+}
+
+var chunkLinePattern = regexp.MustCompile(`(?m)^\[(\d+)\] (.*)$`)
+
+func (c *stubLLMClient) GetProvider() types.LLMProvider { return "stub" }
+
+func (c *stubLLMClient) GetModel() string { return "stub-mini" }
+
+func (c *stubLLMClient) GetJSONCompletion(ctx context.Context, prompt string, image []byte) (*types.JSONCompletion, error) {
+	return c.GetStructuredCompletion(ctx, prompt, image, nil)
+}
+
+func (c *stubLLMClient) GetStructuredCompletion(
+	ctx context.Context, prompt string, image []byte, schema *types.JSONSchema,
+) (*types.JSONCompletion, error) {
+	c.prompts = append(c.prompts, prompt)
+	if c.response != "" {
+		return &types.JSONCompletion{JSON: c.response}, nil
+	}
+	scores := []map[string]any{}
+	for _, match := range chunkLinePattern.FindAllStringSubmatch(prompt, -1) {
+		score := 0.1
+		if strings.Contains(strings.ToLower(match[2]), c.keyword) {
+			score = 0.9
+		}
+		var index int
+		_, _ = fmt.Sscan(match[1], &index)
+		scores = append(scores, map[string]any{"index": index, "score": score})
+	}
+	output, _ := json.Marshal(map[string]any{"scores": scores})
+	return &types.JSONCompletion{
+		JSON: string(output),
+		LLMCompletionMeta: types.LLMCompletionMeta{
+			Provider: c.GetProvider(), Model: c.GetModel(), InputTokens: 100, OutputTokens: 10,
+		},
+	}, nil
+}
+
+func TestLLMReranker(t *testing.T) {
+	documents := []string{
+		`<div id="1">Cart</div>`,
+		`<a id="2" href="/help">Help</a>`,
+		`<p id="3">Total</p>`,
+		`<button id="4" aria-label="Checkout">Pay</button>`,
+		`<footer id="5">Footer</footer>`,
+	}
+	llmClient := &stubLLMClient{keyword: "checkout"}
+	client, err := NewRerankerClient(WithProvider(LLM), WithLLMClient(llmClient), WithLLMBatchSize(2))
+	assert.NoError(t, err)
+	assert.Equal(t, "stub-mini", client.config.model)
+
+	usage := &types.LLMCompletionMeta{}
+	results, err := client.Rerank(
+		types.WithRerankUsage(context.Background(), usage),
+		&types.RerankRequest{Query: "checkout button", Documents: documents, TopN: 2},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, []types.RerankResult{{Index: 3, Score: 0.9}, {Index: 0, Score: 0.1}}, results)
+	assert.Equal(t, &types.LLMCompletionMeta{
+		Provider: "stub", Model: "stub-mini", InputTokens: 300, OutputTokens: 30,
+	}, usage)
+
+	// The documents are scored in batches, each indexed from zero
+	assert.Len(t, llmClient.prompts, 3)
+	assert.Contains(t, llmClient.prompts[1], "[0] Total\n[1] Checkout Pay\n")
+	assert.Contains(t, llmClient.prompts[2], "[0] Footer\n")
+	assert.Contains(t, llmClient.prompts[0], "User request: checkout button")
+	assert.NotContains(t, llmClient.prompts[0], "<div")
+}
+
+func TestLLMReranker_Responses(t *testing.T) {
+	documents := []string{"<p>a</p>", "<p>b</p>", "<p>c</p>"}
+	tests := []struct {
+		name        string
+		response    string
+		expected    []types.RerankResult
+		expectedErr string
+	}{
+		{
+			name:     "unscored, unknown and duplicate chunks",
+			response: `{"scores": [{"index": 2, "score": 1.7}, {"index": 7, "score": 1}, {"index": 2, "score": 0}]}`,
+			expected: []types.RerankResult{{Index: 2, Score: 1}, {Index: 0, Score: 0}, {Index: 1, Score: 0}},
+		},
+		{
+			name:        "invalid JSON",
+			response:    `scores: none`,
+			expectedErr: "couldn't decode llm rerank response",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewRerankerClient(WithProvider(LLM), WithLLMClient(&stubLLMClient{response: tt.response}))
+			assert.NoError(t, err)
+			results, err := client.Rerank(context.Background(), &types.RerankRequest{Query: "a", Documents: documents})
+			if tt.expectedErr != "" {
+				assert.ErrorContains(t, err, tt.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, results)
+		})
+	}
+}
+
+func TestSummarizeChunk(t *testing.T) {
+	assert.Equal(
+		t,
+		"Email email Enter your email Sign in",
+		summarizeChunk(`<div id="12"><label>Email</label>
+			<input id="13" type="email" placeholder='Enter your email'><button id="14">Sign  in</button></div>`),
+	)
+	summary := summarizeChunk("<p>" + strings.Repeat("long ", 100) + "</p>")
+	assert.Equal(t, maxSummaryLength+1, len([]rune(summary)))
+	assert.True(t, strings.HasSuffix(summary, "…"))
+}
+
+func TestNewRerankerClient_LLMValidation(t *testing.T) {
+	_, err := NewRerankerClient(WithProvider(LLM))
+	assert.EqualError(t, err, "llm client of the llm reranker is required")
+	_, err = NewRerankerClient(WithProvider(LLM), WithLLMClient(&stubLLMClient{}), WithLLMBatchSize(0))
+	assert.EqualError(t, err, "invalid llm batch size: 0")
+}
@@ -0,0 +1,119 @@
+package reranker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/vertexcover-io/locatr/pkg/types"
+)
+
+// Middleware wraps a rerank handler, e.g. to log, mutate or cache rerank requests.
+type Middleware func(next Handler) Handler
+
+// requestModelKey is the context key of the provider and model of the client a rerank is requested from.
+type requestModelKey struct{}
+
+// requestModel is the provider and model of the client a rerank is requested from, set on the context
+// of the middlewares so that a middleware shared by several clients can tell their requests apart.
+type requestModel struct {
+	provider types.RerankerProvider
+	model    string
+}
+
+// chain wraps the handler with the middlewares, the first middleware being the outermost.
+func chain(handler Handler, middlewares []Middleware) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// LoggingMiddleware logs the duration, size and error of every rerank request.
+//
+// Parameters:
+//   - logger: The logger to log the requests with
+//
+// Returns the logging middleware.
+func LoggingMiddleware(logger *slog.Logger) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, request *types.RerankRequest) ([]types.RerankResult, error) {
+			start := time.Now()
+			results, err := next(ctx, request)
+			attrs := []any{
+				"duration", time.Since(start).String(), "documents", len(request.Documents),
+				"top_n", request.TopN, "results", len(results),
+			}
+			if err != nil {
+				logger.Error("rerank failed", append(attrs, "error", err)...)
+			} else {
+				logger.Info("rerank", attrs...)
+			}
+			return results, err
+		}
+	}
+}
+
+// CacheMiddleware keeps the successful rerank results in memory and returns them for identical requests
+// to the same provider and model. The options of the context forwarded to the model of the LLM reranker,
+// its image detail and prompt cache prefixes (see types.WithImageDetail and types.WithPromptCachePrefixes),
+// are part of the request.
+// The oldest results are evicted when the cache is full.
+//
+// Parameters:
+//   - maxEntries: The maximum number of cached results, zero for no limit
+//
+// Returns the cache middleware, which can be shared by several clients.
+func CacheMiddleware(maxEntries int) Middleware {
+	var mu sync.Mutex
+	entries := map[string][]types.RerankResult{}
+	keys := []string{}
+
+	return func(next Handler) Handler {
+		return func(ctx context.Context, request *types.RerankRequest) ([]types.RerankResult, error) {
+			model, _ := ctx.Value(requestModelKey{}).(requestModel)
+			prefixes := types.PromptCachePrefixes(ctx)
+			hash := sha256.New()
+			fmt.Fprintf(
+				hash, "%s\x00%s\x00%s\x00%d\x00",
+				model.provider, model.model, types.ContextImageDetail(ctx), len(prefixes),
+			)
+			for _, prefix := range prefixes {
+				fmt.Fprintf(hash, "%d:%s\x00", len(prefix), prefix)
+			}
+			fmt.Fprintf(hash, "%d\x00%s", request.TopN, request.Query)
+			for _, document := range request.Documents {
+				fmt.Fprintf(hash, "\x00%d:%s", len(document), document)
+			}
+			key := hex.EncodeToString(hash.Sum(nil))
+
+			mu.Lock()
+			cached, ok := entries[key]
+			mu.Unlock()
+			if ok {
+				return slices.Clone(cached), nil
+			}
+
+			results, err := next(ctx, request)
+			if err != nil {
+				return results, err
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if _, ok := entries[key]; !ok {
+				if maxEntries > 0 && len(keys) >= maxEntries {
+					delete(entries, keys[0])
+					keys = keys[1:]
+				}
+				keys = append(keys, key)
+			}
+			entries[key] = slices.Clone(results)
+			return results, nil
+		}
+	}
+}
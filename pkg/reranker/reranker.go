@@ -6,10 +6,17 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	cohere "github.com/cohere-ai/cohere-go/v2"
 	cohereclient "github.com/cohere-ai/cohere-go/v2/client"
+	"github.com/openai/openai-go"
+	openaiOption "github.com/openai/openai-go/option"
+	"github.com/vertexcover-io/locatr/pkg/internal/constants"
+	"github.com/vertexcover-io/locatr/pkg/internal/httpclient"
 	"github.com/vertexcover-io/locatr/pkg/logging"
 	"github.com/vertexcover-io/locatr/pkg/types"
 )
@@ -17,13 +24,58 @@ import (
 // RerankerProvider constants define the supported Reranker service providers
 const (
 	Cohere types.RerankerProvider = "cohere"
+	// BM25 is an offline lexical reranker scoring the text and attribute values of the chunks with Okapi BM25.
+	// It requires neither a model nor an API key.
+	BM25 types.RerankerProvider = "bm25"
+	// OpenAIEmbeddings ranks the chunks by the cosine similarity of their embeddings with the embedding of the query.
+	// Any OpenAI-compatible /embeddings endpoint can be set with WithBaseURL, e.g. "http://localhost:11434/v1" for Ollama.
+	OpenAIEmbeddings types.RerankerProvider = "openai-embeddings"
+	// Jina uses Jina's rerank API, e.g. with the "jina-reranker-v2-base-multilingual" model.
+	Jina types.RerankerProvider = "jina"
+	// Voyage uses Voyage's rerank API, e.g. with the "rerank-2" model.
+	Voyage types.RerankerProvider = "voyage"
+	// HTTP sends the rerank requests to the endpoint set with WithBaseURL, e.g. a self-hosted cross-encoder service.
+	// The JSON request and response are described by WithHTTPMapping, and the model is optional.
+	HTTP types.RerankerProvider = "http"
+	// LLM scores summaries of the chunks with the language model set with WithLLMClient, for providers without
+	// a rerank API. A cheap model is enough, the model of the reranker being the one of the LLM client.
+	LLM types.RerankerProvider = "llm"
 )
 
+// defaultBaseURLs are the base URLs of the hosted rerank APIs, to which "/rerank" is appended.
+var defaultBaseURLs = map[types.RerankerProvider]string{
+	Jina:   "https://api.jina.ai/v1",
+	Voyage: "https://api.voyageai.com/v1",
+}
+
 type config struct {
 	provider types.RerankerProvider
 	model    string
 	apiKey   string
-	logger   *slog.Logger
+	baseURL  string
+	// http configures the HTTP client used to reach the provider
+	http httpclient.Config
+	// middlewares wrap every rerank request, the first one being the outermost
+	middlewares []Middleware
+	// bm25K1 and bm25B are the parameters of the BM25 provider
+	bm25K1 float64
+	bm25B  float64
+	// embeddingCacheSize is the maximum number of embeddings kept in memory by the OpenAIEmbeddings provider
+	embeddingCacheSize int
+	// httpMapping describes the JSON request and response of the HTTP provider
+	httpMapping *HTTPMapping
+	// headers are set on the requests of the Jina, Voyage and HTTP providers
+	headers map[string]string
+	// llmClient and llmBatchSize configure the LLM provider
+	llmClient    types.LLMClientInterface
+	llmBatchSize int
+	// maxDocuments and maxDocumentTokens override the limits of the provider, see providerLimits
+	maxDocuments      int
+	maxDocumentTokens int
+	// concurrency is the maximum number of batches of a request ranked at the same time
+	concurrency int
+	tokenizer   types.Tokenizer
+	logger      *slog.Logger
 }
 
 type Option func(*config)
@@ -49,6 +101,135 @@ func WithAPIKey(apiKey string) Option {
 	}
 }
 
+// WithBaseURL sets the base URL of the provider's API, overriding the provider default.
+// For the HTTP provider, it is the URL of the rerank endpoint itself.
+func WithBaseURL(baseURL string) Option {
+	return func(c *config) {
+		c.baseURL = baseURL
+	}
+}
+
+// WithHTTPClient sets the HTTP client used to reach the provider.
+// It takes precedence over WithTransport, WithProxy, WithCABundle and WithRequestTimeout.
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *config) {
+		c.http.Client = client
+	}
+}
+
+// WithTransport sets the transport of the HTTP client used to reach the provider.
+func WithTransport(transport http.RoundTripper) Option {
+	return func(c *config) {
+		c.http.Transport = transport
+	}
+}
+
+// WithProxy sets the URL of the proxy requests are sent through, e.g. "http://proxy.corp:3128".
+// Defaults to the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+func WithProxy(proxyURL string) Option {
+	return func(c *config) {
+		c.http.ProxyURL = proxyURL
+	}
+}
+
+// WithCABundle sets the path of a PEM file with additional root certificates to trust,
+// e.g. the certificate of a TLS-intercepting corporate proxy.
+func WithCABundle(path string) Option {
+	return func(c *config) {
+		c.http.CABundlePath = path
+	}
+}
+
+// WithRequestTimeout sets the timeout of each request made to the provider.
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(c *config) {
+		c.http.Timeout = timeout
+	}
+}
+
+// WithBM25Parameters sets the term frequency saturation k1 and the document length normalization b
+// of the BM25 provider. Defaults to 1.2 and 0.75.
+func WithBM25Parameters(k1, b float64) Option {
+	return func(c *config) {
+		c.bm25K1, c.bm25B = k1, b
+	}
+}
+
+// WithEmbeddingCacheSize sets the maximum number of embeddings kept in memory by the OpenAIEmbeddings provider,
+// zero for no limit. Defaults to constants.DEFAULT_EMBEDDING_CACHE_SIZE.
+func WithEmbeddingCacheSize(size int) Option {
+	return func(c *config) {
+		c.embeddingCacheSize = size
+	}
+}
+
+// WithHTTPMapping sets the mapping of the JSON request and response of the HTTP provider's endpoint.
+func WithHTTPMapping(mapping HTTPMapping) Option {
+	return func(c *config) {
+		c.httpMapping = &mapping
+	}
+}
+
+// WithHeader sets a header on the requests of the Jina, Voyage and HTTP providers, e.g. for authentication.
+// The API key, if any, is sent as a bearer token in the Authorization header.
+func WithHeader(key, value string) Option {
+	return func(c *config) {
+		if c.headers == nil {
+			c.headers = map[string]string{}
+		}
+		c.headers[key] = value
+	}
+}
+
+// WithLLMClient sets the language model client of the LLM provider.
+func WithLLMClient(client types.LLMClientInterface) Option {
+	return func(c *config) {
+		c.llmClient = client
+	}
+}
+
+// WithLLMBatchSize sets the maximum number of chunks scored by a single completion of the LLM provider.
+// Defaults to constants.DEFAULT_LLM_RERANK_BATCH_SIZE.
+func WithLLMBatchSize(size int) Option {
+	return func(c *config) {
+		c.llmBatchSize = size
+	}
+}
+
+// WithMaxDocuments sets the maximum number of documents sent in a single request to the provider.
+// Larger requests are split into batches ranked concurrently. Zero keeps the limit of the provider,
+// e.g. 1000 for Cohere, and a negative value disables it.
+func WithMaxDocuments(count int) Option {
+	return func(c *config) {
+		c.maxDocuments = count
+	}
+}
+
+// WithMaxDocumentTokens sets the maximum number of tokens of a document sent to the provider.
+// Larger documents are split into parts and scored by their best part. Zero keeps the limit of the provider,
+// e.g. 4096 for Cohere, and a negative value disables it.
+func WithMaxDocumentTokens(tokens int) Option {
+	return func(c *config) {
+		c.maxDocumentTokens = tokens
+	}
+}
+
+// WithConcurrency sets the maximum number of batches of a request ranked at the same time.
+// Defaults to constants.DEFAULT_RERANK_CONCURRENCY.
+func WithConcurrency(concurrency int) Option {
+	return func(c *config) {
+		c.concurrency = concurrency
+	}
+}
+
+// WithTokenizer sets the tokenizer estimating the number of tokens of the documents.
+// Defaults to types.HeuristicTokenizer.
+func WithTokenizer(tokenizer types.Tokenizer) Option {
+	return func(c *config) {
+		c.tokenizer = tokenizer
+	}
+}
+
 // WithLogger sets the logger for the reranker client.
 func WithLogger(logger *slog.Logger) Option {
 	return func(c *config) {
@@ -56,11 +237,23 @@ func WithLogger(logger *slog.Logger) Option {
 	}
 }
 
+// WithMiddleware adds middlewares wrapping every rerank request of the client.
+// The middlewares are applied in order, the first one being the outermost.
+func WithMiddleware(middlewares ...Middleware) Option {
+	return func(c *config) {
+		c.middlewares = append(c.middlewares, middlewares...)
+	}
+}
+
+// Handler reranks the documents of the given request.
+type Handler func(ctx context.Context, request *types.RerankRequest) ([]types.RerankResult, error)
+
 // rerankerClient wraps the rerank API client to implement the RerankerInterface.
 // It provides document reranking capabilities using the configured reranker provider.
 type rerankerClient struct {
-	config  *config
-	handler func(ctx context.Context, request *types.RerankRequest) ([]types.RerankResult, error)
+	config *config
+	// handler sends the rerank request to the provider, wrapped by the middlewares
+	handler Handler
 }
 
 // NewRerankerClient creates a new instance of the reranker client.
@@ -73,40 +266,144 @@ type rerankerClient struct {
 //   - error: Any initialization errors
 func NewRerankerClient(opts ...Option) (*rerankerClient, error) {
 
-	cfg := &config{}
+	cfg := &config{
+		bm25K1: defaultBM25K1, bm25B: defaultBM25B, embeddingCacheSize: constants.DEFAULT_EMBEDDING_CACHE_SIZE,
+		llmBatchSize: constants.DEFAULT_LLM_RERANK_BATCH_SIZE, concurrency: constants.DEFAULT_RERANK_CONCURRENCY,
+	}
 	for _, opt := range opts {
 		opt(cfg)
 	}
 	if cfg.provider == "" {
 		return nil, errors.New("reranker provider is required")
 	}
-	if cfg.model == "" {
+	if cfg.provider == BM25 && cfg.model == "" {
+		cfg.model = "okapi-bm25"
+	}
+	if cfg.provider == LLM {
+		if cfg.llmClient == nil {
+			return nil, errors.New("llm client of the llm reranker is required")
+		}
+		cfg.model = cfg.llmClient.GetModel()
+	}
+	if cfg.model == "" && cfg.provider != HTTP {
 		return nil, errors.New("reranker model is required")
 	}
 	if cfg.logger == nil {
 		cfg.logger = logging.DefaultLogger
 	}
+	if cfg.tokenizer == nil {
+		cfg.tokenizer = types.HeuristicTokenizer{}
+	}
+	limits := providerLimits[cfg.provider]
+	if cfg.maxDocuments != 0 {
+		limits.maxDocuments = cfg.maxDocuments
+	}
+	if cfg.maxDocumentTokens != 0 {
+		limits.maxDocumentTokens = cfg.maxDocumentTokens
+	}
 
-	var handler func(ctx context.Context, request *types.RerankRequest) ([]types.RerankResult, error)
+	// The HTTP client and the provider clients are built once so that connections are reused
+	httpClient, err := httpclient.New(&cfg.http)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create http client: %w", err)
+	}
+
+	var handler Handler
 	switch cfg.provider {
 	case Cohere:
+		client := cohereclient.NewClient(
+			cohereclient.WithToken(cfg.apiKey), cohereclient.WithHTTPClient(httpClient),
+		)
 		handler = func(ctx context.Context, request *types.RerankRequest) ([]types.RerankResult, error) {
-			return requestCohere(
-				ctx,
-				cohereclient.NewClient(cohereclient.WithToken(cfg.apiKey)),
-				cfg.model,
-				request,
-			)
+			return requestCohere(ctx, client, cfg.model, request)
+		}
+	case OpenAIEmbeddings:
+		options := []openaiOption.RequestOption{
+			openaiOption.WithAPIKey(cfg.apiKey), openaiOption.WithHTTPClient(httpClient),
+		}
+		if cfg.baseURL != "" {
+			baseURL := cfg.baseURL
+			if !strings.HasSuffix(baseURL, "/") {
+				baseURL += "/"
+			}
+			options = append(options, openaiOption.WithBaseURL(baseURL))
+		}
+		client := openai.NewClient(options...)
+		cache := newEmbeddingCache(max(cfg.embeddingCacheSize, 0))
+		handler = func(ctx context.Context, request *types.RerankRequest) ([]types.RerankResult, error) {
+			return requestEmbeddings(ctx, client, cfg.model, cache, request)
+		}
+	case Jina, Voyage:
+		mapping := jinaMapping
+		if cfg.provider == Voyage {
+			mapping = voyageMapping
+		}
+		baseURL := cfg.baseURL
+		if baseURL == "" {
+			baseURL = defaultBaseURLs[cfg.provider]
+		}
+		reranker := &httpReranker{
+			client:  httpClient,
+			url:     strings.TrimSuffix(baseURL, "/") + "/rerank",
+			apiKey:  cfg.apiKey,
+			headers: cfg.headers,
+			mapping: mapping,
+		}
+		handler = func(ctx context.Context, request *types.RerankRequest) ([]types.RerankResult, error) {
+			return reranker.rerank(ctx, cfg.model, request)
+		}
+	case HTTP:
+		if cfg.baseURL == "" {
+			return nil, errors.New("base url of the http reranker is required")
+		}
+		if cfg.httpMapping == nil {
+			return nil, errors.New("http mapping of the http reranker is required")
+		}
+		if err := cfg.httpMapping.validate(); err != nil {
+			return nil, err
+		}
+		reranker := &httpReranker{
+			client:  httpClient,
+			url:     cfg.baseURL,
+			apiKey:  cfg.apiKey,
+			headers: cfg.headers,
+			mapping: *cfg.httpMapping,
+		}
+		handler = func(ctx context.Context, request *types.RerankRequest) ([]types.RerankResult, error) {
+			return reranker.rerank(ctx, cfg.model, request)
+		}
+	case LLM:
+		if cfg.llmBatchSize <= 0 {
+			return nil, fmt.Errorf("invalid llm batch size: %d", cfg.llmBatchSize)
+		}
+		reranker := &llmReranker{client: cfg.llmClient, batchSize: cfg.llmBatchSize, logger: cfg.logger}
+		handler = reranker.rerank
+	case BM25:
+		if cfg.bm25K1 < 0 || cfg.bm25B < 0 || cfg.bm25B > 1 {
+			return nil, fmt.Errorf("invalid bm25 parameters: k1=%v, b=%v", cfg.bm25K1, cfg.bm25B)
+		}
+		handler = func(ctx context.Context, request *types.RerankRequest) ([]types.RerankResult, error) {
+			return rankBM25(request, cfg.bm25K1, cfg.bm25B), nil
 		}
 	default:
 		return nil, errors.New("invalid provider for reranker")
 	}
-	return &rerankerClient{config: cfg, handler: handler}, nil
+	handler = chain(batchHandler(handler, limits, cfg.concurrency, cfg.tokenizer), cfg.middlewares)
+	model := requestModel{provider: cfg.provider, model: cfg.model}
+	if cfg.provider == LLM {
+		// The model of the reranker is the one of the LLM client
+		model.model = cfg.llmClient.GetModel()
+	}
+	return &rerankerClient{
+		config: cfg,
+		handler: func(ctx context.Context, request *types.RerankRequest) ([]types.RerankResult, error) {
+			return handler(context.WithValue(ctx, requestModelKey{}, model), request)
+		},
+	}, nil
 }
 
-var errDefaultRerankerAPIKeyNotSet = errors.New("'LOCATR_COHERE_API_KEY' or 'COHERE_API_KEY' environment variable is not set")
-
 // DefaultRerankerClient returns a default reranker client using Cohere's rerank-english-v3.0 model.
+// It falls back to the offline BM25 reranker when no Cohere API key is set.
 //
 // Parameters:
 //   - logger: Logger instance for logging
@@ -119,7 +416,14 @@ func DefaultRerankerClient(logger *slog.Logger) (*rerankerClient, error) {
 	if apiKey == "" {
 		apiKey = os.Getenv("COHERE_API_KEY")
 		if apiKey == "" {
-			return nil, errDefaultRerankerAPIKeyNotSet
+			if logger == nil {
+				logger = logging.DefaultLogger
+			}
+			logger.Warn(
+				"'LOCATR_COHERE_API_KEY' or 'COHERE_API_KEY' environment variable is not set, " +
+					"falling back to the offline BM25 reranker",
+			)
+			return NewRerankerClient(WithProvider(BM25), WithLogger(logger))
 		}
 	}
 	options := []Option{
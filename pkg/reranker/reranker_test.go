@@ -0,0 +1,132 @@
+package reranker
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vertexcover-io/locatr/pkg/types"
+)
+
+// stubTransport answers every request with the given body and records the requests.
+type stubTransport struct {
+	body     string
+	requests []*http.Request
+}
+
+func (t *stubTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	t.requests = append(t.requests, r)
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(t.body)),
+		Request:    r,
+	}, nil
+}
+
+func TestCohereReranker(t *testing.T) {
+	transport := &stubTransport{body: `{
+		"id": "rerank-1",
+		"results": [{"index": 1, "relevance_score": 0.92}, {"index": 0, "relevance_score": 0.15}],
+		"meta": {}
+	}`}
+	client, err := NewRerankerClient(
+		WithProvider(Cohere),
+		WithModel("rerank-english-v3.0"),
+		WithAPIKey("cohere-key"),
+		WithTransport(transport),
+	)
+	assert.NoError(t, err)
+
+	request := &types.RerankRequest{
+		Query: "login button", Documents: []string{"<div>footer</div>", "<button>Log in</button>"}, TopN: 2,
+	}
+	for range 2 {
+		results, err := client.Rerank(context.Background(), request)
+		assert.NoError(t, err)
+		assert.Equal(t, []types.RerankResult{{Index: 1, Score: 0.92}, {Index: 0, Score: 0.15}}, results)
+	}
+
+	assert.Len(t, transport.requests, 2)
+	assert.Equal(t, "Bearer cohere-key", transport.requests[0].Header.Get("Authorization"))
+	assert.True(t, strings.HasSuffix(transport.requests[0].URL.Path, "/rerank"))
+}
+
+func TestNewRerankerClient_InvalidCABundle(t *testing.T) {
+	_, err := NewRerankerClient(
+		WithProvider(Cohere), WithModel("rerank-english-v3.0"), WithCABundle("/does/not/exist.pem"),
+	)
+	assert.ErrorContains(t, err, "couldn't read CA bundle")
+}
+
+func TestRerankerMiddleware(t *testing.T) {
+	transport := &stubTransport{body: `{"id": "rerank-1", "results": [{"index": 0, "relevance_score": 0.9}], "meta": {}}`}
+	logs := new(strings.Builder)
+	queries := []string{}
+	lowercase := func(next Handler) Handler {
+		return func(ctx context.Context, request *types.RerankRequest) ([]types.RerankResult, error) {
+			queries = append(queries, request.Query)
+			mutated := *request
+			mutated.Query = strings.ToLower(request.Query)
+			return next(ctx, &mutated)
+		}
+	}
+	client, err := NewRerankerClient(
+		WithProvider(Cohere),
+		WithModel("rerank-english-v3.0"),
+		WithTransport(transport),
+		WithMiddleware(LoggingMiddleware(slog.New(slog.NewTextHandler(logs, nil))), CacheMiddleware(0), lowercase),
+	)
+	assert.NoError(t, err)
+
+	request := &types.RerankRequest{Query: "Login Button", Documents: []string{"<button>Log in</button>"}, TopN: 1}
+	for range 2 {
+		results, err := client.Rerank(context.Background(), request)
+		assert.NoError(t, err)
+		assert.Equal(t, []types.RerankResult{{Index: 0, Score: 0.9}}, results)
+	}
+
+	// The second request is served from the cache, before reaching the inner middleware
+	assert.Len(t, transport.requests, 1)
+	assert.Equal(t, []string{"Login Button"}, queries)
+	body, _ := io.ReadAll(transport.requests[0].Body)
+	assert.Contains(t, string(body), `"query":"login button"`)
+	assert.Equal(t, 2, strings.Count(logs.String(), "msg=rerank"))
+
+	t.Run("keys the cache on the model", func(t *testing.T) {
+		transport := &stubTransport{body: `{"id": "rerank-1", "results": [{"index": 0, "relevance_score": 0.9}], "meta": {}}`}
+		cache := CacheMiddleware(0)
+		for _, model := range []string{"rerank-english-v3.0", "rerank-multilingual-v3.0", "rerank-english-v3.0"} {
+			client, err := NewRerankerClient(
+				WithProvider(Cohere), WithModel(model), WithTransport(transport), WithMiddleware(cache),
+			)
+			assert.NoError(t, err)
+			_, err = client.Rerank(context.Background(), request)
+			assert.NoError(t, err)
+		}
+		assert.Len(t, transport.requests, 2)
+	})
+
+	t.Run("keys the cache on the options of the context", func(t *testing.T) {
+		transport := &stubTransport{body: `{"id": "rerank-1", "results": [{"index": 0, "relevance_score": 0.9}], "meta": {}}`}
+		client, err := NewRerankerClient(
+			WithProvider(Cohere), WithModel("rerank-english-v3.0"), WithTransport(transport),
+			WithMiddleware(CacheMiddleware(0)),
+		)
+		assert.NoError(t, err)
+		for _, ctx := range []context.Context{
+			context.Background(),
+			types.WithImageDetail(context.Background(), types.ImageDetailHigh),
+			types.WithPromptCachePrefixes(context.Background(), "Rank"),
+			types.WithPromptCachePrefixes(context.Background(), "Rank"),
+		} {
+			_, err = client.Rerank(ctx, request)
+			assert.NoError(t, err)
+		}
+		assert.Len(t, transport.requests, 3)
+	})
+}
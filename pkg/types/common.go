@@ -1,6 +1,7 @@
 package types
 
 import (
+	"context"
 	"image/color"
 	"math"
 )
@@ -33,3 +34,52 @@ type HighlightConfig struct {
 	// Resolution is the resolution to use for the screenshot. Defaults to 1280x800.
 	Resolution *Resolution
 }
+
+// ImageFormat defines the encoding of the images sent to the LLM.
+// WebP screenshots are decoded and re-encoded in the configured format like the others.
+type ImageFormat string
+
+const (
+	ImageFormatJPEG     ImageFormat = "jpeg"     // Re-encode as JPEG, smallest for screenshots with photos and gradients
+	ImageFormatPNG      ImageFormat = "png"      // Re-encode as lossless PNG
+	ImageFormatWebP     ImageFormat = "webp"     // Re-encode as lossless WebP, usually smaller than PNG
+	ImageFormatOriginal ImageFormat = "original" // Keep the encoding of the screenshot
+)
+
+// ImageDetail defines the level of detail of the images sent to the LLM.
+type ImageDetail string
+
+const (
+	ImageDetailAuto ImageDetail = "auto" // Optimal size for the provider of the LLM
+	ImageDetailLow  ImageDetail = "low"  // Fit in 512x512, cheapest but loses small elements
+	ImageDetailHigh ImageDetail = "high" // Original size, the provider may still scale it down
+)
+
+// imageDetailKey is the context key of the image detail.
+type imageDetailKey struct{}
+
+// WithImageDetail returns a copy of the context carrying the level of detail of the image of the completion
+// request, for the providers supporting it.
+func WithImageDetail(ctx context.Context, detail ImageDetail) context.Context {
+	return context.WithValue(ctx, imageDetailKey{}, detail)
+}
+
+// ContextImageDetail returns the level of detail set with WithImageDetail, or an empty string if there is none.
+func ContextImageDetail(ctx context.Context) ImageDetail {
+	detail, _ := ctx.Value(imageDetailKey{}).(ImageDetail)
+	return detail
+}
+
+// ImageOptions configures the preprocessing of screenshots before they are sent to the LLM.
+type ImageOptions struct {
+	// Format is the encoding of the image. Defaults to ImageFormatJPEG.
+	Format ImageFormat `json:"format"`
+	// Quality is the JPEG quality, from 1 to 100. Defaults to 85.
+	Quality int `json:"quality"`
+	// Detail is the level of detail, which determines the size of the image and is forwarded to the providers
+	// supporting it, like OpenAI. Defaults to ImageDetailAuto.
+	Detail ImageDetail `json:"detail"`
+	// MaxResolution bounds the size of the image, which is scaled down keeping its aspect ratio.
+	// Overrides the size determined by Detail when set.
+	MaxResolution *Resolution `json:"max_resolution"`
+}
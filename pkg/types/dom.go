@@ -1,6 +1,9 @@
 package types
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 type locatorType = string
 
@@ -50,3 +53,122 @@ type DOM struct {
 	RootElement *ElementSpec // The root element of the DOM
 	Metadata    *DOMMetadata // Metadata associated with the DOM
 }
+
+// fingerprintAttributes lists the attributes that are considered identifying for an element
+// across HTML (web) and XML (Android/iOS) sources.
+var fingerprintAttributes = []string{
+	"data-id",
+	"name",
+	"type",
+	"role",
+	"aria-label",
+	"placeholder",
+	"href",
+	"title",
+	"alt",
+	"resource-id",
+	"content-desc",
+	"accessibility-id",
+	"label",
+}
+
+// ElementFingerprint captures the identifying traits of a located element.
+// It is stored alongside cached locators to detect when a locator starts pointing to a different element.
+type ElementFingerprint struct {
+	TagName             string            `json:"tag_name"`             // Tag name of the element
+	Text                string            `json:"text"`                 // Visible text of the element
+	Attributes          map[string]string `json:"attributes"`           // Key attributes of the element
+	SupportedPrimitives string            `json:"supported_primitives"` // Value of the data-supported-primitives attribute
+}
+
+// NewElementFingerprint creates a fingerprint from the given element spec.
+func NewElementFingerprint(element *ElementSpec) *ElementFingerprint {
+	attributes := map[string]string{}
+	for _, name := range fingerprintAttributes {
+		if value, ok := element.Attributes[name]; ok && value != "" {
+			attributes[name] = value
+		}
+	}
+	return &ElementFingerprint{
+		TagName:             element.TagName,
+		Text:                strings.TrimSpace(element.Text),
+		Attributes:          attributes,
+		SupportedPrimitives: element.Attributes["data-supported-primitives"],
+	}
+}
+
+// Compare calculates how similar the fingerprint is to another one.
+//
+// Returns:
+//   - float64: Similarity score between 0 (completely different) and 1 (identical)
+//   - []string: Human readable descriptions of the traits that differ
+func (f *ElementFingerprint) Compare(other *ElementFingerprint) (float64, []string) {
+	mismatches := []string{}
+	score := 0.0
+
+	// Tag name carries the most weight, an element rarely changes its tag without changing its meaning.
+	if f.TagName == other.TagName {
+		score += 0.35
+	} else {
+		mismatches = append(mismatches, fmt.Sprintf("tag: '%s' != '%s'", f.TagName, other.TagName))
+	}
+
+	textScore := jaccardSimilarity(strings.Fields(strings.ToLower(f.Text)), strings.Fields(strings.ToLower(other.Text)))
+	if textScore < 1 {
+		mismatches = append(mismatches, fmt.Sprintf("text: '%s' != '%s'", f.Text, other.Text))
+	}
+	score += 0.25 * textScore
+
+	attributes, otherAttributes := []string{}, []string{}
+	for k, v := range f.Attributes {
+		attributes = append(attributes, k+"="+v)
+	}
+	for k, v := range other.Attributes {
+		otherAttributes = append(otherAttributes, k+"="+v)
+	}
+	attributesScore := jaccardSimilarity(attributes, otherAttributes)
+	if attributesScore < 1 {
+		for _, name := range fingerprintAttributes {
+			if f.Attributes[name] != other.Attributes[name] {
+				mismatches = append(mismatches, fmt.Sprintf(
+					"attribute %s: '%s' != '%s'", name, f.Attributes[name], other.Attributes[name],
+				))
+			}
+		}
+	}
+	score += 0.25 * attributesScore
+
+	if f.SupportedPrimitives == other.SupportedPrimitives {
+		score += 0.15
+	} else {
+		mismatches = append(mismatches, fmt.Sprintf(
+			"supported primitives: '%s' != '%s'", f.SupportedPrimitives, other.SupportedPrimitives,
+		))
+	}
+	return score, mismatches
+}
+
+// jaccardSimilarity returns the Jaccard index of two string sets. Two empty sets are considered identical.
+func jaccardSimilarity(a, b []string) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 1
+	}
+	set := map[string]bool{}
+	for _, item := range a {
+		set[item] = true
+	}
+	intersection, union := 0, len(set)
+	seen := map[string]bool{}
+	for _, item := range b {
+		if seen[item] {
+			continue
+		}
+		seen[item] = true
+		if set[item] {
+			intersection++
+		} else {
+			union++
+		}
+	}
+	return float64(intersection) / float64(union)
+}
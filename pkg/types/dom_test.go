@@ -0,0 +1,75 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestElementFingerprint_Compare(t *testing.T) {
+	base := &ElementSpec{
+		TagName: "button",
+		Text:    "Add to cart",
+		Attributes: map[string]string{
+			"data-id":                   "add-to-cart",
+			"type":                      "submit",
+			"class":                     "btn btn-primary",
+			"data-supported-primitives": "click",
+		},
+	}
+
+	tests := []struct {
+		name          string
+		other         *ElementSpec
+		minSimilarity float64
+		maxSimilarity float64
+	}{
+		{
+			name:          "identical element",
+			other:         base,
+			minSimilarity: 1,
+			maxSimilarity: 1,
+		},
+		{
+			name: "only non-key attributes changed",
+			other: &ElementSpec{
+				TagName: "button",
+				Text:    "Add to cart",
+				Attributes: map[string]string{
+					"data-id":                   "add-to-cart",
+					"type":                      "submit",
+					"class":                     "btn btn-secondary",
+					"data-supported-primitives": "click",
+				},
+			},
+			minSimilarity: 1,
+			maxSimilarity: 1,
+		},
+		{
+			name: "different element",
+			other: &ElementSpec{
+				TagName: "a",
+				Text:    "Privacy policy",
+				Attributes: map[string]string{
+					"href":                      "/privacy",
+					"data-supported-primitives": "click,hover",
+				},
+			},
+			minSimilarity: 0,
+			maxSimilarity: 0.2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			similarity, mismatches := NewElementFingerprint(base).Compare(NewElementFingerprint(tt.other))
+			assert.GreaterOrEqual(t, similarity, tt.minSimilarity)
+			assert.LessOrEqual(t, similarity, tt.maxSimilarity)
+			if similarity == 1 {
+				assert.Empty(t, mismatches)
+			} else {
+				assert.NotEmpty(t, mismatches)
+			}
+		})
+	}
+}
@@ -1,10 +1,45 @@
 package types
 
-import "context"
+import (
+	"context"
+)
 
 // LLMProvider is a string alias representing a language model provider.
 type LLMProvider = string
 
+// promptCachePrefixesKey is the context key of the prompt cache prefixes.
+type promptCachePrefixesKey struct{}
+
+// WithPromptCachePrefixes returns a copy of the context declaring prefixes of the prompt that should be cached
+// by providers supporting prompt caching, such as Anthropic and Bedrock. The prompt itself is left untouched,
+// so other clients and middlewares see it as is. Prefixes that don't start the prompt are ignored, so a
+// middleware rewriting the prompt only disables the caching.
+//
+// Parameters:
+//   - ctx: The context of the completion request
+//   - prefixes: The prompt prefixes to cache, e.g. the static instructions
+//
+// Returns:
+//   - context.Context: The context carrying the prefixes
+func WithPromptCachePrefixes(ctx context.Context, prefixes ...string) context.Context {
+	return context.WithValue(ctx, promptCachePrefixesKey{}, prefixes)
+}
+
+// PromptCachePrefixes returns the prompt prefixes declared with WithPromptCachePrefixes, if any.
+func PromptCachePrefixes(ctx context.Context) []string {
+	prefixes, _ := ctx.Value(promptCachePrefixesKey{}).([]string)
+	return prefixes
+}
+
+// CachePricing is the cost of the prompt cache tokens relative to the cost of the input tokens.
+type CachePricing struct {
+	WriteMultiplier float64 // Cost of cache writes relative to input tokens
+	ReadMultiplier  float64 // Cost of cache reads relative to input tokens
+}
+
+// AnthropicCachePricing is the prompt cache pricing of Anthropic, also applied by Bedrock.
+var AnthropicCachePricing = CachePricing{WriteMultiplier: 1.25, ReadMultiplier: 0.1}
+
 // LLMClientInterface defines the interface for a language model client required by locatr instance.
 type LLMClientInterface interface {
 
@@ -16,6 +51,17 @@ type LLMClientInterface interface {
 
 	// GetJSONCompletion returns the JSON completion for the given prompt.
 	GetJSONCompletion(ctx context.Context, prompt string, image []byte) (*JSONCompletion, error)
+
+	// GetStructuredCompletion returns the JSON completion for the given prompt, conforming to the given schema.
+	// Providers that can't enforce a schema fall back to a JSON completion.
+	GetStructuredCompletion(ctx context.Context, prompt string, image []byte, schema *JSONSchema) (*JSONCompletion, error)
+}
+
+// JSONSchema describes the JSON object expected from a structured completion.
+type JSONSchema struct {
+	Name        string         `json:"name"`        // Name of the output, made of letters, digits, underscores and dashes
+	Description string         `json:"description"` // Description of the output
+	Schema      map[string]any `json:"schema"`      // JSON schema of the output object
 }
 
 // LLMCompletionMeta contains metadata about a language model completion.
@@ -24,9 +70,102 @@ type LLMCompletionMeta struct {
 	OutputTokens int         `json:"output_tokens"` // Number of output tokens generated
 	Provider     LLMProvider `json:"llm_provider"`  // Provider of the language model
 	Model        string      `json:"llm_model"`     // Model used for the completion
+	Retries      int         `json:"retries"`       // Number of retried requests due to transient errors
+
+	// Number of input tokens written to the prompt cache, not included in InputTokens
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	// Number of input tokens read from the prompt cache, not included in InputTokens
+	CacheReadInputTokens int `json:"cache_read_input_tokens"`
+
+	// Token usage of each tier of a router client, in escalation order
+	Tiers []TierUsage `json:"tiers,omitempty"`
+	// Name of the router client tier whose response was returned last
+	AnsweringTier string `json:"answering_tier,omitempty"`
+}
+
+// TierUsage contains the token usage of a tier of a router client.
+type TierUsage struct {
+	Tier         string      `json:"tier"`          // Name of the tier
+	Provider     LLMProvider `json:"llm_provider"`  // Provider of the language model of the tier
+	Model        string      `json:"llm_model"`     // Model of the tier
+	Requests     int         `json:"requests"`      // Number of completions requested from the tier
+	InputTokens  int         `json:"input_tokens"`  // Number of input tokens used
+	OutputTokens int         `json:"output_tokens"` // Number of output tokens generated
+
+	// Number of input tokens written to the prompt cache, not included in InputTokens
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	// Number of input tokens read from the prompt cache, not included in InputTokens
+	CacheReadInputTokens int `json:"cache_read_input_tokens"`
+}
+
+// CalculateCost calculates the cost of the completions of the tier, with the prices of its model.
+// Cache tokens are charged with AnthropicCachePricing, like LLMCompletionMeta.CalculateCost.
+// Parameters:
+//   - costPer1MInputTokens: Cost per 1 million input tokens
+//   - costPer1MOutputTokens: Cost per 1 million output tokens
+//
+// Returns:
+//   - float64: Total cost of the completions of the tier
+func (u TierUsage) CalculateCost(costPer1MInputTokens, costPer1MOutputTokens float64) float64 {
+	return u.CalculateCostWithCachePricing(costPer1MInputTokens, costPer1MOutputTokens, AnthropicCachePricing)
+}
+
+// CalculateCostWithCachePricing calculates the cost of the completions of the tier with the given
+// prompt cache pricing.
+// Parameters:
+//   - costPer1MInputTokens: Cost per 1 million input tokens
+//   - costPer1MOutputTokens: Cost per 1 million output tokens
+//   - cachePricing: Cost of the cache writes and reads relative to the input tokens
+//
+// Returns:
+//   - float64: Total cost of the completions of the tier
+func (u TierUsage) CalculateCostWithCachePricing(
+	costPer1MInputTokens, costPer1MOutputTokens float64, cachePricing CachePricing,
+) float64 {
+	usage := LLMCompletionMeta{
+		InputTokens:              u.InputTokens,
+		OutputTokens:             u.OutputTokens,
+		CacheCreationInputTokens: u.CacheCreationInputTokens,
+		CacheReadInputTokens:     u.CacheReadInputTokens,
+	}
+	return usage.CalculateCostWithCachePricing(costPer1MInputTokens, costPer1MOutputTokens, cachePricing)
+}
+
+// Accumulate adds the token usage and retries of another completion to this one.
+// The usage of router client tiers is merged by tier name, and the answering tier is replaced if set.
+// Parameters:
+//   - other: The completion metadata to add
+func (c *LLMCompletionMeta) Accumulate(other LLMCompletionMeta) {
+	c.InputTokens += other.InputTokens
+	c.OutputTokens += other.OutputTokens
+	c.Retries += other.Retries
+	c.CacheCreationInputTokens += other.CacheCreationInputTokens
+	c.CacheReadInputTokens += other.CacheReadInputTokens
+	for _, usage := range other.Tiers {
+		merged := false
+		for i := range c.Tiers {
+			if c.Tiers[i].Tier == usage.Tier {
+				c.Tiers[i].Requests += usage.Requests
+				c.Tiers[i].InputTokens += usage.InputTokens
+				c.Tiers[i].OutputTokens += usage.OutputTokens
+				c.Tiers[i].CacheCreationInputTokens += usage.CacheCreationInputTokens
+				c.Tiers[i].CacheReadInputTokens += usage.CacheReadInputTokens
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			c.Tiers = append(c.Tiers, usage)
+		}
+	}
+	if other.AnsweringTier != "" {
+		c.AnsweringTier = other.AnsweringTier
+	}
 }
 
 // CalculateCost calculates the cost of the completion.
+// Cache tokens are charged with AnthropicCachePricing, as Anthropic and Bedrock are the only providers reporting
+// them. Use CalculateCostWithCachePricing for another pricing.
 // Parameters:
 //   - costPer1MInputTokens: Cost per 1 million input tokens
 //   - costPer1MOutputTokens: Cost per 1 million output tokens
@@ -34,7 +173,23 @@ type LLMCompletionMeta struct {
 // Returns:
 //   - float64: Total cost of the completion
 func (c LLMCompletionMeta) CalculateCost(costPer1MInputTokens, costPer1MOutputTokens float64) float64 {
+	return c.CalculateCostWithCachePricing(costPer1MInputTokens, costPer1MOutputTokens, AnthropicCachePricing)
+}
+
+// CalculateCostWithCachePricing calculates the cost of the completion with the given prompt cache pricing.
+// Parameters:
+//   - costPer1MInputTokens: Cost per 1 million input tokens
+//   - costPer1MOutputTokens: Cost per 1 million output tokens
+//   - cachePricing: Cost of the cache writes and reads relative to the input tokens
+//
+// Returns:
+//   - float64: Total cost of the completion
+func (c LLMCompletionMeta) CalculateCostWithCachePricing(
+	costPer1MInputTokens, costPer1MOutputTokens float64, cachePricing CachePricing,
+) float64 {
 	inputCost := (float64(c.InputTokens) / 1000000.0) * costPer1MInputTokens
+	inputCost += (float64(c.CacheCreationInputTokens) / 1000000.0) * costPer1MInputTokens * cachePricing.WriteMultiplier
+	inputCost += (float64(c.CacheReadInputTokens) / 1000000.0) * costPer1MInputTokens * cachePricing.ReadMultiplier
 	outputCost := (float64(c.OutputTokens) / 1000000.0) * costPer1MOutputTokens
 	return inputCost + outputCost
 }
@@ -44,3 +199,25 @@ type JSONCompletion struct {
 	JSON string `json:"json_value"` // The JSON content of the completion
 	LLMCompletionMeta
 }
+
+// Tokenizer estimates the number of tokens of a text for a language model.
+type Tokenizer interface {
+	// CountTokens returns the number of tokens of the given text.
+	CountTokens(text string) int
+}
+
+// TieredLLMClient is implemented by LLM clients spreading completions over the clients of several tiers,
+// like router clients. Prompts and images are sized for the smallest limits of the tiers.
+type TieredLLMClient interface {
+	LLMClientInterface
+
+	// GetTierClients returns the clients of the tiers.
+	GetTierClients() []LLMClientInterface
+}
+
+// ModelCapabilities describes the limits and features of a language model.
+type ModelCapabilities struct {
+	ContextWindow   int  `json:"context_window"`    // Maximum number of input and output tokens
+	MaxOutputTokens int  `json:"max_output_tokens"` // Maximum number of output tokens
+	Vision          bool `json:"vision"`            // Whether the model accepts images
+}
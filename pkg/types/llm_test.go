@@ -0,0 +1,62 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLLMCompletionMeta_CalculateCost(t *testing.T) {
+	meta := LLMCompletionMeta{
+		InputTokens:              1000000,
+		OutputTokens:             100000,
+		CacheCreationInputTokens: 2000000,
+		CacheReadInputTokens:     10000000,
+	}
+	// 3 for input, 1.5 for output, 2 * 3 * 1.25 for cache writes and 10 * 3 * 0.1 for cache reads
+	assert.InDelta(t, 3+1.5+7.5+3, meta.CalculateCost(3, 15), 1e-9)
+	// 2 * 3 * 0.5 for cache writes and 10 * 3 * 0.5 for cache reads
+	assert.InDelta(
+		t, 3+1.5+3+15,
+		meta.CalculateCostWithCachePricing(3, 15, CachePricing{WriteMultiplier: 0.5, ReadMultiplier: 0.5}), 1e-9,
+	)
+
+	other := LLMCompletionMeta{InputTokens: 1, CacheCreationInputTokens: 2, CacheReadInputTokens: 3}
+	meta.Accumulate(other)
+	assert.Equal(t, 2000002, meta.CacheCreationInputTokens)
+	assert.Equal(t, 10000003, meta.CacheReadInputTokens)
+}
+
+func TestLLMCompletionMeta_AccumulateTiers(t *testing.T) {
+	meta := LLMCompletionMeta{}
+	meta.Accumulate(LLMCompletionMeta{
+		InputTokens: 30,
+		Tiers: []TierUsage{
+			{Tier: "cheap", Requests: 1, InputTokens: 10, OutputTokens: 1},
+			{Tier: "strong", Requests: 1, InputTokens: 20, OutputTokens: 2, CacheReadInputTokens: 100},
+		},
+		AnsweringTier: "strong",
+	})
+	meta.Accumulate(LLMCompletionMeta{
+		InputTokens:   10,
+		Tiers:         []TierUsage{{Tier: "cheap", Requests: 1, InputTokens: 10, OutputTokens: 1, CacheCreationInputTokens: 4}},
+		AnsweringTier: "cheap",
+	})
+	meta.Accumulate(LLMCompletionMeta{InputTokens: 5})
+
+	assert.Equal(t, 45, meta.InputTokens)
+	assert.Equal(t, []TierUsage{
+		{Tier: "cheap", Requests: 2, InputTokens: 20, OutputTokens: 2, CacheCreationInputTokens: 4},
+		{Tier: "strong", Requests: 1, InputTokens: 20, OutputTokens: 2, CacheReadInputTokens: 100},
+	}, meta.Tiers)
+	assert.Equal(t, "cheap", meta.AnsweringTier)
+}
+
+func TestTierUsage_CalculateCost(t *testing.T) {
+	usage := TierUsage{
+		Tier: "strong", InputTokens: 1000000, OutputTokens: 100000, CacheCreationInputTokens: 2000000,
+		CacheReadInputTokens: 10000000,
+	}
+	assert.InDelta(t, 3+1.5+7.5+3, usage.CalculateCost(3, 15), 1e-9)
+	assert.InDelta(t, 3+1.5, usage.CalculateCostWithCachePricing(3, 15, CachePricing{}), 1e-9)
+}
@@ -3,13 +3,22 @@ package types
 import (
 	"context"
 	"log/slog"
+	"time"
 )
 
 // CacheEntry represents a cache entry for storing locator information.
 type CacheEntry struct {
-	UserRequest string      `json:"user_request"` // User's request or query name
-	Locators    []string    `json:"locators"`     // List of locators associated with the request
-	LocatorType locatorType `json:"locator_type"` // Type of locator used
+	UserRequest string              `json:"user_request"`          // User's request or query name
+	Locators    []string            `json:"locators"`              // List of locators associated with the request
+	LocatorType locatorType         `json:"locator_type"`          // Type of locator used
+	Fingerprint *ElementFingerprint `json:"fingerprint,omitempty"` // Fingerprint of the located element
+	CreatedAt   *time.Time          `json:"created_at,omitempty"`  // Time at which the entry was created
+}
+
+// CacheDrift describes how much a cached element differs from the live element its locator resolves to.
+type CacheDrift struct {
+	Similarity float64  `json:"similarity"` // Similarity score between 0 and 1
+	Mismatches []string `json:"mismatches"` // Traits of the element that changed
 }
 
 // LocatrCompletion represents the completion result of Locate method.
@@ -17,6 +26,15 @@ type LocatrCompletion struct {
 	Locators    []string    `json:"locators"`     // List of locators found, all of them point to the same element
 	LocatorType locatorType `json:"locator_type"` // Type of locators in the list
 	CacheHit    bool        `json:"cache_hit"`    // Indicates if the result was a cache hit
+	// CacheDrift is set when a cached locator resolved to an element that no longer matches its fingerprint
+	CacheDrift *CacheDrift `json:"cache_drift,omitempty"`
+	// CacheRefreshed is set when the element was located again after a CacheDrift and the cache entry was replaced
+	CacheRefreshed bool `json:"cache_refreshed"`
+	// DegradedRanking is set when the reranker failed and the chunks were ranked with a local heuristic instead
+	DegradedRanking bool `json:"degraded_ranking"`
+	// RerankUsage is the token usage of the reranker when it uses a language model, like the LLM reranker.
+	// It isn't included in the LLMCompletionMeta of the completion, which is the usage of the mode
+	RerankUsage *LLMCompletionMeta `json:"rerank_usage,omitempty"`
 	LLMCompletionMeta
 }
 
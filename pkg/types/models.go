@@ -0,0 +1,178 @@
+package types
+
+import (
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// DefaultMaxCompletionTokens is the maximum number of tokens requested for a completion, which is plenty
+// for the JSON responses of locatr.
+const DefaultMaxCompletionTokens = 1024
+
+var (
+	modelCapabilitiesMu sync.RWMutex
+	// modelCapabilities maps model name prefixes to the capabilities of the models
+	modelCapabilities = map[string]ModelCapabilities{
+		// Anthropic, also served by Bedrock and OpenRouter
+		"claude-3-haiku":    {ContextWindow: 200_000, MaxOutputTokens: 4_096, Vision: true},
+		"claude-3-sonnet":   {ContextWindow: 200_000, MaxOutputTokens: 4_096, Vision: true},
+		"claude-3-opus":     {ContextWindow: 200_000, MaxOutputTokens: 4_096, Vision: true},
+		"claude-3-5-haiku":  {ContextWindow: 200_000, MaxOutputTokens: 8_192, Vision: true},
+		"claude-3-5-sonnet": {ContextWindow: 200_000, MaxOutputTokens: 8_192, Vision: true},
+		"claude-3-7-sonnet": {ContextWindow: 200_000, MaxOutputTokens: 64_000, Vision: true},
+		"claude-sonnet-4":   {ContextWindow: 200_000, MaxOutputTokens: 64_000, Vision: true},
+		"claude-opus-4":     {ContextWindow: 200_000, MaxOutputTokens: 32_000, Vision: true},
+		"claude-3.5-haiku":  {ContextWindow: 200_000, MaxOutputTokens: 8_192, Vision: true},  // OpenRouter naming
+		"claude-3.5-sonnet": {ContextWindow: 200_000, MaxOutputTokens: 8_192, Vision: true},  // OpenRouter naming
+		"claude-3.7-sonnet": {ContextWindow: 200_000, MaxOutputTokens: 64_000, Vision: true}, // OpenRouter naming
+		// OpenAI, also served by Azure OpenAI
+		"gpt-3.5-turbo": {ContextWindow: 16_385, MaxOutputTokens: 4_096},
+		"gpt-4":         {ContextWindow: 8_192, MaxOutputTokens: 8_192},
+		"gpt-4-turbo":   {ContextWindow: 128_000, MaxOutputTokens: 4_096, Vision: true},
+		"gpt-4o":        {ContextWindow: 128_000, MaxOutputTokens: 16_384, Vision: true},
+		"gpt-4.1":       {ContextWindow: 1_047_576, MaxOutputTokens: 32_768, Vision: true},
+		"o1":            {ContextWindow: 200_000, MaxOutputTokens: 100_000, Vision: true},
+		"o1-mini":       {ContextWindow: 128_000, MaxOutputTokens: 65_536},
+		"o3-mini":       {ContextWindow: 200_000, MaxOutputTokens: 100_000},
+		// Gemini
+		"gemini-1.5-flash": {ContextWindow: 1_048_576, MaxOutputTokens: 8_192, Vision: true},
+		"gemini-1.5-pro":   {ContextWindow: 2_097_152, MaxOutputTokens: 8_192, Vision: true},
+		"gemini-2.0-flash": {ContextWindow: 1_048_576, MaxOutputTokens: 8_192, Vision: true},
+		"gemini-2.5-pro":   {ContextWindow: 1_048_576, MaxOutputTokens: 65_536, Vision: true},
+		// Open models, served by Groq, OpenRouter and self-hosted servers
+		"llama-3.1":     {ContextWindow: 131_072, MaxOutputTokens: 8_192},
+		"llama-3.3":     {ContextWindow: 131_072, MaxOutputTokens: 32_768},
+		"llama3.2":      {ContextWindow: 131_072, MaxOutputTokens: 8_192},
+		"llama3.3":      {ContextWindow: 131_072, MaxOutputTokens: 8_192},
+		"deepseek-r1":   {ContextWindow: 65_536, MaxOutputTokens: 8_192},
+		"deepseek-v3":   {ContextWindow: 65_536, MaxOutputTokens: 8_192},
+		"qwen2.5":       {ContextWindow: 32_768, MaxOutputTokens: 8_192},
+		"mistral-large": {ContextWindow: 131_072, MaxOutputTokens: 8_192},
+	}
+)
+
+// CompletionTokens returns the maximum number of tokens to request for a completion of the model,
+// DefaultMaxCompletionTokens bounded by the maximum output of the model when it's known.
+func (c ModelCapabilities) CompletionTokens() int {
+	if c.MaxOutputTokens > 0 {
+		return min(DefaultMaxCompletionTokens, c.MaxOutputTokens)
+	}
+	return DefaultMaxCompletionTokens
+}
+
+// RegisterModelCapabilities registers the capabilities of the models whose name starts with the given prefix,
+// replacing any previous registration of the prefix.
+//
+// Parameters:
+//   - prefix: The prefix of the model names, e.g. "gpt-4o"
+//   - capabilities: The capabilities of the models
+func RegisterModelCapabilities(prefix string, capabilities ModelCapabilities) {
+	modelCapabilitiesMu.Lock()
+	defer modelCapabilitiesMu.Unlock()
+	modelCapabilities[prefix] = capabilities
+}
+
+// LookupModelCapabilities returns the capabilities of the given model, matched by the longest registered prefix.
+// Provider namespaces are ignored, so "us.anthropic.claude-3-5-sonnet-20241022-v2:0" (Bedrock)
+// and "anthropic/claude-3-5-sonnet" (OpenRouter) match "claude-3-5-sonnet".
+//
+// Parameters:
+//   - model: The model name
+//
+// Returns:
+//   - ModelCapabilities: The capabilities of the model
+//   - bool: Whether the model is known
+func LookupModelCapabilities(model string) (ModelCapabilities, bool) {
+	model = strings.ToLower(model)
+	names := []string{model}
+	for i, r := range model {
+		if r == '.' || r == '/' {
+			names = append(names, model[i+1:])
+		}
+	}
+
+	modelCapabilitiesMu.RLock()
+	defer modelCapabilitiesMu.RUnlock()
+	var capabilities ModelCapabilities
+	matched := ""
+	for _, name := range names {
+		for prefix, candidate := range modelCapabilities {
+			if len(prefix) > len(matched) && strings.HasPrefix(name, prefix) {
+				capabilities, matched = candidate, prefix
+			}
+		}
+	}
+	return capabilities, matched != ""
+}
+
+// LookupClientCapabilities returns the capabilities of the model of the given client. The capabilities of a
+// TieredLLMClient are the smallest limits of its tiers, supporting images only if all of them do.
+//
+// Parameters:
+//   - client: The LLM client
+//
+// Returns:
+//   - ModelCapabilities: The capabilities of the model, or of the tiers of the client
+//   - bool: Whether the model, or the model of every tier, is known
+func LookupClientCapabilities(client LLMClientInterface) (ModelCapabilities, bool) {
+	tiered, ok := client.(TieredLLMClient)
+	if !ok {
+		return LookupModelCapabilities(client.GetModel())
+	}
+	var capabilities ModelCapabilities
+	for i, tier := range tiered.GetTierClients() {
+		tierCapabilities, known := LookupClientCapabilities(tier)
+		if !known {
+			return ModelCapabilities{}, false
+		}
+		if i == 0 {
+			capabilities = tierCapabilities
+			continue
+		}
+		capabilities.ContextWindow = min(capabilities.ContextWindow, tierCapabilities.ContextWindow)
+		capabilities.MaxOutputTokens = min(capabilities.MaxOutputTokens, tierCapabilities.MaxOutputTokens)
+		capabilities.Vision = capabilities.Vision && tierCapabilities.Vision
+	}
+	return capabilities, true
+}
+
+// ClientProviders returns the providers the given client sends requests to, those of all its tiers
+// for a TieredLLMClient.
+func ClientProviders(client LLMClientInterface) []LLMProvider {
+	tiered, ok := client.(TieredLLMClient)
+	if !ok {
+		return []LLMProvider{client.GetProvider()}
+	}
+	providers := []LLMProvider{}
+	for _, tier := range tiered.GetTierClients() {
+		providers = append(providers, ClientProviders(tier)...)
+	}
+	return providers
+}
+
+// HeuristicTokenizer estimates the number of tokens of a text without a model specific vocabulary.
+// Words count as one token per five letters or digits, punctuation as one token per character and
+// ideographs as one token each, which slightly overestimates the count of BPE tokenizers on HTML.
+type HeuristicTokenizer struct{}
+
+// CountTokens returns the estimated number of tokens of the given text.
+func (HeuristicTokenizer) CountTokens(text string) int {
+	tokens, word := 0, 0
+	for _, r := range text {
+		switch {
+		case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul):
+			tokens += (word+4)/5 + 1
+			word = 0
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			word++
+		default:
+			tokens += (word + 4) / 5
+			word = 0
+			if !unicode.IsSpace(r) {
+				tokens++
+			}
+		}
+	}
+	return tokens + (word+4)/5
+}
@@ -0,0 +1,120 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookupModelCapabilities(t *testing.T) {
+	tests := []struct {
+		model         string
+		known         bool
+		contextWindow int
+	}{
+		{model: "claude-3-5-sonnet-latest", known: true, contextWindow: 200_000},
+		{model: "us.anthropic.claude-3-5-sonnet-20241022-v2:0", known: true, contextWindow: 200_000},
+		{model: "anthropic/claude-3.5-sonnet", known: true, contextWindow: 200_000},
+		{model: "gpt-4o-mini", known: true, contextWindow: 128_000},
+		{model: "gpt-4-0613", known: true, contextWindow: 8_192},
+		{model: "Gemini-2.0-Flash", known: true, contextWindow: 1_048_576},
+		{model: "my-finetune", known: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			capabilities, known := LookupModelCapabilities(tt.model)
+			assert.Equal(t, tt.known, known)
+			assert.Equal(t, tt.contextWindow, capabilities.ContextWindow)
+		})
+	}
+
+	RegisterModelCapabilities("my-finetune", ModelCapabilities{ContextWindow: 32_000})
+	t.Cleanup(func() {
+		modelCapabilitiesMu.Lock()
+		delete(modelCapabilities, "my-finetune")
+		modelCapabilitiesMu.Unlock()
+	})
+	capabilities, known := LookupModelCapabilities("my-finetune-v2")
+	assert.True(t, known)
+	assert.Equal(t, 32_000, capabilities.ContextWindow)
+}
+
+func TestModelCapabilities_CompletionTokens(t *testing.T) {
+	assert.Equal(t, DefaultMaxCompletionTokens, ModelCapabilities{}.CompletionTokens())
+	assert.Equal(t, DefaultMaxCompletionTokens, ModelCapabilities{MaxOutputTokens: 8_192}.CompletionTokens())
+	assert.Equal(t, 512, ModelCapabilities{MaxOutputTokens: 512}.CompletionTokens())
+}
+
+func TestHeuristicTokenizer(t *testing.T) {
+	tokenizer := HeuristicTokenizer{}
+	assert.Equal(t, 0, tokenizer.CountTokens(""))
+	assert.Equal(t, 2, tokenizer.CountTokens("hello world"))
+	assert.Equal(t, 3, tokenizer.CountTokens("internationalization"[:12]))
+	// <, butto, n, id, =, ", submi, t, ", >
+	assert.Equal(t, 10, tokenizer.CountTokens(`<button id="submit">`))
+	assert.Equal(t, 2, tokenizer.CountTokens("登录"))
+}
+
+// stubClient is an LLM client of a fixed provider and model.
+type stubClient struct {
+	LLMClientInterface
+	provider LLMProvider
+	model    string
+}
+
+func (c *stubClient) GetProvider() LLMProvider { return c.provider }
+
+func (c *stubClient) GetModel() string { return c.model }
+
+// stubTieredClient is an LLM client with tiers.
+type stubTieredClient struct {
+	*stubClient
+	tiers []LLMClientInterface
+}
+
+func (c *stubTieredClient) GetTierClients() []LLMClientInterface { return c.tiers }
+
+func TestLookupClientCapabilities(t *testing.T) {
+	mini := &stubClient{provider: "openai", model: "gpt-4o-mini"}
+	haiku := &stubClient{provider: "anthropic", model: "claude-3-5-haiku-latest"}
+	llama := &stubClient{provider: "groq", model: "llama-3.3-70b-versatile"}
+	tests := []struct {
+		name              string
+		client            LLMClientInterface
+		expected          ModelCapabilities
+		expectedKnown     bool
+		expectedProviders []LLMProvider
+	}{
+		{
+			name:              "single model",
+			client:            haiku,
+			expected:          ModelCapabilities{ContextWindow: 200_000, MaxOutputTokens: 8_192, Vision: true},
+			expectedKnown:     true,
+			expectedProviders: []LLMProvider{"anthropic"},
+		},
+		{
+			name:              "smallest limits of the tiers",
+			client:            &stubTieredClient{stubClient: haiku, tiers: []LLMClientInterface{haiku, mini, llama}},
+			expected:          ModelCapabilities{ContextWindow: 128_000, MaxOutputTokens: 8_192},
+			expectedKnown:     true,
+			expectedProviders: []LLMProvider{"anthropic", "openai", "groq"},
+		},
+		{
+			name: "unknown tier",
+			client: &stubTieredClient{
+				stubClient: mini, tiers: []LLMClientInterface{mini, &stubClient{provider: "openai", model: "my-finetune"}},
+			},
+			expectedProviders: []LLMProvider{"openai", "openai"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			capabilities, known := LookupClientCapabilities(tt.client)
+			assert.Equal(t, tt.expectedKnown, known)
+			assert.Equal(t, tt.expected, capabilities)
+			assert.Equal(t, tt.expectedProviders, ClientProviders(tt.client))
+		})
+	}
+}
@@ -38,3 +38,10 @@ type PluginInterface interface {
 	// GetElementLocation retrieves the point and scroll position of the element identified by the given locator.
 	GetElementLocation(ctx context.Context, locator string) (*Location, error)
 }
+
+// DOMVersionProvider is implemented by plugins that can cheaply tell whether the DOM changed.
+type DOMVersionProvider interface {
+
+	// GetDOMVersion returns an opaque version that changes whenever the DOM of the current context changes.
+	GetDOMVersion(ctx context.Context) (string, error)
+}
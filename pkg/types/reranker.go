@@ -1,10 +1,24 @@
 package types
 
-import "context"
+import (
+	"context"
+	"sync"
+)
 
 // RerankerProvider represents the provider of the reranker.
 type RerankerProvider string
 
+// RerankerFailurePolicy decides how a mode handles a failure of the reranker.
+type RerankerFailurePolicy string
+
+const (
+	// RerankerFailureFallback ranks the chunks with a local heuristic and carries on, flagging the completion
+	// with DegradedRanking. It is the policy of the zero value.
+	RerankerFailureFallback RerankerFailurePolicy = "fallback"
+	// RerankerFailureAbort fails the request with the error of the reranker.
+	RerankerFailureAbort RerankerFailurePolicy = "abort"
+)
+
 // RerankRequest represents a request to re-rank a set of documents based on a query.
 type RerankRequest struct {
 	Query     string   // The query string used for re-ranking
@@ -22,3 +36,40 @@ type RerankResult struct {
 type RerankerClientInterface interface {
 	Rerank(ctx context.Context, request *RerankRequest) ([]RerankResult, error) // Re-rank documents based on the request
 }
+
+// rerankUsageKey is the context key of the token usage collected from the rerank requests.
+type rerankUsageKey struct{}
+
+// rerankUsage is the token usage collected from the rerank requests, whose batches may run concurrently.
+type rerankUsage struct {
+	mu    sync.Mutex
+	usage *LLMCompletionMeta
+}
+
+// WithRerankUsage returns a copy of the context collecting the token usage of the rerank requests made with it,
+// for rerankers spending tokens like the LLM reranker.
+//
+// Parameters:
+//   - ctx: The context of the rerank request
+//   - usage: The usage the token usage of the rerank requests is added to
+//
+// Returns:
+//   - context.Context: The context collecting the usage
+func WithRerankUsage(ctx context.Context, usage *LLMCompletionMeta) context.Context {
+	return context.WithValue(ctx, rerankUsageKey{}, &rerankUsage{usage: usage})
+}
+
+// AddRerankUsage adds the token usage of a completion made by a reranker to the usage collected by the context,
+// if any (see WithRerankUsage). The provider and model of the first completion are recorded.
+func AddRerankUsage(ctx context.Context, usage LLMCompletionMeta) {
+	collector, ok := ctx.Value(rerankUsageKey{}).(*rerankUsage)
+	if !ok {
+		return
+	}
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	if collector.usage.Model == "" {
+		collector.usage.Provider, collector.usage.Model = usage.Provider, usage.Model
+	}
+	collector.usage.Accumulate(usage)
+}